@@ -0,0 +1,78 @@
+package main
+
+import "fmt"
+
+// addApproxLen appends an ApproxLen method that returns the number of keys of the internal
+// maps in constant time, instead of ranging over the map like the len helper. The internal
+// maps keep the deleted entries until the dirty map is rebuilt, and the result may be more
+// than the number of entries. The maps of -simple and -readonly-after-init have no deleted
+// entries, and their result is exact.
+func (g *Generator) addApproxLen() {
+	fields := g.mapFields()
+	want := []string{"mu", "read", "dirty"}
+	if g.Simple || g.Frozen {
+		want = []string{"mu", "m"}
+	}
+	for _, name := range want {
+		expect(fields[name] != nil, "approxlen: unsupported map layout. missing field: %s", name)
+	}
+	switch {
+	case g.Simple:
+		g.appendSource([]byte(fmt.Sprintf(`
+// ApproxLen returns the number of entries in the map, in constant time. Unlike the
+// ApproxLen of the maps that specialize sync.Map, the result is exact.
+func (m *%s) ApproxLen() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.m)
+}
+`, g.Name)))
+		return
+	case g.Frozen:
+		g.appendSource([]byte(fmt.Sprintf(`
+// ApproxLen returns the number of entries in the map, in constant time. Unlike the
+// ApproxLen of the maps that specialize sync.Map, the result is exact.
+func (m *%s) ApproxLen() int {
+	if m.frozen {
+		return len(m.m)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.m)
+}
+`, g.Name)))
+		return
+	}
+	readOnly := g.renames()["readOnly"]
+	load := fmt.Sprintf("read, _ := m.read.Load().(%s)", readOnly)
+	reload := fmt.Sprintf("read, _ = m.read.Load().(%s)", readOnly)
+	if g.readLayout("approxlen", fields) {
+		load = fmt.Sprintf("var read %s\n\tif p := m.read.Load(); p != nil {\n\t\tread = *p\n\t}", readOnly)
+		// The read-only map of the promoted dirty map is never nil.
+		reload = "read = *m.read.Load()"
+	}
+	g.appendSource([]byte(fmt.Sprintf(`
+// ApproxLen returns the approximate number of entries in the map, in constant time.
+// It counts the keys of the internal maps, which keep the deleted entries until the
+// map is rebuilt on the next store of a new key after a promotion of the dirty map.
+// Hence, the result is never less than the number of entries, and it may be more
+// for maps with many deletes. Use the len helper for an exact result.
+//
+// ApproxLen takes no lock if the read-only map holds all the keys.
+func (m *%[1]s) ApproxLen() int {
+	%[2]s
+	if !read.amended {
+		return len(read.m)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.dirty == nil {
+		// The dirty map was promoted meanwhile.
+		%[3]s
+		return len(read.m)
+	}
+	// The dirty map holds all the entries that are not expunged.
+	return len(m.dirty)
+}
+`, g.Name, load, reload)))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// benchOps are the benchmarked operations, in the order they are reported.
+var benchOps = []string{"Store", "Load", "LoadOrStore", "Range"}
+
+// benchResult is the result of a benchmark of an operation of a variant.
+type benchResult struct {
+	ns     float64 // nanoseconds per operation.
+	allocs string  // allocations per operation.
+}
+
+// Bench generates the map described by the command-line arguments (without the program
+// name and the command) into a throwaway module, together with a type-safe wrapper of
+// sync.Map of the same types, and benchmarks their Store, Load, LoadOrStore and Range
+// methods with go test. The comparison is written to w. The -benchtime flag is passed to
+// go test. The keys of the benchmarks are converted from ints, and the key type must be a
+// string or a number type. Types that are declared in the output package are not
+// supported, as the module has only the generated files.
+func Bench(w io.Writer, args []string) (err error) {
+	defer catch(&err)
+	fs := flag.NewFlagSet("syncmap bench", flag.ContinueOnError)
+	benchtime := fs.String("benchtime", "1s", "Run each benchmark for `duration`, or for Nx iterations. See go help testflag.")
+	c, err := ParseConfig(fs, args)
+	check(err, "bench")
+	expect(!c.Set, "bench: -set is not supported")
+	expect(!c.Generic, "bench: -generic is not supported")
+	dir, err := ioutil.TempDir("", "syncmap_bench")
+	check(err, "bench: create module")
+	defer os.RemoveAll(dir)
+	_, err = goCmd(dir, "mod", "init", "syncmapbench")
+	check(err, "bench: create module")
+	c.Pkg, c.Out, c.Verify = "bench", filepath.Join(dir, "map.go"), false
+	g, err := NewGenerator(c)
+	check(err, "bench")
+	conv := g.benchKey()
+	err = g.Mutate()
+	check(err, "bench")
+	err = g.Gen()
+	check(err, "bench")
+	b := bytes.NewBuffer(nil)
+	err = benchTmpl.Execute(b, map[string]interface{}{
+		"Name":  g.Name,
+		"Key":   g.key,
+		"Value": g.value,
+		"Conv":  conv,
+		"Ops":   benchOps,
+	})
+	check(err, "execute bench template")
+	g.write(filepath.Join(dir, "bench_test.go"), b.Bytes())
+	fmt.Fprintf(w, "benchmarking %s and a wrapper of sync.Map (-benchtime %s)\n\n", g.Spec, *benchtime)
+	out, err := goCmd(dir, "test", "-run", "^$", "-bench", ".", "-benchmem", "-benchtime", *benchtime)
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		expect(false, "bench: go test failed:\n%s", out)
+	}
+	check(err, "bench: run go test")
+	results := parseBench(out)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "op\t%s\tsync.Map wrapper\tdelta\n", g.Name)
+	for _, op := range benchOps {
+		s, ok1 := results[op+"/specialized"]
+		r, ok2 := results[op+"/wrapped"]
+		expect(ok1 && ok2, "bench: missing results of %s in the output of go test:\n%s", op, out)
+		fmt.Fprintf(tw, "%s\t%.1f ns/op, %s allocs/op\t%.1f ns/op, %s allocs/op\t%+.0f%%\n", op, s.ns, s.allocs, r.ns, r.allocs, (s.ns-r.ns)/r.ns*100)
+	}
+	return tw.Flush()
+}
+
+// benchKey returns the format of the conversion of an int (named i) to the key type, for
+// the keys of the benchmarks. The imports of the conversion are resolved by goimports.
+func (g *Generator) benchKey() string {
+	id, ok := g.underlying(g.mapType.Key).(*ast.Ident)
+	expect(ok, "bench: unsupported key type %s. expected a string or a number type", g.key)
+	switch id.Name {
+	case "string":
+		return g.key + "(strconv.Itoa(i))"
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"float32", "float64", "byte", "rune":
+		return g.key + "(i)"
+	}
+	expect(false, "bench: unsupported key type %s. expected a string or a number type", g.key)
+	return ""
+}
+
+// parseBench returns the results of the benchmarks in the output of go test -benchmem,
+// keyed by their names without the Benchmark prefix and the GOMAXPROCS suffix, e.g.
+// "Load/specialized".
+func parseBench(out string) map[string]benchResult {
+	results := make(map[string]benchResult)
+	for _, l := range strings.Split(out, "\n") {
+		f := strings.Fields(l)
+		if len(f) < 8 || !strings.HasPrefix(f[0], "Benchmark") || f[3] != "ns/op" || f[7] != "allocs/op" {
+			continue
+		}
+		name := strings.TrimPrefix(f[0], "Benchmark")
+		if i := strings.LastIndexByte(name, '-'); i > 0 {
+			name = name[:i]
+		}
+		ns, err := strconv.ParseFloat(f[2], 64)
+		check(err, "bench: parse %q", l)
+		results[name] = benchResult{ns: ns, allocs: f[6]}
+	}
+	return results
+}
+
+var benchTmpl = template.Must(template.New("bench").Parse(`package bench
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// wrappedMap is a type-safe wrapper of sync.Map, whose methods convert the keys and the
+// values to and from interface{}.
+type wrappedMap struct {
+	m sync.Map
+}
+
+func (w *wrappedMap) Load(key {{.Key}}) (value {{.Value}}, ok bool) {
+	v, ok := w.m.Load(key)
+	if !ok {
+		return value, false
+	}
+	return v.({{.Value}}), true
+}
+
+func (w *wrappedMap) Store(key {{.Key}}, value {{.Value}}) {
+	w.m.Store(key, value)
+}
+
+func (w *wrappedMap) LoadOrStore(key {{.Key}}, value {{.Value}}) (actual {{.Value}}, loaded bool) {
+	v, loaded := w.m.LoadOrStore(key, value)
+	return v.({{.Value}}), loaded
+}
+
+func (w *wrappedMap) Range(f func(key {{.Key}}, value {{.Value}}) bool) {
+	w.m.Range(func(key, value interface{}) bool {
+		return f(key.({{.Key}}), value.({{.Value}}))
+	})
+}
+
+var (
+	benchKeys = func() []{{.Key}} {
+		keys := make([]{{.Key}}, 1024)
+		for i := range keys {
+			keys[i] = {{.Conv}}
+		}
+		return keys
+	}()
+	benchValue {{.Value}}
+)
+{{range $op := .Ops}}
+func Benchmark{{$op}}(b *testing.B) {
+	b.Run("specialized", func(b *testing.B) {
+		bench{{$op}}(b, new({{$.Name}}))
+	})
+	b.Run("wrapped", func(b *testing.B) {
+		bench{{$op}}(b, new(wrappedMap))
+	})
+}
+{{end}}
+type benchMap interface {
+	Load(key {{.Key}}) (value {{.Value}}, ok bool)
+	Store(key {{.Key}}, value {{.Value}})
+	LoadOrStore(key {{.Key}}, value {{.Value}}) (actual {{.Value}}, loaded bool)
+	Range(f func(key {{.Key}}, value {{.Value}}) bool)
+}
+
+func fill(m benchMap) {
+	for _, key := range benchKeys {
+		m.Store(key, benchValue)
+	}
+}
+
+func benchStore(b *testing.B, m benchMap) {
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			m.Store(benchKeys[i%len(benchKeys)], benchValue)
+		}
+	})
+}
+
+func benchLoad(b *testing.B, m benchMap) {
+	fill(m)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			m.Load(benchKeys[i%len(benchKeys)])
+		}
+	})
+}
+
+func benchLoadOrStore(b *testing.B, m benchMap) {
+	fill(m)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			m.LoadOrStore(benchKeys[i%len(benchKeys)], benchValue)
+		}
+	})
+}
+
+func benchRange(b *testing.B, m benchMap) {
+	fill(m)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Range(func(_ {{.Key}}, _ {{.Value}}) bool {
+			return true
+		})
+	}
+}
+`))
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"sort"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// warnBestEffort reports a declaration of sync/map.go that -best-effort could not mutate
+// as the supported versions of sync/map.go.
+func (g *Generator) warnBestEffort(format string, args ...interface{}) {
+	fmt.Fprintf(g.stderr, "syncmap: warning: best-effort: "+format+"\n", args...)
+}
+
+// handle calls the handler of a declaration of sync/map.go. With -best-effort, a failure of
+// the handler, e.g. on a declaration whose shape changed, is reported as a warning, and the
+// declaration is substituted as the unrecognized ones.
+func (g *Generator) handle(kind, name string, d ast.Node, handler func()) {
+	if !g.BestEffort {
+		handler()
+		return
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			err, ok := e.(genError)
+			if !ok {
+				err = genError{fmt.Sprint(e)}
+			}
+			g.warnBestEffort("the handler of %s %s failed: %s. its types are substituted heuristically", kind, name, err.msg)
+			g.substitute(d)
+		}
+	}()
+	handler()
+}
+
+// skipUnrecognized reports a declaration that has no handler, and substitutes its types.
+func (g *Generator) skipUnrecognized(kind, name string, d ast.Node) {
+	g.warnBestEffort("unrecognized %s %s. its types are substituted heuristically, and its code is not adapted", kind, name)
+	g.substitute(d)
+}
+
+// warnMissing reports the handled declarations that are missing from sync/map.go.
+func (g *Generator) warnMissing() {
+	var missing []string
+	for name := range g.funcs {
+		missing = append(missing, "func "+name)
+	}
+	for name := range g.types {
+		missing = append(missing, "type "+name)
+	}
+	for name := range g.values {
+		missing = append(missing, "value "+name)
+	}
+	sort.Strings(missing)
+	for _, m := range missing {
+		g.warnBestEffort("missing %s, which is declared by the supported versions of sync/map.go", m)
+	}
+}
+
+// substitute replaces the interface{} (or any) types of a declaration that has no handler
+// with the key and the value types of the map, heuristically: the keys of the map types
+// and the fields named key or k get the key type, and the other ones get the value type.
+// The fields that declare several names of these types are split, e.g. "key, value any".
+func (g *Generator) substitute(n ast.Node) {
+	astutil.Apply(n, func(c *astutil.Cursor) bool {
+		switch n := c.Node().(type) {
+		case *ast.FieldList:
+			var list []*ast.Field
+			for _, f := range n.List {
+				if len(f.Names) < 2 || !isEmptyIface(f.Type) {
+					list = append(list, f)
+					continue
+				}
+				for _, id := range f.Names {
+					list = append(list, &ast.Field{Names: []*ast.Ident{id}, Type: expr("interface{}", f.Type.Pos())})
+				}
+			}
+			n.List = list
+		case *ast.Field:
+			if len(n.Names) == 1 && (n.Names[0].Name == "key" || n.Names[0].Name == "k") && isEmptyIface(n.Type) {
+				n.Type = expr(g.key, n.Type.Pos())
+			}
+		case *ast.MapType:
+			if isEmptyIface(n.Key) {
+				n.Key = expr(g.key, n.Key.Pos())
+			}
+		case ast.Expr:
+			if _, ok := c.Parent().(*ast.SelectorExpr); !ok && isEmptyIface(n) {
+				c.Replace(expr(g.value, n.Pos()))
+			}
+		}
+		return true
+	}, nil)
+}
+
+// isEmptyIface reports whether the expression is the empty interface type, i.e. interface{}
+// or any.
+func isEmptyIface(x ast.Expr) bool {
+	switch t := x.(type) {
+	case *ast.InterfaceType:
+		return len(t.Methods.List) == 0
+	case *ast.Ident:
+		return t.Name == "any" && t.Obj == nil
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"text/template"
+)
+
+// binaryKind is the encoding of a map type by the binary helper.
+type binaryKind int
+
+const (
+	binaryNone      binaryKind = iota // zero-size types, e.g. the struct{} values of sets.
+	binaryFixed                       // fixed-size numbers and booleans.
+	binaryInt                         // int, uint and uintptr, written as 64-bit numbers.
+	binaryString                      // strings, written as length-prefixed bytes.
+	binaryMarshaler                   // encoding.BinaryMarshaler, written as length-prefixed bytes.
+)
+
+// binaryKindOf returns the encoding of the given map type by the binary helper. The types
+// that are not numbers, booleans or strings must implement encoding.BinaryMarshaler, and
+// their pointers encoding.BinaryUnmarshaler. This can not be checked for the types of
+// other packages, and it is asserted by the generated code instead. The local types that
+// have a MarshalBinary method are encoded with it, even if they are numbers or strings.
+func (g *Generator) binaryKindOf(kind, name string, x ast.Expr) binaryKind {
+	expect(g.typeParam(name) == nil, "binary helper: %s type %s is a type parameter and its encoding is unknown", kind, name)
+	if id, ok := x.(*ast.Ident); ok {
+		if _, ok := g.localMethods(id.Name)["MarshalBinary"]; ok {
+			return binaryMarshaler
+		}
+	}
+	switch t := g.underlying(x).(type) {
+	case *ast.Ident:
+		obj, ok := types.Universe.Lookup(t.Name).(*types.TypeName)
+		if !ok {
+			return binaryMarshaler
+		}
+		b, ok := obj.Type().(*types.Basic)
+		if !ok {
+			// The error interface.
+			return binaryMarshaler
+		}
+		switch {
+		case b.Kind() == types.Int || b.Kind() == types.Uint || b.Kind() == types.Uintptr:
+			return binaryInt
+		case b.Info()&types.IsString != 0:
+			return binaryString
+		case b.Info()&(types.IsNumeric|types.IsBoolean) != 0:
+			return binaryFixed
+		}
+	case *ast.StructType:
+		if len(t.Fields.List) == 0 {
+			return binaryNone
+		}
+	case *ast.FuncType, *ast.ChanType, *ast.MapType:
+		expect(false, "binary helper: %s type %s can not be encoded. expected a number, a boolean, a string, "+
+			"or a type that implements encoding.BinaryMarshaler", kind, name)
+	}
+	return binaryMarshaler
+}
+
+// checkBinary fails if the key or the value type can not be encoded by the binary helper.
+func (g *Generator) checkBinary() {
+	g.binaryKindOf("key", g.key, g.mapType.Key)
+	g.binaryKindOf("value", g.value, g.mapType.Value)
+}
+
+// binaryData returns the data of the binary helper template, and the imports of the
+// generated codecs of the key and the value types.
+func (g *Generator) binaryData(d helperData) (interface{}, []string) {
+	var (
+		codecs  = bytes.NewBuffer(nil)
+		imports []string
+		bytesIO bool
+	)
+	for _, t := range []struct {
+		kind, name, param string
+		expr              ast.Expr
+	}{
+		{"key", g.key, "Key", g.mapType.Key},
+		{"value", g.value, "Value", g.mapType.Value},
+	} {
+		k := g.binaryKindOf(t.kind, t.name, t.expr)
+		c := binaryCodec{Name: d.Name, Method: t.param, Type: t.name, Var: t.kind}
+		switch k {
+		case binaryNone:
+			c.Write, c.Read = "return nil", fmt.Sprintf("return %s, nil", t.kind)
+		case binaryFixed:
+			c.Write = fmt.Sprintf("return binary.Write(w, binary.LittleEndian, %s)", t.kind)
+			c.Read = fmt.Sprintf("err = binary.Read(r, binary.LittleEndian, &%[1]s)\n\treturn %[1]s, err", t.kind)
+		case binaryInt:
+			c.Write = fmt.Sprintf("return binary.Write(w, binary.LittleEndian, int64(%s))", t.kind)
+			c.Read = fmt.Sprintf("var n int64\n\terr = binary.Read(r, binary.LittleEndian, &n)\n\treturn %s(n), err", t.name)
+		case binaryString:
+			c.Write = fmt.Sprintf("return m.writeBinaryBytes(w, []byte(%s))", t.kind)
+			c.Read = fmt.Sprintf("b, err := m.readBinaryBytes(r)\n\treturn %s(b), err", t.name)
+			bytesIO = true
+		case binaryMarshaler:
+			c.Assert = true
+			c.Write = fmt.Sprintf("b, err := %s.MarshalBinary()\n\tif err != nil {\n\t\treturn err\n\t}\n\treturn m.writeBinaryBytes(w, b)", t.kind)
+			alloc := ""
+			if s, ok := t.expr.(*ast.StarExpr); ok {
+				// The values are decoded into new variables.
+				c.Pointer = true
+				alloc = fmt.Sprintf("%s = new(%s)\n\t", t.kind, types.ExprString(s.X))
+			}
+			c.Read = fmt.Sprintf("b, err := m.readBinaryBytes(r)\n\tif err != nil {\n\t\treturn %[1]s, err\n\t}\n\t%[2]serr = %[1]s.UnmarshalBinary(b)\n\treturn %[1]s, err", t.kind, alloc)
+			bytesIO = true
+			imports = append(imports, "encoding")
+		}
+		err := binaryCodecTmpl.Execute(codecs, c)
+		check(err, "execute binary codec template")
+	}
+	return struct {
+		helperData
+		Codecs string
+		Bytes  bool
+	}{d, codecs.String(), bytesIO}, imports
+}
+
+// binaryCodec is the data of the codec template of a map type.
+type binaryCodec struct {
+	Name        string // struct name.
+	Method      string // suffix of the codec methods, i.e. Key or Value.
+	Type        string // the map type.
+	Var         string // the name of the encoded variable.
+	Write, Read string // the bodies of the codec methods.
+	Assert      bool   // assert that the type implements the marshaler interfaces.
+	Pointer     bool   // the type is a pointer, and its values are decoded into new variables.
+}
+
+var binaryCodecTmpl = template.Must(template.New("binarycodec").Parse(`
+{{- if .Assert}}
+// The {{.Var}} type is encoded by the binary helper with its MarshalBinary method, and
+// decoded with the UnmarshalBinary method of
+{{- if .Pointer}} its values{{else}} a pointer to it{{end}}.
+var (
+	_ encoding.BinaryMarshaler   = *new({{.Type}})
+	_ encoding.BinaryUnmarshaler = {{if .Pointer}}*new({{.Type}}){{else}}new({{.Type}}){{end}}
+)
+{{end}}
+// writeBinary{{.Method}} writes a {{.Var}} of the map to w, for WriteBinary.
+func (m *{{.Name}}) writeBinary{{.Method}}(w *bufio.Writer, {{.Var}} {{.Type}}) error {
+	{{.Write}}
+}
+
+// readBinary{{.Method}} reads a {{.Var}} of the map from r, for ReadBinary.
+func (m *{{.Name}}) readBinary{{.Method}}(r *bufio.Reader) ({{.Var}} {{.Type}}, err error) {
+	{{.Read}}
+}
+`))
+
+// binaryHelper is the source of the binary helper. The codecs of the key and the value
+// types are generated by binaryData.
+const binaryHelper = `
+// WriteBinary writes the entries of the map to w in a compact binary format, that is read
+// by ReadBinary. Each entry is written as its key followed by its value. The numbers are
+// written in little-endian byte order, int and uint as 64-bit numbers, and the strings
+// and the encodings of encoding.BinaryMarshaler are prefixed by their length as a uvarint.
+// The entries are written during a single Range, in no particular order.
+func (m *{{.Name}}) WriteBinary(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		if err = m.writeBinaryKey(bw, key); err == nil {
+			err = m.writeBinaryValue(bw, value)
+		}
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ReadBinary reads the entries written by WriteBinary from r until EOF, and stores them
+// in the map, in addition to the existing ones. r is read ahead, and it should contain
+// only the entries.
+func (m *{{.Name}}) ReadBinary(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			return nil
+		}
+		key, err := m.readBinaryKey(br)
+		var value {{.Value}}
+		if err == nil {
+			value, err = m.readBinaryValue(br)
+		}
+		if err == io.EOF {
+			// The entry is truncated.
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		m.{{.Store}}(key, value)
+	}
+}
+{{.Codecs}}
+{{- if .Bytes}}
+// writeBinaryBytes writes b to w, prefixed by its length as a uvarint.
+func (m *{{.Name}}) writeBinaryBytes(w *bufio.Writer, b []byte) error {
+	var n [binary.MaxVarintLen64]byte
+	if _, err := w.Write(n[:binary.PutUvarint(n[:], uint64(len(b)))]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readBinaryBytes reads bytes that were written by writeBinaryBytes from r.
+func (m *{{.Name}}) readBinaryBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+{{- end}}
+`
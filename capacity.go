@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+)
+
+// addCapacity appends a constructor that pre-sizes the map that holds the new entries,
+// i.e. the dirty map, or the plain map of -simple and -readonly-after-init. The type of
+// the made map is taken from the map struct, in order to follow the renamed entry type
+// and the layout of the sync/map.go it was generated from.
+func (g *Generator) addCapacity() {
+	fields := g.mapFields()
+	field := "dirty"
+	if g.Simple || g.Frozen {
+		field = "m"
+	}
+	for _, name := range []string{"mu", field} {
+		expect(fields[name] != nil, "capacity: unsupported map layout. missing field: %s", name)
+	}
+	b := bytes.NewBuffer(nil)
+	err := format.Node(b, g.fset, fields[field])
+	check(err, "format %s field type", field)
+	g.appendSource([]byte(fmt.Sprintf(`
+// %[1]s returns an empty %[2]s whose internal map is pre-sized for n entries.
+// It saves the rehashing of the map when it is bulk-loaded, and its approximate size
+// is known upfront.
+func %[1]s(n int) *%[2]s {
+	m := new(%[2]s)
+	m.mu.Lock()
+	m.%[3]s = make(%[4]s, n)
+	m.mu.Unlock()
+	return m
+}
+`, g.capacityFunc(), g.Name, field, b)))
+}
+
+// capacityFunc returns the name of the constructor generated by -capacity.
+func (g *Generator) capacityFunc() string {
+	return "New" + g.Name + "WithCapacity"
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"text/template"
+)
+
+// addCompareAndSwap appends a CompareAndSwap method, like the one of sync.Map in Go 1.20,
+// for maps generated from a sync/map.go that predates it. The values are compared with ==.
+func (g *Generator) addCompareAndSwap() {
+	names := g.renames()
+	data := map[string]interface{}{
+		"Name":     g.Name,
+		"Key":      g.key,
+		"Value":    g.value,
+		"Entry":    names["entry"],
+		"ReadOnly": names["readOnly"],
+		"Expunged": names["expunged"],
+	}
+	t := casTmpl
+	switch {
+	case g.Simple || g.Frozen:
+		t = casLockedTmpl
+		data["Frozen"] = g.Frozen
+	case g.Inline:
+		t = casInlineTmpl
+		g.checkLayout("compare and swap")
+	case g.Slab:
+		t = casSlabTmpl
+		g.checkLayout("compare and swap")
+	default:
+		g.checkLayout("compare and swap")
+	}
+	b := bytes.NewBuffer(nil)
+	err := t.Execute(b, data)
+	check(err, "execute compare and swap template")
+	g.appendSource(b.Bytes())
+}
+
+// checkLayout fails if the layout of the map is not the one the templates of the given
+// feature expect: a read-only map stored in an atomic.Value, and a dirty map, as up to
+// Go 1.19.
+func (g *Generator) checkLayout(feature string) {
+	fields := g.mapFields()
+	for _, name := range []string{"mu", "read", "dirty", "misses"} {
+		expect(fields[name] != nil, "%s: unsupported map layout. missing field: %s", feature, name)
+	}
+	t, ok := fields["read"].(*ast.SelectorExpr)
+	expect(ok && t.Sel.Name == "Value", "%s: unsupported type of the read field", feature)
+}
+
+// casMethod is the CompareAndSwap method of the maps that have read and dirty maps of entries.
+const casMethod = `
+// CompareAndSwap swaps the old and new values for key if the value stored in the map is
+// equal to old. The swapped result reports whether the swap was performed.
+func (m *{{.Name}}) CompareAndSwap(key {{.Key}}, old, new {{.Value}}) (swapped bool) {
+	read, _ := m.read.Load().({{.ReadOnly}})
+	if e, ok := read.m[key]; ok {
+		return e.tryCompareAndSwap(old, new)
+	} else if !read.amended {
+		return false // No existing value for key.
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	read, _ = m.read.Load().({{.ReadOnly}})
+	if e, ok := read.m[key]; ok {
+		swapped = e.tryCompareAndSwap(old, new)
+	} else if e, ok := m.dirty[key]; ok {
+		swapped = e.tryCompareAndSwap(old, new)
+		// We needed to lock mu in order to load the entry for key,
+		// and the operation didn't change the set of keys in the map
+		// (so it would be made more efficient by promoting the dirty
+		// map to read-only).
+		// Count it as a miss so that we will eventually switch to the
+		// more efficient steady state.
+		m.missLocked()
+	}
+	return swapped
+}
+`
+
+var casTmpl = template.Must(template.New("cas").Parse(casMethod + `
+// tryCompareAndSwap compare the entry with the given old value and swaps
+// it with a new value if the entry is equal to the old value, and the entry
+// has not been expunged.
+//
+// If the entry is expunged, tryCompareAndSwap returns false and leaves
+// the entry unchanged.
+func (e *{{.Entry}}) tryCompareAndSwap(old, new {{.Value}}) bool {
+	p := atomic.LoadPointer(&e.p)
+	if p == nil || p == {{.Expunged}} || *(*{{.Value}})(p) != old {
+		return false
+	}
+
+	// Copy the value after the first load to make this method more amenable
+	// to escape analysis: if the comparison fails from the start, we shouldn't
+	// bother heap-allocating a value to store.
+	nc := new
+	for {
+		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(&nc)) {
+			return true
+		}
+		p = atomic.LoadPointer(&e.p)
+		if p == nil || p == {{.Expunged}} || *(*{{.Value}})(p) != old {
+			return false
+		}
+	}
+}
+`))
+
+var casInlineTmpl = template.Must(template.New("cas").Parse(casMethod + `
+// tryCompareAndSwap swaps the value of the entry with a new value if it is equal to
+// the given old value. An entry that holds no value, or that has been expunged, is
+// left unchanged.
+func (e *{{.Entry}}) tryCompareAndSwap(old, new {{.Value}}) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.ok || e.v != old {
+		return false
+	}
+	e.v = new
+	return true
+}
+`))
+
+var casSlabTmpl = template.Must(template.New("cas").Parse(casMethod + `
+// tryCompareAndSwap swaps the value of the entry with a new value if it is equal to
+// the given old value. An entry that holds no value, or that has been expunged, is
+// left unchanged.
+func (e *{{.Entry}}) tryCompareAndSwap(old, new {{.Value}}) bool {
+	var h int64 // the handle of new, allocated on the first attempt to swap it.
+	for {
+		p := atomic.LoadInt64(&e.p)
+		if p == 0 || p == {{.Expunged}} {
+			break
+		}
+		v, ok := e.s.load(p)
+		if !ok {
+			continue
+		}
+		if v != old {
+			break
+		}
+		if h == 0 {
+			h = e.s.alloc(new)
+		}
+		if atomic.CompareAndSwapInt64(&e.p, p, h) {
+			e.s.release(p)
+			return true
+		}
+	}
+	if h != 0 {
+		e.s.release(h)
+	}
+	return false
+}
+`))
+
+var casLockedTmpl = template.Must(template.New("cas").Parse(`
+// CompareAndSwap swaps the old and new values for key if the value stored in the map is
+// equal to old. The swapped result reports whether the swap was performed.
+{{- if .Frozen}} It panics if
+// the map is frozen and the value is equal to old.
+{{- end}}
+func (m *{{.Name}}) CompareAndSwap(key {{.Key}}, old, new {{.Value}}) (swapped bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if value, ok := m.m[key]; !ok || value != old {
+		return false
+	}
+	{{- if .Frozen}}
+	m.checkWrite()
+	{{- end}}
+	m.m[key] = new
+	return true
+}
+`))
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+)
+
+// addClear appends a Clear method that deletes all entries of the map at once, instead of
+// ranging over it. The method is synthesized from the fields of the map struct, in order to
+// follow the layout of the sync/map.go it was generated from.
+func (g *Generator) addClear() {
+	fields := g.mapFields()
+	want := []string{"mu", "read", "dirty", "misses"}
+	if g.Simple {
+		want = []string{"mu", "m"}
+	}
+	for _, name := range want {
+		expect(fields[name] != nil, "clear: unsupported map layout. missing field: %s", name)
+	}
+	if g.Simple {
+		g.appendSource([]byte(fmt.Sprintf(`
+// Clear deletes all the entries, resulting in an empty map.
+func (m *%s) Clear() {
+	m.mu.Lock()
+	m.m = nil
+	m.mu.Unlock()
+}
+`, g.Name)))
+		return
+	}
+	readOnly := g.renames()["readOnly"] + "{}"
+	if g.readLayout("clear", fields) {
+		readOnly = "&" + readOnly
+	}
+	var slab string
+	if g.Slab {
+		// The entries of the cleared maps may still be updated by the methods that
+		// loaded them, and keep the previous slab.
+		slab = "\n\tm.slab = nil"
+	}
+	g.appendSource([]byte(fmt.Sprintf(`
+// Clear deletes all the entries, resulting in an empty map.
+func (m *%s) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.read.Store(%s)
+	m.dirty = nil
+	m.misses = 0%s
+}
+`, g.Name, readOnly, slab)))
+}
+
+// readLayout reports whether the read-only map is stored in an atomic.Pointer, as since
+// Go 1.20, given the fields of the map struct. It is stored in an atomic.Value up to
+// Go 1.19, and the other types of the read field fail the given feature.
+func (g *Generator) readLayout(feature string, fields map[string]ast.Expr) (pointer bool) {
+	switch t := fields["read"].(type) {
+	case *ast.SelectorExpr:
+		expect(t.Sel.Name == "Value", "%s: unsupported type of the read field: %s", feature, t.Sel.Name)
+		return false
+	case *ast.IndexExpr:
+		return true
+	default:
+		expect(false, "%s: unsupported type of the read field: %T", feature, t)
+		return false
+	}
+}
+
+// mapFields returns the types of the map struct fields by their names.
+func (g *Generator) mapFields() map[string]ast.Expr {
+	fields := make(map[string]ast.Expr)
+	for _, d := range g.file.Decls {
+		d, ok := d.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+			for _, f := range t.Type.(*ast.StructType).Fields.List {
+				for _, n := range f.Names {
+					fields[n.Name] = f.Type
+				}
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,70 @@
+package main
+
+import "fmt"
+
+// addCompact appends a Compact method that rebuilds the internal maps of the map without
+// their deleted entries. Go maps do not shrink, and therefore a map that grew large and was
+// mostly deleted keeps its memory until it is compacted. Like Clear, the method is
+// synthesized from the fields of the map struct.
+func (g *Generator) addCompact() {
+	fields := g.mapFields()
+	want := []string{"mu", "read", "dirty", "misses"}
+	if g.Simple {
+		want = []string{"mu", "m"}
+	}
+	for _, name := range want {
+		expect(fields[name] != nil, "compact: unsupported map layout. missing field: %s", name)
+	}
+	if g.Simple {
+		g.appendSource([]byte(fmt.Sprintf(`
+// Compact rebuilds the map, in order to release the memory of the deleted entries.
+// It runs in linear time, and blocks the other methods of the map meanwhile.
+func (m *%s) Compact() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.m == nil {
+		return
+	}
+	compact := make(map[%s]%s, len(m.m))
+	for key, value := range m.m {
+		compact[key] = value
+	}
+	m.m = compact
+}
+`, g.Name, g.key, g.value)))
+		return
+	}
+	readOnly := g.renames()["readOnly"]
+	load := fmt.Sprintf("read, _ := m.read.Load().(%s)", readOnly)
+	store := fmt.Sprintf("m.read.Store(%s{m: compact})", readOnly)
+	if g.readLayout("compact", fields) {
+		load = fmt.Sprintf("var read %s\n\tif p := m.read.Load(); p != nil {\n\t\tread = *p\n\t}", readOnly)
+		store = fmt.Sprintf("m.read.Store(&%s{m: compact})", readOnly)
+	}
+	g.appendSource([]byte(fmt.Sprintf(`
+// Compact rebuilds the internal maps of the map without the deleted entries, in order
+// to release their memory. It runs in linear time, and blocks the writes of new keys
+// meanwhile. Loads, and stores of existing keys, are not blocked.
+func (m *%[1]s) Compact() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	%[2]s
+	src := read.m
+	if read.amended {
+		// The dirty map holds all the entries that are not expunged.
+		src = m.dirty
+	}
+	compact := make(map[%[3]s]*%[4]s, len(src))
+	for key, e := range src {
+		// Deleted entries are expunged before they are dropped, so that stores
+		// that found them in the previous read-only map do not update them.
+		if !e.tryExpungeLocked() {
+			compact[key] = e
+		}
+	}
+	%[5]s
+	m.dirty = nil
+	m.misses = 0
+}
+`, g.Name, load, g.key, g.renames()["entry"], store)))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"strings"
+	"text/template"
+)
+
+// addCompute appends the LoadOrCompute method, that makes the map a read-through cache.
+// The calls of compute in flight are registered by key next to the map, so that the
+// concurrent misses of a key wait for a single call. The method is appended after the
+// methods are wrapped by the other options, and it stores the computed values with the
+// exported LoadOrStore, e.g. in the insertion order of -ordered.
+func (g *Generator) addCompute() {
+	call := g.computeType()
+	for _, d := range g.file.Decls {
+		if d, ok := d.(*ast.GenDecl); ok {
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				appendFields(t.Type.(*ast.StructType), fmt.Sprintf("computeMu sync.Mutex; computing map[%s]*%s", g.key, call))
+			}
+		}
+	}
+	g.addImport(g.file, "", "sync")
+	b := bytes.NewBuffer(nil)
+	err := computeTmpl.Execute(b, struct {
+		helperData
+		Call string
+	}{g.helperData(), call})
+	check(err, "execute compute template")
+	g.appendSource(b.Bytes())
+}
+
+// computeType returns the name of the type of the calls of compute generated by -compute.
+func (g *Generator) computeType() string {
+	return "computeCall" + strings.Title(g.Name)
+}
+
+var computeTmpl = template.Must(template.New("compute").Parse(`
+// A {{.Call}} is a call of the compute function of {{.Name}}.LoadOrCompute that is in
+// flight. Its results are set before done is closed.
+type {{.Call}} struct {
+	done     chan struct{}
+	value    {{.Value}}
+	err      error
+	returned bool // compute returned, and did not panic.
+}
+
+// LoadOrCompute returns the existing value for the key if present. Otherwise, it calls
+// compute with the key, and stores and returns the value it returns.
+//
+// The calls of LoadOrCompute that miss the same key concurrently call compute once: one
+// of them calls it, and the others wait for it and return its results. If compute
+// returns an error, no value is stored, and the waiting calls return the error as well.
+// The next call of LoadOrCompute for the key calls compute again. If compute panics, the
+// panic is propagated to its caller, and one of the waiting calls calls compute again.
+//
+// The map is not locked while compute runs, and it may call the methods of the map, but
+// not LoadOrCompute with the same key. If a value is stored for the key by another method
+// while compute runs, that value is kept and returned instead of the computed one.
+func (m *{{.Name}}) LoadOrCompute(key {{.Key}}, compute func({{.Key}}) ({{.Value}}, error)) ({{.Value}}, error) {
+	for {
+		if value, ok := m.{{.Load}}(key); ok {
+			return value, nil
+		}
+		m.computeMu.Lock()
+		if c, ok := m.computing[key]; ok {
+			m.computeMu.Unlock()
+			<-c.done
+			if c.returned {
+				return c.value, c.err
+			}
+			continue
+		}
+		// The value may have been stored by a call of compute that completed after
+		// the load above. Its value is stored before it is unregistered.
+		if value, ok := m.{{.Load}}(key); ok {
+			m.computeMu.Unlock()
+			return value, nil
+		}
+		if m.computing == nil {
+			m.computing = make(map[{{.Key}}]*{{.Call}})
+		}
+		c := &{{.Call}}{done: make(chan struct{})}
+		m.computing[key] = c
+		m.computeMu.Unlock()
+		return m.runCompute(key, c, compute)
+	}
+}
+
+// runCompute calls compute for the key, stores the value it returns, and sets the results
+// of c for the calls of LoadOrCompute that wait for it.
+func (m *{{.Name}}) runCompute(key {{.Key}}, c *{{.Call}}, compute func({{.Key}}) ({{.Value}}, error)) ({{.Value}}, error) {
+	defer func() {
+		m.computeMu.Lock()
+		delete(m.computing, key)
+		m.computeMu.Unlock()
+		close(c.done)
+	}()
+	value, err := compute(key)
+	if err == nil {
+		value, _ = m.{{.LoadOrStore}}(key, value)
+	}
+	c.value, c.err, c.returned = value, err, true
+	return value, err
+}
+`))
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"text/template"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// addCounter adds a counter of the keys to the map struct, and appends the Len method that
+// reads it in constant time. The counter is updated by the transitions of the entries
+// between holding a value and not: the stores of the entries that held no value, the new
+// entries of Store, the LoadOrStore calls that stored the value, and the LoadAndDelete calls
+// that deleted it. Delete is rewritten to call LoadAndDelete, as it does since Go 1.15. The
+// entry methods of Store are replaced by methods that count the stores of the deleted
+// entries. The maps of -simple and -readonly-after-init have no deleted entries, and their
+// Len returns the size of their map instead.
+func (g *Generator) addCounter() {
+	kind := "map"
+	if g.Set {
+		kind = "set"
+	}
+	if g.Simple || g.Frozen {
+		var frozen string
+		if g.Frozen {
+			frozen = "\n\tif m.frozen {\n\t\treturn len(m.m)\n\t}"
+		}
+		g.appendSource([]byte(fmt.Sprintf(`
+// Len returns the number of keys in the %[2]s, in constant time.
+func (m *%[1]s) Len() int {%[3]s
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.m)
+}
+`, g.Name, kind, frozen)))
+		return
+	}
+	g.checkLayout("counter")
+	names := g.renames()
+	entry, newEntry := names["entry"], names["newEntry"]
+	var stored bool
+	removeDecls(g.file, func(d ast.Decl) bool {
+		f, ok := d.(*ast.FuncDecl)
+		if !ok || f.Recv == nil || (f.Name.Name != "tryStore" && f.Name.Name != "storeLocked") {
+			return false
+		}
+		star, ok := f.Recv.List[0].Type.(*ast.StarExpr)
+		if !ok {
+			return false
+		}
+		id, ok := star.X.(*ast.Ident)
+		return ok && id.Name == entry
+	})
+	for _, d := range g.file.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				// The counter is placed first to guarantee the 64-bit alignment
+				// required by the atomic operations on 32-bit platforms.
+				prependFields(t.Type.(*ast.StructType), "length int64")
+			}
+		case *ast.FuncDecl:
+			if !g.isMethod(d) {
+				continue
+			}
+			m := d.Recv.List[0].Names[0].Name
+			switch d.Name.Name {
+			case "Store":
+				g.countStores(d, m, newEntry)
+				stored = true
+			case "LoadOrStore":
+				loaded := lastResult(d)
+				prepend(d, fmt.Sprintf(`defer func() {
+					if !%[2]s {
+						atomic.AddInt64(&%[1]s.length, 1)
+					}
+				}()`, m, loaded))
+			case "LoadAndDelete":
+				loaded := lastResult(d)
+				prepend(d, fmt.Sprintf(`defer func() {
+					if %[2]s {
+						atomic.AddInt64(&%[1]s.length, -1)
+					}
+				}()`, m, loaded))
+			case "Delete":
+				if !calls(d, m, "LoadAndDelete") {
+					g.logf("rewriting Delete of %s to call LoadAndDelete", g.Name)
+					key := d.Type.Params.List[0].Names[0].Name
+					d.Body.List = stmts(fmt.Sprintf("%s.LoadAndDelete(%s)", m, key), d.Body.Lbrace)
+				}
+			case "Clear":
+				// The writes that race with Clear may update the entries of the
+				// cleared maps, and they are counted although they are lost.
+				d.Body.List = append(d.Body.List, stmts(fmt.Sprintf("atomic.StoreInt64(&%s.length, 0)", m), d.Body.Rbrace)...)
+			}
+		}
+	}
+	expect(stored, "counter: missing Store method")
+	t := counterTmpl
+	switch {
+	case g.Inline:
+		t = counterInlineTmpl
+	case g.Slab:
+		t = counterSlabTmpl
+	}
+	b := bytes.NewBuffer(nil)
+	err := t.Execute(b, map[string]string{
+		"Name":     g.Name,
+		"Kind":     kind,
+		"Value":    g.value,
+		"Entry":    entry,
+		"Expunged": names["expunged"],
+	})
+	check(err, "execute counter template")
+	g.appendSource(b.Bytes())
+}
+
+// countStores passes the counter of the map to the entry methods that Store calls, and
+// counts the new entries that Store adds to the dirty map.
+func (g *Generator) countStores(f *ast.FuncDecl, m, newEntry string) {
+	astutil.Apply(f.Body, func(c *astutil.Cursor) bool {
+		switch n := c.Node().(type) {
+		case *ast.CallExpr:
+			s, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok || (s.Sel.Name != "tryStore" && s.Sel.Name != "storeLocked") {
+				return true
+			}
+			arg, err := parser.ParseExpr("&" + m + ".length")
+			check(err, "parse counter argument")
+			setAllPos(arg, n.Lparen)
+			n.Args = append([]ast.Expr{arg}, n.Args...)
+		case *ast.AssignStmt:
+			call, ok := n.Rhs[0].(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if id, ok := call.Fun.(*ast.Ident); ok && id.Name == newEntry {
+				c.InsertAfter(stmts(fmt.Sprintf("atomic.AddInt64(&%s.length, 1)", m), n.Pos())[0])
+			}
+		}
+		return true
+	}, nil)
+}
+
+// calls reports whether the body of the given method calls the given method of its receiver.
+func calls(f *ast.FuncDecl, recv, method string) bool {
+	var found bool
+	ast.Inspect(f.Body, func(n ast.Node) bool {
+		if s, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := s.X.(*ast.Ident); ok && id.Name == recv && s.Sel.Name == method {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// counterLen is the Len method of the maps that count their keys.
+const counterLen = `
+// Len returns the number of keys in the {{.Kind}}, in constant time. The keys are counted
+// atomically by the methods that add and delete them, and Len may not reflect the writes
+// that run concurrently with it. The writes that run concurrently with Clear may be
+// counted although they are lost.
+func (m *{{.Name}}) Len() int {
+	return int(atomic.LoadInt64(&m.length))
+}
+`
+
+var counterTmpl = template.Must(template.New("counter").Parse(counterLen + `
+// tryStore stores a value if the entry has not been expunged, and increments n if the
+// entry held no value.
+//
+// If the entry is expunged, tryStore returns false and leaves the entry
+// unchanged.
+func (e *{{.Entry}}) tryStore(n *int64, i *{{.Value}}) bool {
+	for {
+		p := atomic.LoadPointer(&e.p)
+		if p == {{.Expunged}} {
+			return false
+		}
+		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(i)) {
+			if p == nil {
+				atomic.AddInt64(n, 1)
+			}
+			return true
+		}
+	}
+}
+
+// storeLocked unconditionally stores a value to the entry, and increments n if the
+// entry held no value.
+//
+// The entry must be known not to be expunged.
+func (e *{{.Entry}}) storeLocked(n *int64, i *{{.Value}}) {
+	if atomic.SwapPointer(&e.p, unsafe.Pointer(i)) == nil {
+		atomic.AddInt64(n, 1)
+	}
+}
+`))
+
+var counterInlineTmpl = template.Must(template.New("counter").Parse(counterLen + `
+// tryStore stores a value if the entry has not been expunged, and increments n if the
+// entry held no value.
+//
+// If the entry is expunged, tryStore returns false and leaves the entry
+// unchanged.
+func (e *{{.Entry}}) tryStore(n *int64, i *{{.Value}}) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.expunged {
+		return false
+	}
+	if !e.ok {
+		atomic.AddInt64(n, 1)
+	}
+	e.v, e.ok = *i, true
+	return true
+}
+
+// storeLocked unconditionally stores a value to the entry, and increments n if the
+// entry held no value.
+//
+// The entry must be known not to be expunged.
+func (e *{{.Entry}}) storeLocked(n *int64, i *{{.Value}}) {
+	e.mu.Lock()
+	if !e.ok {
+		atomic.AddInt64(n, 1)
+	}
+	e.v, e.ok = *i, true
+	e.mu.Unlock()
+}
+`))
+
+var counterSlabTmpl = template.Must(template.New("counter").Parse(counterLen + `
+// tryStore stores a value if the entry has not been expunged, and increments n if the
+// entry held no value.
+//
+// If the entry is expunged, tryStore returns false and leaves the entry
+// unchanged.
+func (e *{{.Entry}}) tryStore(n *int64, i *{{.Value}}) bool {
+	p := atomic.LoadInt64(&e.p)
+	if p == {{.Expunged}} {
+		return false
+	}
+	h := e.s.alloc(*i)
+	for {
+		if atomic.CompareAndSwapInt64(&e.p, p, h) {
+			if p != 0 {
+				e.s.release(p)
+			} else {
+				atomic.AddInt64(n, 1)
+			}
+			return true
+		}
+		p = atomic.LoadInt64(&e.p)
+		if p == {{.Expunged}} {
+			e.s.release(h)
+			return false
+		}
+	}
+}
+
+// storeLocked unconditionally stores a value to the entry, and increments n if the
+// entry held no value.
+//
+// The entry must be known not to be expunged.
+func (e *{{.Entry}}) storeLocked(n *int64, i *{{.Value}}) {
+	if p := atomic.SwapInt64(&e.p, e.s.alloc(*i)); p != 0 {
+		e.s.release(p)
+	} else {
+		atomic.AddInt64(n, 1)
+	}
+}
+`))
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+// Doctor reports the compatibility of a sync/map.go with the generator, by comparing its
+// declarations with the ones the generator knows how to mutate. It lists the declarations
+// that are unrecognized, the ones that are expected but missing, and the generic ones,
+// and then tries to mutate the file. The path defaults to the sync/map.go of GOROOT.
+// It fails if the file is not compatible.
+func Doctor(w io.Writer, path string) (err error) {
+	defer catch(&err)
+	if path == "" {
+		path = filepath.Join(runtime.GOROOT(), "src", "sync", "map.go")
+	}
+	src, err := ioutil.ReadFile(path)
+	check(err, "read %q file", path)
+	f, err := parser.ParseFile(token.NewFileSet(), path, src, 0)
+	check(err, "parse %q file", path)
+	var g Generator
+	known := map[string]bool{}
+	for name := range g.Funcs() {
+		known["func "+name] = true
+	}
+	for name := range g.Types() {
+		known["type "+name] = true
+	}
+	for name := range g.Values() {
+		known["value "+name] = true
+	}
+	var declared, generic []string
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			declared = append(declared, "func "+d.Name.Name)
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				switch s := s.(type) {
+				case *ast.TypeSpec:
+					declared = append(declared, "type "+s.Name.Name)
+					if s.TypeParams != nil {
+						generic = append(generic, "type "+s.Name.Name)
+					}
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						declared = append(declared, "value "+n.Name)
+					}
+				}
+			}
+		}
+	}
+	var unrecognized, missing []string
+	for _, name := range declared {
+		if !known[name] {
+			unrecognized = append(unrecognized, name)
+		}
+		delete(known, name)
+	}
+	for name := range known {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	fmt.Fprintf(w, "%s: %d declarations\n", path, len(declared))
+	for _, l := range []struct {
+		title string
+		names []string
+	}{
+		{"unrecognized (no handler)", unrecognized},
+		{"missing (handled, but not declared)", missing},
+		{"generic (not supported)", generic},
+	} {
+		for _, name := range l.names {
+			fmt.Fprintf(w, "\t%s: %s\n", l.title, name)
+		}
+	}
+	// The handlers may still fail on declarations that changed.
+	merr := func() error {
+		g, err := NewGenerator(Config{
+			Name:   "Map",
+			Pkg:    "main",
+			Spec:   "map[string]int",
+			Source: path,
+			Out:    filepath.Join(os.TempDir(), "syncmap-doctor", "map.go"),
+		})
+		if err == nil {
+			err = g.Mutate()
+		}
+		return err
+	}()
+	if merr != nil {
+		fmt.Fprintf(w, "\tmutation failed: %v\n", merr)
+	}
+	expect(len(unrecognized)+len(missing)+len(generic) == 0 && merr == nil, "%s is not compatible with this version of syncmap", path)
+	fmt.Fprintf(w, "\tcompatible\n")
+	return
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	_ "embed"
+)
+
+// embeddedVersion is the Go version the embedded sync/map.go was copied from.
+const embeddedVersion = "go1.16"
+
+// embeddedSource is a copy of sync/map.go, used as a last resort when the file is missing
+// from GOROOT (e.g. in minimal containers that do not ship the Go source files).
+//
+//go:embed testdata/testdata/src/sync/map.go
+var embeddedSource []byte
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Placeholders of the map name and types in the cached templates. The name placeholder
+// starts with a lowercase letter, in order to tell it apart from its title-cased form
+// in the names of the internal types (e.g. entrySyncmapName).
+const (
+	fastName  = "syncmapName"
+	fastKey   = "syncmapKey"
+	fastValue = "syncmapValue"
+)
+
+var packageClause = regexp.MustCompile(`(?m)^package .*\n`)
+
+// fastTemplate is the generated source of a map whose name and types are placeholders.
+type fastTemplate struct {
+	src        []byte   // source of the main file.
+	ext        []byte   // source of the extension file.
+	extImports []string // imports of the extension file.
+	imports    int      // imports added to the main file.
+}
+
+// fastTypes reports whether the map types can be substituted in the source of a template.
+// That is, types that are printed the same in every position they appear in, and do not
+// change the shape of the generated code: named types, and pointers and slices of them.
+func (g *Generator) fastTypes() bool {
+	var ok func(x ast.Expr) bool
+	ok = func(x ast.Expr) bool {
+		switch x := x.(type) {
+		case *ast.Ident:
+			return true
+		case *ast.SelectorExpr:
+			_, isIdent := x.X.(*ast.Ident)
+			return isIdent
+		case *ast.StarExpr:
+			return ok(x.X)
+		case *ast.ArrayType:
+			return x.Len == nil && ok(x.Elt)
+		case *ast.StructType:
+			// The struct{} values of sets.
+			return len(x.Fields.List) == 0
+		}
+		return false
+	}
+	if !ok(g.mapType.Key) || !ok(g.mapType.Value) {
+		return false
+	}
+	// The hash of the shards of -shards depends on the key type.
+	if g.Shards != 0 {
+		return false
+	}
+	// The codecs of the binary helper depend on the types.
+	for _, name := range g.Helpers {
+		if name == "binary" {
+			return false
+		}
+	}
+	// Without goimports, the imports of the types are added by the AST mutation.
+	return g.Format == nil || len(g.typeImports()) == 0
+}
+
+// mutateFast generates the map by substituting its name and types in a template that is
+// cached across resets, instead of mutating the AST of sync/map.go.
+func (g *Generator) mutateFast() {
+	c := g.Config
+	c.Name, c.Spec = fastName, fmt.Sprintf("map[%s]%s", fastKey, fastValue)
+	c.Out = filepath.Join(filepath.Dir(g.Out), fastName+".go")
+	// The options that are applied by Gen are not part of the template.
+	c.Args, c.Suffix, c.Header, c.Size, c.NoHeader, c.NoGofmt = nil, "", "", 0, false, false
+	c.Zero, c.Format, c.WriteFile, c.Fast = false, nil, nil, false
+	switch {
+	case g.Set:
+		c.Spec = fastKey
+	case g.key == g.value:
+		// Maps with the same key and value types declare them together in tuples.
+		c.Spec = fmt.Sprintf("map[%s]%s", fastKey, fastKey)
+	}
+	id := fmt.Sprintf("%+v", c)
+	t, ok := g.templates[id]
+	if !ok {
+		t = g.newTemplate(c)
+		g.templates[id] = t
+	}
+	// The article of the placeholder is "a", and it is replaced with the one of the name.
+	r := strings.NewReplacer(
+		" a "+fastName, " "+article(g.Name)+" "+g.Name,
+		" A "+fastName, " "+strings.Title(article(g.Name))+" "+g.Name,
+		strings.Title(fastName), strings.Title(g.Name),
+		fastName, g.Name,
+		fastKey, g.key,
+		fastValue, g.value,
+	)
+	src := r.Replace(string(t.src))
+	if specs := g.typeImports(); len(specs) > 0 {
+		// The imports are added in a separate declaration after the package clause,
+		// and goimports merges them with the others.
+		i := packageClause.FindStringIndex(src)[1]
+		b := bytes.NewBufferString(src[:i])
+		for _, s := range specs {
+			fmt.Fprintf(b, "\nimport %s\n", s)
+		}
+		g.stats.Imports += len(specs)
+		b.WriteString(src[i:])
+		src = b.String()
+	}
+	g.body = []byte(src)
+	g.stats.Imports += t.imports
+	if t.ext != nil {
+		g.ext = []byte(r.Replace(string(t.ext)))
+		g.extImports = t.extImports
+	}
+}
+
+// newTemplate generates the template for the given config, using the AST mutation.
+func (g *Generator) newTemplate(c Config) *fastTemplate {
+	t := &Generator{goroot: g.goroot, stderr: g.stderr, src: g.src, srcPath: g.srcPath}
+	err := t.Reset(c)
+	check(err, "reset template generator")
+	err = t.Mutate()
+	check(err, "mutate template")
+	g.src, g.srcPath = t.src, t.srcPath
+	b := bytes.NewBuffer(nil)
+	err = format.Node(b, t.fset, t.file)
+	check(err, "format template")
+	return &fastTemplate{src: b.Bytes(), ext: t.ext, extImports: t.extImports, imports: t.stats.Imports}
+}
@@ -0,0 +1,132 @@
+package main
+
+import "text/template"
+
+// freezeTmpl is the map generated by -readonly-after-init. It has the same method set as
+// sync.Map, and a Freeze method.
+var freezeTmpl = template.Must(template.New("freeze").Parse(`package {{.Pkg}}
+
+import "sync"
+
+// {{.Name}} is like a Go map[{{.Key}}]{{.Value}} but is safe for concurrent use by multiple
+// goroutines. It is specialized for maps that are written during the initialization of
+// the program, and only read afterwards. Until Freeze is called, it is a plain Go map
+// guarded by a mutex. After Freeze, the map is immutable: writes panic, and reads take
+// no lock.
+//
+// Freeze must happen-before the reads that do not synchronize with it otherwise. i.e.
+// it must not be called concurrently with other methods of the map. Typically, Freeze
+// is called at the end of the initialization, before the map is shared with the
+// goroutines that read it.
+//
+// The zero {{.Name}} is empty and ready for use. It must not be copied after first use.
+type {{.Name}} struct {
+	frozen bool // set by Freeze. m is immutable once it is set.
+	mu     {{.Mutex}}
+	m      map[{{.Key}}]{{.Value}}
+}
+
+// Freeze makes the map immutable. After Freeze, reads take no lock, and writes panic.
+// Calling Freeze more than once has no effect.
+func (m *{{.Name}}) Freeze() {
+	m.mu.Lock()
+	m.frozen = true
+	m.mu.Unlock()
+}
+
+// Load returns the value stored in the map for a key, or the zero value if no
+// value is present.
+// The ok result indicates whether value was found in the map.
+func (m *{{.Name}}) Load(key {{.Key}}) (value {{.Value}}, ok bool) {
+	if m.frozen {
+		value, ok = m.m[key]
+		return value, ok
+	}
+	m.mu.Lock()
+	value, ok = m.m[key]
+	m.mu.Unlock()
+	return value, ok
+}
+
+// Store sets the value for a key. It panics if the map is frozen.
+func (m *{{.Name}}) Store(key {{.Key}}, value {{.Value}}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkWrite()
+	if m.m == nil {
+		m.m = make(map[{{.Key}}]{{.Value}})
+	}
+	m.m[key] = value
+}
+
+// LoadOrStore returns the existing value for the key if present.
+// Otherwise, it stores and returns the given value.
+// The loaded result is true if the value was loaded, false if stored.
+// It panics if the map is frozen and the key is not present.
+func (m *{{.Name}}) LoadOrStore(key {{.Key}}, value {{.Value}}) (actual {{.Value}}, loaded bool) {
+	if m.frozen {
+		if actual, loaded = m.m[key]; loaded {
+			return actual, loaded
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if actual, loaded = m.m[key]; loaded {
+		return actual, loaded
+	}
+	m.checkWrite()
+	if m.m == nil {
+		m.m = make(map[{{.Key}}]{{.Value}})
+	}
+	m.m[key] = value
+	return value, false
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if any.
+// The loaded result reports whether the key was present. It panics if the map is
+// frozen.
+func (m *{{.Name}}) LoadAndDelete(key {{.Key}}) (value {{.Value}}, loaded bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkWrite()
+	value, loaded = m.m[key]
+	delete(m.m, key)
+	return value, loaded
+}
+
+// Delete deletes the value for a key. It panics if the map is frozen.
+func (m *{{.Name}}) Delete(key {{.Key}}) {
+	m.LoadAndDelete(key)
+}
+
+// Range calls f sequentially for each key and value present in the map.
+// If f returns false, range stops the iteration.
+//
+// Before Freeze, Range iterates over a copy of the map taken under the lock, so f
+// may call any of the map methods. After Freeze, Range iterates over the map itself.
+func (m *{{.Name}}) Range(f func(key {{.Key}}, value {{.Value}}) bool) {
+	var s map[{{.Key}}]{{.Value}}
+	if m.frozen {
+		s = m.m
+	} else {
+		m.mu.Lock()
+		s = make(map[{{.Key}}]{{.Value}}, len(m.m))
+		for key, value := range m.m {
+			s[key] = value
+		}
+		m.mu.Unlock()
+	}
+	for key, value := range s {
+		if !f(key, value) {
+			break
+		}
+	}
+}
+
+// checkWrite panics if the map is frozen. It must be called with mu held.
+func (m *{{.Name}}) checkWrite() {
+	if m.frozen {
+		panic("{{.Name}}: write after Freeze")
+	}
+}
+`))
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// typeParam is a type parameter of a generic map.
+type typeParam struct {
+	name       string
+	constraint string
+}
+
+// typeParams returns the type parameters of a generic map: the key, and the value of maps
+// that are not sets.
+func (g *Generator) typeParams() []typeParam {
+	params := []typeParam{{g.key, g.KeyConstraint}}
+	if !g.Set {
+		params = append(params, typeParam{g.value, g.ValueConstraint})
+	}
+	return params
+}
+
+// typeParam returns the type parameter with the given name, or nil if the map is not
+// generic or has no such type parameter.
+func (g *Generator) typeParam(name string) *typeParam {
+	if !g.Generic {
+		return nil
+	}
+	for _, p := range g.typeParams() {
+		if p.name == name {
+			return &p
+		}
+	}
+	return nil
+}
+
+// anyConstraint reports whether the constraint permits any type, and therefore does not
+// permit comparisons with ==.
+func anyConstraint(c string) bool {
+	c = strings.Join(strings.Fields(c), "")
+	return c == "any" || c == "interface{}"
+}
+
+// checkTypeParams fails if the config of a generic map is invalid. The map type must be
+// map[K]V, where K and V are the names of the type parameters (or K, for sets), and their
+// constraints default to comparable and any.
+func (g *Generator) checkTypeParams() {
+	if g.KeyConstraint == "" {
+		g.KeyConstraint = "comparable"
+	}
+	if g.ValueConstraint == "" {
+		g.ValueConstraint = "any"
+	}
+	// Predeclared types (e.g. string) are not taken as names of type parameters, as they
+	// would shadow the types.
+	_, ok := g.mapType.Key.(*ast.Ident)
+	expect(ok && types.Universe.Lookup(g.key) == nil, "-generic: key type %s is not a type parameter name. expected map[K]V", g.key)
+	if !g.Set {
+		_, ok := g.mapType.Value.(*ast.Ident)
+		expect(ok && g.value != g.key && types.Universe.Lookup(g.value) == nil, "-generic: value type %s is not a type parameter name. expected map[K]V", g.value)
+	}
+	for _, c := range []string{g.KeyConstraint, g.ValueConstraint} {
+		x, err := parser.ParseExpr(c)
+		check(err, "-generic: parse constraint %q", c)
+		g.constraints = append(g.constraints, x)
+	}
+	expect(!anyConstraint(g.KeyConstraint), "-generic: key constraint %s is not comparable", g.KeyConstraint)
+	// The tests and the extension files are generated separately, and do not know the
+	// type arguments.
+	for _, o := range []struct {
+		flag string
+		set  bool
+	}{
+		{"-ext", g.Ext},
+		{"-tags", g.Tags != ""},
+		{"-assert-size", g.Size > 0},
+		{"-assert-zero", g.Zero},
+		{"-assert-comparable", g.Comparable},
+	} {
+		expect(!o.set, "%s can not be used with -generic", o.flag)
+	}
+}
+
+// addTypeParams makes the generated code generic. The declarations that depend on the type
+// parameters, directly or through other declarations, are declared with the type parameter
+// list of the map (e.g. the entry type), and their uses are instantiated with it.
+func (g *Generator) addTypeParams() {
+	params := g.typeParams()
+	generic := make(map[string]bool)
+	for _, p := range params {
+		generic[p.name] = true
+	}
+	refers := func(n ast.Node) (found bool) {
+		ast.Inspect(n, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && generic[id.Name] {
+				found = true
+			}
+			return !found
+		})
+		return found
+	}
+	// The types are generic also if only their methods depend on the type parameters,
+	// e.g. the entry type, whose values are unsafe pointers.
+	types := make(map[string]*ast.TypeSpec)
+	for _, d := range g.file.Decls {
+		if d, ok := d.(*ast.GenDecl); ok {
+			for _, s := range d.Specs {
+				if s, ok := s.(*ast.TypeSpec); ok {
+					types[s.Name.Name] = s
+				}
+			}
+		}
+	}
+	var decls []ast.Node
+	add := func(kind string, name *ast.Ident, d ast.Node) {
+		g.logf("adding type parameters to %s %s", kind, name.Name)
+		generic[name.Name] = true
+		decls = append(decls, d)
+	}
+	for changed := true; changed; {
+		n := len(decls)
+		for _, d := range g.file.Decls {
+			switch d := d.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					if !generic[d.Name.Name] && refers(d) {
+						add("func", d.Name, d)
+					}
+					break
+				}
+				recv := d.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				if id, ok := recv.(*ast.Ident); ok && types[id.Name] != nil && !generic[id.Name] && refers(d) {
+					add("type", id, types[id.Name])
+				}
+			case *ast.GenDecl:
+				for _, s := range d.Specs {
+					if s, ok := s.(*ast.TypeSpec); ok && !generic[s.Name.Name] && refers(s.Type) {
+						add("type", s.Name, s)
+					}
+				}
+			}
+		}
+		changed = len(decls) > n
+	}
+	for _, p := range params {
+		delete(generic, p.name)
+	}
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.name
+	}
+	astutil.Apply(g.file, func(c *astutil.Cursor) bool {
+		id, ok := c.Node().(*ast.Ident)
+		if !ok || !generic[id.Name] {
+			return true
+		}
+		switch p := c.Parent().(type) {
+		case *ast.TypeSpec, *ast.FuncDecl:
+			if c.Name() == "Name" {
+				// The declared name.
+				return true
+			}
+		case *ast.SelectorExpr:
+			if p.Sel == id {
+				return true
+			}
+		}
+		x, err := parser.ParseExpr(fmt.Sprintf("%s[%s]", id.Name, strings.Join(names, ", ")))
+		check(err, "parse instantiation of %s", id.Name)
+		setAllPos(x, id.Pos())
+		c.Replace(x)
+		return true
+	}, nil)
+	for _, d := range decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			d.Type.TypeParams = g.typeParamList(d.Name.End())
+		case *ast.TypeSpec:
+			d.TypeParams = g.typeParamList(d.Name.End())
+		}
+	}
+}
+
+// typeParamList returns the type parameter list of the generic declarations, e.g.
+// [K comparable, V any], at the given position.
+func (g *Generator) typeParamList(pos token.Pos) *ast.FieldList {
+	var list []string
+	for _, p := range g.typeParams() {
+		list = append(list, p.name+" "+p.constraint)
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", fmt.Sprintf("package p\ntype _[%s] int", strings.Join(list, ", ")), 0)
+	check(err, "parse type parameters")
+	params := f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).TypeParams
+	setAllPos(params, pos)
+	return params
+}
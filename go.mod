@@ -1,9 +1,10 @@
 module github.com/a8m/syncmap
 
-go 1.16
+go 1.18
+
+require golang.org/x/tools v0.1.12
 
 require (
-	golang.org/x/mod v0.4.1 // indirect
-	golang.org/x/sys v0.0.0-20210309074719-68d13333faf2 // indirect
-	golang.org/x/tools v0.1.0
+	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4 // indirect
+	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
 )
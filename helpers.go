@@ -0,0 +1,672 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/types"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// helper is an optional method that is generated on top of the specialized map.
+type helper struct {
+	name    string           // name in the -helpers flag.
+	imports []string         // packages used by the method.
+	check   func(*Generator) // optional validation of the map types.
+	src     string           // method template.
+	tmpl    *template.Template
+	// data optionally returns the data of the template, and its imports in addition to
+	// the imports of the helper, instead of the helperData.
+	data func(*Generator, helperData) (interface{}, []string)
+}
+
+// helperData is the data passed to the helper templates.
+type helperData struct {
+	Name           string // struct name.
+	Zero           string // name of the variable that holds the zero value.
+	Key            string // map key type, as written by the user.
+	Value          string // map value type, as written by the user.
+	Load           string // name of the Load method.
+	LoadOrStore    string // name of the LoadOrStore method.
+	Range          string // name of the Range method.
+	Store          string // name of the Store method.
+	CompareAndSwap string // name of the CompareAndSwap method of -cas.
+}
+
+// helpers holds all available helpers, in the order they are generated.
+var helpers = []*helper{
+	{
+		name: "len",
+		check: func(g *Generator) {
+			expect(!g.Set, "len helper: sets already have a Len method")
+			expect(!g.Counter, "len helper: -counter already generates a Len method")
+		},
+		src: `
+// Len returns the number of entries in the map. It ranges over the map and
+// therefore runs in linear time.
+func (m *{{.Name}}) Len() int {
+	n := 0
+	m.{{.Range}}(func(_ {{.Key}}, _ {{.Value}}) bool {
+		n++
+		return true
+	})
+	return n
+}
+`,
+	},
+	{
+		name: "keys",
+		src: `
+// Keys returns all keys present in the map, in no particular order.
+func (m *{{.Name}}) Keys() []{{.Key}} {
+	var keys []{{.Key}}
+	m.{{.Range}}(func(key {{.Key}}, _ {{.Value}}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+`,
+	},
+	{
+		name: "values",
+		src: `
+// Values returns all values present in the map, in no particular order.
+func (m *{{.Name}}) Values() []{{.Value}} {
+	var values []{{.Value}}
+	m.{{.Range}}(func(_ {{.Key}}, value {{.Value}}) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
+`,
+	},
+	{
+		name:    "string",
+		imports: []string{"fmt"},
+		src: `
+// String returns the string representation of the map, formatted as a Go map.
+func (m *{{.Name}}) String() string {
+	s := make(map[{{.Key}}]{{.Value}})
+	m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		s[key] = value
+		return true
+	})
+	return fmt.Sprint(s)
+}
+`,
+	},
+	{
+		name: "loadordefault",
+		src: `
+// LoadOrDefault returns the value stored in the map for a key, or def if no value
+// is present. Unlike LoadOrStore, it never stores def in the map.
+func (m *{{.Name}}) LoadOrDefault(key {{.Key}}, def {{.Value}}) {{.Value}} {
+	if value, ok := m.{{.Load}}(key); ok {
+		return value
+	}
+	return def
+}
+`,
+	},
+	{
+		name: "loadnonzero",
+		check: func(g *Generator) {
+			expect(g.comparable(g.mapType.Value), "loadnonzero helper: value type %s is not comparable", g.value)
+		},
+		src: `
+// LoadNonZero is like Load, but treats a stored zero value as absent. i.e. ok is
+// false if the key is not present, or if its value is the zero value of its type.
+// It is convenient for counters, where zero means nothing.
+func (m *{{.Name}}) LoadNonZero(key {{.Key}}) (value {{.Value}}, ok bool) {
+	if value, ok = m.{{.Load}}(key); !ok || value == {{.Zero}} {
+		return {{.Zero}}, false
+	}
+	return value, true
+}
+`,
+	},
+	{
+		name: "loadptr",
+		check: func(g *Generator) {
+			_, ptr := g.underlying(g.mapType.Value).(*ast.StarExpr)
+			expect(!ptr, "loadptr helper: value type %s is a pointer. use Load instead", g.value)
+		},
+		src: `
+// LoadPtr returns a pointer to a copy of the value stored in the map for a key, or nil
+// if no value is present. The copy does not alias the value in the map. i.e. changes
+// made through the pointer are not visible to other callers until it is stored back.
+func (m *{{.Name}}) LoadPtr(key {{.Key}}) (*{{.Value}}, bool) {
+	value, ok := m.{{.Load}}(key)
+	if !ok {
+		return nil, false
+	}
+	return &value, true
+}
+`,
+	},
+	{
+		name: "tomap",
+		src: `
+// ToMap returns a copy of the map as a Go map.
+func (m *{{.Name}}) ToMap() map[{{.Key}}]{{.Value}} {
+	s := make(map[{{.Key}}]{{.Value}})
+	m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		s[key] = value
+		return true
+	})
+	return s
+}
+`,
+	},
+	{
+		name: "rangeinto",
+		src: `
+// RangeInto stores all the entries of the map into the given Go map, which must not
+// be nil. The other entries of dst are kept. Unlike ToMap, it does not allocate a
+// Go map, and lets the caller reuse one, e.g. after clearing it.
+func (m *{{.Name}}) RangeInto(dst map[{{.Key}}]{{.Value}}) {
+	m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		dst[key] = value
+		return true
+	})
+}
+`,
+	},
+	{
+		name: "storeall",
+		src: `
+// StoreAll sets the values for all keys in the given Go map.
+func (m *{{.Name}}) StoreAll(s map[{{.Key}}]{{.Value}}) {
+	for key, value := range s {
+		m.{{.Store}}(key, value)
+	}
+}
+`,
+	},
+	{
+		name: "snapshotrange",
+		src: `
+// SnapshotRange calls f sequentially for each key and value present in a copy of
+// the map, taken before the first call to f. If f returns false, range stops the
+// iteration.
+//
+// Unlike Range, the iteration is not affected by stores and deletes that happen
+// during the call, including the ones made by f. Note that SnapshotRange allocates
+// a copy of the entire map, and that the copy is built using Range. Hence, it is not
+// an atomic snapshot with respect to concurrent writes.
+func (m *{{.Name}}) SnapshotRange(f func(key {{.Key}}, value {{.Value}}) bool) {
+	s := make(map[{{.Key}}]{{.Value}})
+	m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		s[key] = value
+		return true
+	})
+	for key, value := range s {
+		if !f(key, value) {
+			break
+		}
+	}
+}
+`,
+	},
+	{
+		name: "merge",
+		data: func(g *Generator, d helperData) (interface{}, []string) {
+			return struct {
+				helperData
+				CAS           bool
+				LoadAndDelete string
+			}{d, g.CAS, g.method("LoadAndDelete")}, nil
+		},
+		src: `
+// Merge stores all entries of other in the map. If a key is already present, the value
+// stored for it is the result of resolve, that is called with the existing and the
+// incoming values.
+//
+{{- if .CAS}}
+// The resolved value is stored only if the existing value was not changed concurrently.
+// Otherwise, resolve is called again with the new existing value, and it may be called
+// several times for the same key. Hence, it should not have side effects.
+func (m *{{.Name}}) Merge(other *{{.Name}}, resolve func(existing, incoming {{.Value}}) {{.Value}}) {
+	other.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		for {
+			existing, loaded := m.{{.LoadOrStore}}(key, value)
+			if !loaded || m.{{.CompareAndSwap}}(key, existing, resolve(existing, value)) {
+				return true
+			}
+		}
+	})
+}
+{{- else}}
+// The existing value is taken out of the map while it is resolved, and the key is absent
+// from the map meanwhile. A value stored concurrently is not overwritten, but resolved
+// in turn, with the resolved value as the incoming one. Hence, resolve may be called
+// several times for the same key, and it should not have side effects.
+func (m *{{.Name}}) Merge(other *{{.Name}}, resolve func(existing, incoming {{.Value}}) {{.Value}}) {
+	other.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		for {
+			if existing, loaded := m.{{.LoadAndDelete}}(key); loaded {
+				value = resolve(existing, value)
+			}
+			if _, loaded := m.{{.LoadOrStore}}(key, value); !loaded {
+				return true
+			}
+		}
+	})
+}
+{{- end}}
+`,
+	},
+	{
+		name: "rangebatch",
+		src: `
+// RangeBatch calls f sequentially for batches of up to n keys and values present in
+// the map, where values[i] is the value of keys[i]. If f returns false, range stops
+// the iteration. A value of n less than 1 is treated as 1.
+//
+// The slices are reused between the calls, and are valid only during the call to f.
+// RangeBatch has the same semantics as Range, except that f is called with a delay,
+// after a batch is accumulated. f may call any of the map methods, and its stores and
+// deletes may be reflected in the following batches.
+func (m *{{.Name}}) RangeBatch(n int, f func(keys []{{.Key}}, values []{{.Value}}) bool) {
+	if n < 1 {
+		n = 1
+	}
+	// The slices are not allocated with the capacity of large batches up front, as the
+	// map may have fewer entries, and they grow up to n while the batch is accumulated.
+	size := n
+	if size > 64 {
+		size = 64
+	}
+	keys, values := make([]{{.Key}}, 0, size), make([]{{.Value}}, 0, size)
+	ok := true
+	m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		keys, values = append(keys, key), append(values, value)
+		if len(keys) == n {
+			ok = f(keys, values)
+			keys, values = keys[:0], values[:0]
+		}
+		return ok
+	})
+	if ok && len(keys) > 0 {
+		f(keys, values)
+	}
+}
+`,
+	},
+	{
+		name:    "rangesafe",
+		imports: []string{"fmt"},
+		src: `
+// RangeSafe is like Range, but recovers from a panic in f instead of propagating it.
+// The iteration stops at the panic, and the recovered value is returned as an error.
+// If the recovered value is an error, the returned error wraps it.
+func (m *{{.Name}}) RangeSafe(f func(key {{.Key}}, value {{.Value}}) bool) (err error) {
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+		case error:
+			err = fmt.Errorf("range: panic: %w", r)
+		default:
+			err = fmt.Errorf("range: panic: %v", r)
+		}
+	}()
+	m.{{.Range}}(f)
+	return nil
+}
+`,
+	},
+	{
+		name:    "json",
+		imports: []string{"encoding/json"},
+		check:   jsonEncodable("json"),
+		src: `
+// MarshalJSON implements the json.Marshaler interface. The map is encoded as a JSON object.
+func (m *{{.Name}}) MarshalJSON() ([]byte, error) {
+	s := make(map[{{.Key}}]{{.Value}})
+	m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		s[key] = value
+		return true
+	})
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. The decoded entries are
+// stored in the map, in addition to the existing ones.
+func (m *{{.Name}}) UnmarshalJSON(b []byte) error {
+	var s map[{{.Key}}]{{.Value}}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	for key, value := range s {
+		m.{{.Store}}(key, value)
+	}
+	return nil
+}
+`,
+	},
+	{
+		name:    "jsonstream",
+		imports: []string{"bufio", "encoding/json", "io"},
+		check:   jsonEncodable("jsonstream"),
+		src: `
+// WriteJSON writes the map to w as a JSON object, like MarshalJSON, without building a
+// copy of the map in memory. The entries are encoded one by one during a single Range,
+// with the encoding of the keys and the values of encoding/json. Unlike MarshalJSON,
+// the keys are written in no particular order.
+func (m *{{.Name}}) WriteJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('{')
+	var err error
+	first := true
+	m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		// An object of one entry, whose key is encoded like the keys of MarshalJSON.
+		var b []byte
+		if b, err = json.Marshal(map[{{.Key}}]{{.Value}}{key: value}); err != nil {
+			return false
+		}
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		_, err = bw.Write(b[1 : len(b)-1])
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	bw.WriteByte('}')
+	return bw.Flush()
+}
+`,
+	},
+	{
+		name:    "binary",
+		imports: []string{"bufio", "encoding/binary", "io"},
+		check:   (*Generator).checkBinary,
+		src:     binaryHelper,
+		data:    (*Generator).binaryData,
+	},
+	{
+		name:    "gob",
+		imports: []string{"bytes", "encoding/gob"},
+		check:   encodable("gob"),
+		src: `
+// GobEncode implements the gob.GobEncoder interface.
+func (m *{{.Name}}) GobEncode() ([]byte, error) {
+	s := make(map[{{.Key}}]{{.Value}})
+	m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+		s[key] = value
+		return true
+	})
+	b := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(b).Encode(s); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// GobDecode implements the gob.GobDecoder interface. The decoded entries are stored
+// in the map, in addition to the existing ones.
+func (m *{{.Name}}) GobDecode(b []byte) error {
+	var s map[{{.Key}}]{{.Value}}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&s); err != nil {
+		return err
+	}
+	for key, value := range s {
+		m.{{.Store}}(key, value)
+	}
+	return nil
+}
+`,
+	},
+}
+
+// iterHelper is the All method generated by the -iter flag. It is not selectable by
+// -helpers, and is generated after them.
+var iterHelper = &helper{
+	name:    "iter",
+	imports: []string{"iter"},
+	src: `
+// All returns an iterator over the key-value pairs in the map, for use with range loops.
+// The iteration has the same semantics as Range.
+func (m *{{.Name}}) All() iter.Seq2[{{.Key}}, {{.Value}}] {
+	return func(yield func({{.Key}}, {{.Value}}) bool) {
+		m.{{.Range}}(yield)
+	}
+}
+`,
+}
+
+// chanIterHelper is the Iter method generated by the -chaniter flag. Like iterHelper,
+// it is not selectable by -helpers.
+var chanIterHelper = &helper{
+	name: "chaniter",
+	src: `
+// Iter returns a channel of the key-value pairs in the map. The pairs are sent by a new
+// goroutine that ranges over the map, and the channel is closed when the iteration is done.
+// The iteration has the same semantics as Range.
+//
+// The channel must be drained by the caller. If the caller stops receiving from the channel,
+// the goroutine blocks forever, and both the goroutine and the map are leaked. On Go 1.23 or
+// later, prefer ranging over the All method (see -iter).
+func (m *{{.Name}}) Iter() <-chan struct {
+	Key   {{.Key}}
+	Value {{.Value}}
+} {
+	c := make(chan struct {
+		Key   {{.Key}}
+		Value {{.Value}}
+	})
+	go func() {
+		defer close(c)
+		m.{{.Range}}(func(key {{.Key}}, value {{.Value}}) bool {
+			c <- struct {
+				Key   {{.Key}}
+				Value {{.Value}}
+			}{key, value}
+			return true
+		})
+	}()
+	return c
+}
+`,
+}
+
+// encodable returns a check that fails if the map types can not be encoded by the helper.
+func encodable(name string) func(*Generator) {
+	return func(g *Generator) {
+		for _, t := range []struct {
+			kind, name string
+			expr       ast.Expr
+		}{
+			{"key", g.key, g.mapType.Key},
+			{"value", g.value, g.mapType.Value},
+		} {
+			switch g.underlying(t.expr).(type) {
+			case *ast.FuncType:
+				expect(false, "%s helper: %s type %s is a function and can not be encoded", name, t.kind, t.name)
+			case *ast.ChanType:
+				expect(false, "%s helper: %s type %s is a channel and can not be encoded", name, t.kind, t.name)
+			}
+		}
+	}
+}
+
+// jsonEncodable returns the check of the helpers that encode the map as a JSON object.
+func jsonEncodable(name string) func(*Generator) {
+	return func(g *Generator) {
+		encodable(name)(g)
+		expect(g.jsonKey(g.mapType.Key), "%s helper: key type %s can not be the key of a JSON object. "+
+			"expected a string, an integer, or a type that implements encoding.TextMarshaler", name, g.key)
+	}
+}
+
+// jsonKey reports whether the key type can be the key of a JSON object. encoding/json
+// encodes the keys that are strings, integers, or implement encoding.TextMarshaler, and
+// fails at runtime for the others. Types that can not be resolved (e.g. types from other
+// packages, or the type parameters of -generic) are assumed to be valid.
+func (g *Generator) jsonKey(x ast.Expr) bool {
+	seen := make(map[string]bool)
+	for {
+		switch t := x.(type) {
+		case *ast.ParenExpr:
+			x = t.X
+		case *ast.Ident:
+			if g.typeParam(t.Name) != nil {
+				return true
+			}
+			if ptr, ok := g.localMethods(t.Name)["MarshalText"]; ok && !ptr {
+				return true
+			}
+			decl, ok := g.localTypes()[t.Name]
+			if !ok || seen[t.Name] {
+				obj, ok := types.Universe.Lookup(t.Name).(*types.TypeName)
+				if !ok {
+					return true
+				}
+				b, ok := obj.Type().(*types.Basic)
+				return ok && b.Info()&(types.IsInteger|types.IsString) != 0
+			}
+			seen[t.Name] = true
+			x = decl
+		case *ast.StarExpr:
+			// The method set of a pointer includes the methods of both receivers.
+			if id, ok := t.X.(*ast.Ident); ok {
+				_, ok := g.localMethods(id.Name)["MarshalText"]
+				_, local := g.localTypes()[id.Name]
+				return ok || !local && types.Universe.Lookup(id.Name) == nil
+			}
+			_, ok := t.X.(*ast.SelectorExpr)
+			return ok
+		case *ast.InterfaceType:
+			for _, m := range t.Methods.List {
+				if s, ok := m.Type.(*ast.SelectorExpr); ok && s.Sel.Name == "TextMarshaler" {
+					return true
+				}
+				for _, n := range m.Names {
+					if n.Name == "MarshalText" {
+						return true
+					}
+				}
+			}
+			return false
+		case *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+func init() {
+	for _, h := range append(helpers, iterHelper, chanIterHelper) {
+		h.tmpl = template.Must(template.New(h.name).Parse(h.src))
+	}
+}
+
+// lookupHelper returns the helper with the given name, or nil if there is no such helper.
+func lookupHelper(name string) *helper {
+	for _, h := range helpers {
+		if h.name == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// addHelpers appends the requested helpers and iteration methods to the mutated file,
+// or to the extension file if requested. The helpers are generated in the order of the
+// registry, regardless of their order in the config, and their imports in the order of
+// their first use. Hence, the output does not depend on how the helpers are listed.
+func (g *Generator) addHelpers() {
+	var imports []string
+	seen := make(map[string]bool)
+	addImports := func(paths []string) {
+		for _, path := range paths {
+			if !seen[path] {
+				seen[path] = true
+				imports = append(imports, path)
+			}
+		}
+	}
+	b := bytes.NewBuffer(nil)
+	data := g.helperData()
+	for _, h := range helpers {
+		for _, name := range g.Helpers {
+			if h.name != name {
+				continue
+			}
+			g.logf("adding helper %s", h.name)
+			addImports(h.imports)
+			var d interface{} = data
+			if h.data != nil {
+				var imports []string
+				d, imports = h.data(g, data)
+				addImports(imports)
+			}
+			err := h.tmpl.Execute(b, d)
+			check(err, "execute %q helper", h.name)
+			break
+		}
+	}
+	for _, h := range []*helper{iterHelper, chanIterHelper} {
+		if h == iterHelper && !g.Iter || h == chanIterHelper && !g.ChanIter {
+			continue
+		}
+		g.logf("adding helper %s", h.name)
+		addImports(h.imports)
+		err := h.tmpl.Execute(b, data)
+		check(err, "execute %q helper", h.name)
+	}
+	// Helpers guarded by a build constraint are always generated in a separate file.
+	if g.Ext || g.Tags != "" {
+		g.ext, g.extImports = b.Bytes(), imports
+		return
+	}
+	for _, path := range imports {
+		g.logf("adding import %q for the helpers", path)
+		g.addImport(g.file, "", path)
+	}
+	g.appendSource(b.Bytes())
+}
+
+// helperData returns the data passed to the helper templates.
+func (g *Generator) helperData() helperData {
+	return helperData{
+		Name:           g.Name,
+		Zero:           g.zero(),
+		Key:            g.key,
+		Value:          g.value,
+		Load:           g.method("Load"),
+		LoadOrStore:    g.method("LoadOrStore"),
+		Range:          g.method("Range"),
+		Store:          g.method("Store"),
+		CompareAndSwap: g.method("CompareAndSwap"),
+	}
+}
+
+// list is a flag.Value for comma-separated lists. Repeated flags are appended.
+type list []string
+
+func (l *list) String() string { return strings.Join(*l, ",") }
+
+func (l *list) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// helperNames returns the names of all available helpers.
+func helperNames() string {
+	names := make([]string, len(helpers))
+	for i, h := range helpers {
+		names[i] = strconv.Quote(h.name)
+	}
+	return strings.Join(names, ", ")
+}
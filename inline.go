@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/token"
+	"text/template"
+)
+
+// addInlineValues replaces the entry of the map with an entry that stores its value inline,
+// guarded by a mutex, instead of an atomic pointer to a copy of the value. This saves the
+// allocation of the copy on every store, at the cost of locking the entry on every access.
+func (g *Generator) addInlineValues() {
+	g.replaceEntry("-valueinline", inlineTmpl, nil)
+}
+
+// replaceEntry replaces the entry type of the map, its methods, newEntry and expunged with
+// the declarations of the given template. The template gets the renamed identifiers and
+// the value type, in addition to the given data. It fails if sync/map.go has entry methods
+// that the template does not implement, as the map methods would not compile.
+func (g *Generator) replaceEntry(flag string, t *template.Template, data map[string]string) {
+	names := g.renames()
+	entry, expunged, newEntry := names["entry"], names["expunged"], names["newEntry"]
+	var methods []string
+	removeDecls(g.file, func(d ast.Decl) bool {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			switch s := d.Specs[0].(type) {
+			case *ast.TypeSpec:
+				return s.Name.Name == entry
+			case *ast.ValueSpec:
+				return s.Names[0].Name == expunged
+			}
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				return d.Name.Name == newEntry
+			}
+			star, ok := d.Recv.List[0].Type.(*ast.StarExpr)
+			if !ok {
+				return false
+			}
+			if id, ok := star.X.(*ast.Ident); !ok || id.Name != entry {
+				return false
+			}
+			methods = append(methods, d.Name.Name)
+			return true
+		}
+		return false
+	})
+	for _, name := range methods {
+		expect(entryMethods[name], "%s does not support the entry.%s method of this Go version", flag, name)
+	}
+	if data == nil {
+		data = make(map[string]string)
+	}
+	data["Entry"], data["Expunged"], data["NewEntry"], data["Value"] = entry, expunged, newEntry, g.value
+	b := bytes.NewBuffer(nil)
+	err := t.Execute(b, data)
+	check(err, "execute %s entry template", flag)
+	g.appendSource(b.Bytes())
+}
+
+// removeDecls removes the declarations of the file that match the given function, together
+// with their comments.
+func removeDecls(f *ast.File, remove func(ast.Decl) bool) {
+	var (
+		decls  []ast.Decl
+		ranges [][2]token.Pos
+	)
+	for _, d := range f.Decls {
+		if !remove(d) {
+			decls = append(decls, d)
+			continue
+		}
+		start := d.Pos()
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+		}
+		ranges = append(ranges, [2]token.Pos{start, d.End()})
+	}
+	f.Decls = decls
+	var comments []*ast.CommentGroup
+Comments:
+	for _, c := range f.Comments {
+		for _, r := range ranges {
+			if c.Pos() >= r[0] && c.Pos() < r[1] {
+				continue Comments
+			}
+		}
+		comments = append(comments, c)
+	}
+	f.Comments = comments
+}
+
+// entryMethods holds the entry methods that are implemented by the entries of replaceEntry.
+var entryMethods = map[string]bool{
+	"load":             true,
+	"tryStore":         true,
+	"unexpungeLocked":  true,
+	"storeLocked":      true,
+	"tryLoadOrStore":   true,
+	"delete":           true,
+	"tryExpungeLocked": true,
+}
+
+var inlineTmpl = template.Must(template.New("inline").Parse(`
+// An entry is a slot in the map corresponding to a particular key.
+//
+// Unlike the entry of sync.Map, the value is stored inline and guarded by mu, instead
+// of being stored as an atomic pointer to a copy of it. An entry that holds no value
+// has been deleted. If expunged is set, the entry has also been deleted from the dirty
+// map, and its value can be updated only after first setting m.dirty[key] = e so that
+// lookups using the dirty map find the entry.
+type {{.Entry}} struct {
+	mu       sync.Mutex
+	ok       bool // the entry holds a value.
+	expunged bool // the entry is missing from m.dirty.
+	v        {{.Value}}
+}
+
+func {{.NewEntry}}(i {{.Value}}) *{{.Entry}} {
+	return &{{.Entry}}{ok: true, v: i}
+}
+
+func (e *{{.Entry}}) load() (value {{.Value}}, ok bool) {
+	e.mu.Lock()
+	value, ok = e.v, e.ok
+	e.mu.Unlock()
+	return value, ok
+}
+
+// tryStore stores a value if the entry has not been expunged.
+//
+// If the entry is expunged, tryStore returns false and leaves the entry
+// unchanged.
+func (e *{{.Entry}}) tryStore(i *{{.Value}}) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.expunged {
+		return false
+	}
+	e.v, e.ok = *i, true
+	return true
+}
+
+// unexpungeLocked ensures that the entry is not marked as expunged.
+//
+// If the entry was previously expunged, it must be added to the dirty map
+// before m.mu is unlocked.
+func (e *{{.Entry}}) unexpungeLocked() (wasExpunged bool) {
+	e.mu.Lock()
+	wasExpunged, e.expunged = e.expunged, false
+	e.mu.Unlock()
+	return wasExpunged
+}
+
+// storeLocked unconditionally stores a value to the entry.
+//
+// The entry must be known not to be expunged.
+func (e *{{.Entry}}) storeLocked(i *{{.Value}}) {
+	e.mu.Lock()
+	e.v, e.ok = *i, true
+	e.mu.Unlock()
+}
+
+// tryLoadOrStore atomically loads or stores a value if the entry is not
+// expunged.
+//
+// If the entry is expunged, tryLoadOrStore leaves the entry unchanged and
+// returns with ok==false.
+func (e *{{.Entry}}) tryLoadOrStore(i {{.Value}}) (actual {{.Value}}, loaded, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.expunged {
+		return actual, false, false
+	}
+	if e.ok {
+		return e.v, true, true
+	}
+	e.v, e.ok = i, true
+	return i, false, true
+}
+
+func (e *{{.Entry}}) delete() (value {{.Value}}, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.ok {
+		return value, false
+	}
+	// Reset the value to release the memory it references.
+	value, e.v, e.ok = e.v, value, false
+	return value, true
+}
+
+func (e *{{.Entry}}) tryExpungeLocked() (isExpunged bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.ok {
+		e.expunged = true
+	}
+	return e.expunged
+}
+`))
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+)
+
+// addInternal renames the map type to an unexported implementation type, and declares the
+// map type as a struct that embeds it. The exported methods of the implementation are
+// promoted to the map, and the fields of the implementation are hidden from the docs of
+// the map. The zero value of the map is ready for use, as the zero value of its
+// implementation is. The functions that are not methods (e.g. the constructor of
+// -capacity) keep using the map type, whose fields are promoted as well.
+func (g *Generator) addInternal() {
+	impl := g.internalType()
+	var doc []string
+	for _, d := range g.file.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				g.logf("renaming %s to %s", g.Name, impl)
+				expect(d.Doc != nil && len(d.Doc.List) > 0, "missing doc comment of %s", g.Name)
+				for _, c := range d.Doc.List {
+					doc = append(doc, c.Text)
+				}
+				// The comment group is shared with the comments of the file, and it
+				// is replaced in place by its last comment, adjacent to the type.
+				d.Doc.List = d.Doc.List[len(d.Doc.List)-1:]
+				d.Doc.List[0].Text = fmt.Sprintf("// %s is the implementation of %s, which embeds it.", impl, g.Name)
+				t.Name.Name = impl
+			}
+		case *ast.FuncDecl:
+			if g.isMethod(d) {
+				d.Recv.List[0].Type.(*ast.StarExpr).X.(*ast.Ident).Name = impl
+			}
+		}
+	}
+	expect(doc != nil, "-internal: missing type %s", g.Name)
+	g.appendSource([]byte(fmt.Sprintf(`
+%[3]s
+type %[1]s struct {
+	%[2]s
+}
+`, g.Name, impl, strings.Join(doc, "\n"))))
+}
+
+// internalType returns the name of the implementation type generated by -internal.
+func (g *Generator) internalType() string {
+	return "impl" + strings.Title(g.Name)
+}
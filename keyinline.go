@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// addEntryKeys adds a key field to the entry of the map, and rewrites Range to read the
+// keys from the entries instead of from the map it ranges over. The key is set by newEntry,
+// which gets the key that the entry is stored with in the dirty map. The key of an entry
+// never changes: the stores of existing keys update the value of their entries.
+func (g *Generator) addEntryKeys() {
+	names := g.renames()
+	entry, newEntry := names["entry"], names["newEntry"]
+	var ranged bool
+	for _, d := range g.file.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == entry {
+				g.logf("adding the key field to %s", entry)
+				appendFields(t.Type.(*ast.StructType), "key "+g.key)
+			}
+		case *ast.FuncDecl:
+			switch {
+			case d.Recv == nil && d.Name.Name == newEntry:
+				g.addKeyParam(d, entry)
+			case d.Recv != nil && d.Name.Name == "Range":
+				ranged = g.rangeEntryKeys(d)
+			case d.Recv != nil:
+				g.passEntryKeys(d, newEntry)
+			}
+		}
+	}
+	expect(ranged, "-keyinline: unsupported Range method of this Go version")
+}
+
+// addKeyParam adds the key parameter to newEntry, and sets the key field of the entries
+// it creates.
+func (g *Generator) addKeyParam(d *ast.FuncDecl, entry string) {
+	x, err := parser.ParseExpr(fmt.Sprintf("func(key %s)", g.key))
+	check(err, "parse key parameter")
+	param := x.(*ast.FuncType).Params.List[0]
+	setAllPos(param, d.Type.Params.Opening)
+	d.Type.Params.List = append([]*ast.Field{param}, d.Type.Params.List...)
+	var set bool
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		if id, ok := lit.Type.(*ast.Ident); ok && id.Name == entry {
+			x, err := parser.ParseExpr("T{key: key}")
+			check(err, "parse key field")
+			kv := x.(*ast.CompositeLit).Elts[0]
+			setAllPos(kv, lit.Rbrace)
+			lit.Elts = append(lit.Elts, kv)
+			set = true
+		}
+		return true
+	})
+	expect(set, "-keyinline: unsupported %s of this Go version", d.Name.Name)
+}
+
+// passEntryKeys passes the keys to the newEntry calls of the given map method. The calls
+// must be assigned to the dirty map, e.g. m.dirty[key] = newEntry(value), whose index is
+// the key of the entry.
+func (g *Generator) passEntryKeys(d *ast.FuncDecl, newEntry string) {
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			if assign, ok := n.(*ast.AssignStmt); ok && len(assign.Lhs) == 1 && len(assign.Rhs) == 1 {
+				index, isIndex := assign.Lhs[0].(*ast.IndexExpr)
+				call, isCall := assign.Rhs[0].(*ast.CallExpr)
+				if isIndex && isCall && isIdent(call.Fun, newEntry) {
+					g.logf("passing the key to %s in %s", newEntry, d.Name.Name)
+					call.Args = append([]ast.Expr{index.Index}, call.Args...)
+					// The arguments are not visited, as the call is handled.
+					return false
+				}
+			}
+			return true
+		}
+		expect(!isIdent(call.Fun, newEntry), "-keyinline: unsupported call of %s in %s. expected an assignment to the dirty map", newEntry, d.Name.Name)
+		return true
+	})
+}
+
+// rangeEntryKeys rewrites the loop of Range over the entries of the read-only map to read
+// the keys from the entries. It reports whether the loop was found.
+func (g *Generator) rangeEntryKeys(d *ast.FuncDecl) (found bool) {
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		loop, ok := n.(*ast.RangeStmt)
+		if !ok || found {
+			return !found
+		}
+		k, kok := loop.Key.(*ast.Ident)
+		e, eok := loop.Value.(*ast.Ident)
+		if !kok || !eok || k.Name == "_" {
+			return true
+		}
+		g.logf("reading the keys of the entries in %s", d.Name.Name)
+		astutil.Apply(loop.Body, func(c *astutil.Cursor) bool {
+			if id, ok := c.Node().(*ast.Ident); ok && id.Name == k.Name {
+				if s, ok := c.Parent().(*ast.SelectorExpr); ok && s.Sel == id {
+					return true
+				}
+				x, err := parser.ParseExpr(e.Name + ".key")
+				check(err, "parse entry key")
+				setAllPos(x, id.Pos())
+				c.Replace(x)
+			}
+			return true
+		}, nil)
+		loop.Key = &ast.Ident{Name: "_", NamePos: k.NamePos}
+		found = true
+		return false
+	})
+	return found
+}
+
+// isIdent reports whether x is the identifier with the given name.
+func isIdent(x ast.Expr, name string) bool {
+	id, ok := x.(*ast.Ident)
+	return ok && id.Name == name
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// lineOut is a placeholder line directive that marks the end of the code that originates
+// from sync/map.go. It is replaced by a directive that resets the positions back to the
+// generated file, once the final line numbers are known.
+const lineOut = "//line syncmap.out:1"
+
+// addLineDirectives adds a //line directive before each top-level declaration of the mutated
+// file, that maps it back to its position in sync/map.go. The lines inside a declaration are
+// mapped to the lines following the original position, so they are accurate as long as the
+// mutations did not add lines to the declaration (e.g. the instrumentation of -metrics).
+func (g *Generator) addLineDirectives() {
+	for _, d := range g.file.Decls {
+		if d, ok := d.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			continue
+		}
+		// The directive is placed between the doc comment and the declaration.
+		c := &ast.Comment{
+			Slash: d.Pos() - 1,
+			Text:  fmt.Sprintf("//line %s:%d", filepath.ToSlash(g.srcPath), g.fset.Position(d.Pos()).Line),
+		}
+		g.file.Comments = append(g.file.Comments, &ast.CommentGroup{List: []*ast.Comment{c}})
+	}
+	sort.Slice(g.file.Comments, func(i, j int) bool {
+		return g.file.Comments[i].Pos() < g.file.Comments[j].Pos()
+	})
+}
+
+// fixLineDirectives replaces the lineOut placeholders in the formatted source with directives
+// that point to the following line in the generated file.
+func fixLineDirectives(path string, src []byte) []byte {
+	lines := bytes.Split(src, []byte("\n"))
+	for i, l := range lines {
+		if string(l) == lineOut {
+			// Lines are 1-based, and the directive applies to the line after it.
+			lines[i] = []byte("//line " + filepath.Base(path) + ":" + strconv.Itoa(i+2))
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"text/template"
+)
+
+// addLoadAndDelete appends a LoadAndDelete method, like the one of sync.Map in Go 1.15, for
+// maps generated from a sync/map.go that predates it. The entries of these versions can not
+// return the deleted value, and an entry method that does is added as well, unless the
+// entries are replaced by -valueinline or -slab, whose delete method returns it. Maps that
+// already have the method are left as is.
+func (g *Generator) addLoadAndDelete() {
+	for _, d := range g.file.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Recv != nil && d.Name.Name == "LoadAndDelete" {
+			g.logf("%s already has a LoadAndDelete method", g.Name)
+			return
+		}
+	}
+	g.checkLayout("load and delete")
+	names := g.renames()
+	data := map[string]interface{}{
+		"Name":     g.Name,
+		"Key":      g.key,
+		"Value":    g.value,
+		"Entry":    names["entry"],
+		"ReadOnly": names["readOnly"],
+		"Expunged": names["expunged"],
+		"Delete":   "loadAndDelete",
+	}
+	if g.Inline || g.Slab {
+		data["Delete"] = "delete"
+	}
+	b := bytes.NewBuffer(nil)
+	err := loadAndDeleteTmpl.Execute(b, data)
+	check(err, "execute load and delete template")
+	g.appendSource(b.Bytes())
+}
+
+var loadAndDeleteTmpl = template.Must(template.New("loadanddelete").Parse(`
+// LoadAndDelete deletes the value for a key, returning the previous value if any.
+// The loaded result reports whether the key was present.
+func (m *{{.Name}}) LoadAndDelete(key {{.Key}}) (value {{.Value}}, loaded bool) {
+	read, _ := m.read.Load().({{.ReadOnly}})
+	e, ok := read.m[key]
+	if !ok && read.amended {
+		m.mu.Lock()
+		read, _ = m.read.Load().({{.ReadOnly}})
+		e, ok = read.m[key]
+		if !ok && read.amended {
+			e, ok = m.dirty[key]
+			delete(m.dirty, key)
+			// Regardless of whether the entry was present, record a miss: this key
+			// will take the slow path until the dirty map is promoted to the read
+			// map.
+			m.missLocked()
+		}
+		m.mu.Unlock()
+	}
+	if ok {
+		return e.{{.Delete}}()
+	}
+	return value, false
+}
+{{- if eq .Delete "loadAndDelete"}}
+
+// loadAndDelete deletes the value of the entry, and returns it.
+func (e *{{.Entry}}) loadAndDelete() (value {{.Value}}, ok bool) {
+	for {
+		p := atomic.LoadPointer(&e.p)
+		if p == nil || p == {{.Expunged}} {
+			return value, false
+		}
+		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
+			return *(*{{.Value}})(p), true
+		}
+	}
+}
+{{- end}}
+`))
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+)
+
+// methodsData is the data passed to the template of -methods.
+type methodsData struct {
+	helperData
+	Recv string // receiver type of the map methods. e.g. *UserMap.
+}
+
+// readMethods reads and parses the template of -methods. Relative paths are resolved
+// against the directory of the output file, like the file of -tags.
+func (g *Generator) readMethods(path string) *template.Template {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(g.Out), path)
+	}
+	b, err := ioutil.ReadFile(path)
+	check(err, "read -methods file")
+	t, err := template.New(filepath.Base(path)).Parse(string(b))
+	check(err, "parse -methods template")
+	return t
+}
+
+// addMethods appends the custom declarations rendered by the template of -methods. The
+// declarations can not add imports. They are resolved by goimports, or by the Format hook.
+func (g *Generator) addMethods() {
+	b := bytes.NewBuffer(nil)
+	err := g.methods.Execute(b, methodsData{helperData: g.helperData(), Recv: "*" + g.Name})
+	check(err, "execute -methods template")
+	src := b.String()
+	// Validate the declarations apart from the map, for an error that points to the
+	// lines of the rendered template.
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package "+g.Pkg+"\n//line "+g.Methods+":1\n"+src, 0)
+	check(err, "-methods template renders invalid declarations")
+	expect(len(f.Imports) == 0, "-methods template renders imports. they are added by goimports")
+	g.logf("adding the methods of %s", g.Methods)
+	g.appendSource([]byte("\n" + src))
+}
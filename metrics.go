@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"text/template"
+)
+
+// addMetrics adds operation counters to the map struct, instruments the map methods to update
+// them, and appends the Stats method that reports them.
+func (g *Generator) addMetrics() {
+	g.logf("adding import \"sync/atomic\" for the metrics")
+	g.addImport(g.file, "", "sync/atomic")
+	for _, d := range g.file.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				// The counters are placed first to guarantee the 64-bit alignment
+				// required by the atomic operations on 32-bit platforms.
+				prependFields(t.Type.(*ast.StructType), "nhits int64; nmisses int64; nstores int64; ndeletes int64")
+			}
+		case *ast.FuncDecl:
+			if !g.isMethod(d) {
+				continue
+			}
+			m := d.Recv.List[0].Names[0].Name
+			switch d.Name.Name {
+			case "Load":
+				ok := lastResult(d)
+				prepend(d, fmt.Sprintf(`defer func() {
+					if %[2]s {
+						atomic.AddInt64(&%[1]s.nhits, 1)
+					} else {
+						atomic.AddInt64(&%[1]s.nmisses, 1)
+					}
+				}()`, m, ok))
+			case "LoadOrStore":
+				loaded := lastResult(d)
+				prepend(d, fmt.Sprintf(`defer func() {
+					if %[2]s {
+						atomic.AddInt64(&%[1]s.nhits, 1)
+					} else {
+						atomic.AddInt64(&%[1]s.nmisses, 1)
+						atomic.AddInt64(&%[1]s.nstores, 1)
+					}
+				}()`, m, loaded))
+			case "LoadAndDelete":
+				loaded := lastResult(d)
+				prepend(d, fmt.Sprintf(`defer func() {
+					if %[2]s {
+						atomic.AddInt64(&%[1]s.ndeletes, 1)
+					}
+				}()`, m, loaded))
+			case "Store":
+				prepend(d, fmt.Sprintf("atomic.AddInt64(&%s.nstores, 1)", m))
+			}
+		}
+	}
+	b := bytes.NewBuffer(nil)
+	err := metricsTmpl.Execute(b, g.helperData())
+	check(err, "execute metrics template")
+	g.appendSource(b.Bytes())
+}
+
+var metricsTmpl = template.Must(template.New("metrics").Funcs(template.FuncMap{"article": article}).Parse(`
+// {{.Name}}Stats holds the operation counters of {{article .Name}} {{.Name}}.
+type {{.Name}}Stats struct {
+	Loads   int64 // Loads is the number of loads, including LoadOrStore calls. i.e. Hits + Misses.
+	Hits    int64 // Hits is the number of loads that found the key.
+	Misses  int64 // Misses is the number of loads that did not find the key.
+	Stores  int64 // Stores is the number of stores, including LoadOrStore calls that stored the value.
+	Deletes int64 // Deletes is the number of entries that were deleted.
+}
+
+// Stats returns the operation counters of the map. The counters are read atomically
+// one by one, and therefore they may be inconsistent with each other under concurrent
+// operations.
+func (m *{{.Name}}) Stats() {{.Name}}Stats {
+	s := {{.Name}}Stats{
+		Hits:    atomic.LoadInt64(&m.nhits),
+		Misses:  atomic.LoadInt64(&m.nmisses),
+		Stores:  atomic.LoadInt64(&m.nstores),
+		Deletes: atomic.LoadInt64(&m.ndeletes),
+	}
+	s.Loads = s.Hits + s.Misses
+	return s
+}
+`))
+
+// isMethod reports if the given function is a method of the generated map.
+func (g *Generator) isMethod(f *ast.FuncDecl) bool {
+	if f.Recv == nil || len(f.Recv.List) != 1 {
+		return false
+	}
+	star, ok := f.Recv.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	id, ok := star.X.(*ast.Ident)
+	return ok && id.Name == g.Name
+}
+
+// lastResult returns the name of the last result of the given function.
+func lastResult(f *ast.FuncDecl) string {
+	expect(f.Type.Results != nil, "function %s has no results", f.Name.Name)
+	l := f.Type.Results.List[len(f.Type.Results.List)-1]
+	expect(len(l.Names) > 0, "function %s has unnamed results", f.Name.Name)
+	return l.Names[len(l.Names)-1].Name
+}
+
+// prepend inserts the given statements at the beginning of the function body.
+func prepend(f *ast.FuncDecl, s string) {
+	f.Body.List = append(stmts(s, f.Body.Lbrace), f.Body.List...)
+}
+
+// stmts parses the given statements and positions them at p.
+func stmts(s string, p token.Pos) []ast.Stmt {
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package p\nfunc _() {\n"+s+"\n}", 0)
+	check(err, "parse statements: %q", s)
+	body := f.Decls[0].(*ast.FuncDecl).Body
+	setAllPos(body, p)
+	return body.List
+}
+
+// prependFields parses the given fields and inserts them at the beginning of the struct.
+// It fails if the struct already has a field with the same name.
+func prependFields(st *ast.StructType, s string) {
+	fields := parseFields(st, s, st.Fields.Opening)
+	st.Fields.List = append(fields, st.Fields.List...)
+}
+
+// appendFields parses the given fields and inserts them at the end of the struct.
+// It fails if the struct already has a field with the same name.
+func appendFields(st *ast.StructType, s string) {
+	fields := parseFields(st, s, st.Fields.Closing)
+	st.Fields.List = append(st.Fields.List, fields...)
+}
+
+// parseFields parses the given fields of the struct, and sets their positions to p.
+func parseFields(st *ast.StructType, s string, p token.Pos) []*ast.Field {
+	exp, err := parser.ParseExpr("struct{" + s + "}")
+	check(err, "parse fields: %q", s)
+	fields := exp.(*ast.StructType).Fields
+	setAllPos(fields, p)
+	for _, f := range fields.List {
+		for _, n := range f.Names {
+			for _, sf := range st.Fields.List {
+				for _, sn := range sf.Names {
+					expect(sn.Name != n.Name, "field %s already exists in the map struct", n.Name)
+				}
+			}
+		}
+	}
+	return fields.List
+}
+
+// setAllPos sets all valid positions in the given node to p. Unlike setPos, it supports any
+// node. Invalid positions are kept as is, as their validity is meaningful for some nodes.
+// e.g. the Ellipsis of a CallExpr.
+func setAllPos(n ast.Node, p token.Pos) {
+	pos := reflect.TypeOf(token.NoPos)
+	ast.Inspect(n, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		v := reflect.ValueOf(n).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.Type() == pos && f.Int() != 0 {
+				f.SetInt(int64(p))
+			}
+		}
+		return true
+	})
+}
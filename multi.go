@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GenerateFiles generates the maps of the given configs into their output files, e.g. for
+// the related maps of several packages. The configs of the same output file are generated
+// into it as by GenerateFile, and the files are generated in the order of their first map.
+// The generator is shared by all the files, and sync/map.go (or Source) is read once, and
+// parsed again for each map, as the mutations modify its AST. The package clause and the
+// imports of each file are set by its own configs, and resolved using its own directory.
+func GenerateFiles(cs []Config) (err error) {
+	defer catch(&err)
+	expect(len(cs) > 0, "no maps to generate")
+	var outs []string
+	files := make(map[string][]Config)
+	for _, c := range cs {
+		if _, ok := files[c.Out]; !ok {
+			outs = append(outs, c.Out)
+		}
+		files[c.Out] = append(files[c.Out], c)
+	}
+	g := newGenerator()
+	for _, out := range outs {
+		if err := g.generateFile(files[out]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GenerateFile generates the maps of the given configs into a single file, e.g. for keeping
+// related maps together. The file has one package clause and one import block, merged from
+// the imports of all the maps, and each map is preceded by a section comment. The configs
+// must differ only by the Name and the Spec of the maps, as the ones returned by ParseConfigs.
+// A single config is generated as by Generate.
+func GenerateFile(cs []Config) error {
+	return newGenerator().generateFile(cs)
+}
+
+// newGenerator returns a generator that is not configured yet. It must be reset before it
+// is used.
+func newGenerator() *Generator {
+	return &Generator{goroot: runtime.GOROOT(), stderr: os.Stderr}
+}
+
+// generateFile generates the maps of the given configs into a single file, as GenerateFile,
+// and resets the generator for each of them.
+func (g *Generator) generateFile(cs []Config) (err error) {
+	defer catch(&err)
+	expect(len(cs) > 0, "no maps to generate")
+	if len(cs) == 1 {
+		if err := g.Reset(cs[0]); err != nil {
+			return err
+		}
+		if err := g.Mutate(); err != nil {
+			return err
+		}
+		return g.Gen()
+	}
+	var (
+		prefix   []byte
+		sections = bytes.NewBuffer(nil)
+		specs    = make(map[importSpec]bool)
+		declared = make(map[string]string) // top-level identifiers, mapped to the maps declaring them.
+		stubs    = make(map[string]int)    // stubs of verify, of the types of all the maps.
+	)
+	for i, c := range cs {
+		expect(c.Out != "", "-o is required with several -name")
+		expect(c.Out == cs[0].Out && c.Pkg == cs[0].Pkg, "maps of one file must have the same output file and package")
+		// The extension and the test files are named after the output file, and would
+		// collide.
+		expect(!c.Ext && c.Tags == "" && c.Size == 0 && !c.Zero, "-ext, -tags, -assert-size and -assert-zero can not be used with several -name")
+		// The generator is reused for reading sync/map.go once.
+		if err := g.Reset(c); err != nil {
+			return err
+		}
+		if err := g.Mutate(); err != nil {
+			return err
+		}
+		src := g.source()
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, c.Out, src, parser.ParseComments)
+		check(err, "parse generated code of %s", c.Name)
+		end := f.Name.End()
+		for _, d := range f.Decls {
+			if d, ok := d.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+				end = d.End()
+			}
+		}
+		for _, s := range f.Imports {
+			path, err := strconv.Unquote(s.Path.Value)
+			check(err, "unquote import path %s", s.Path.Value)
+			spec := importSpec{path: path}
+			if s.Name != nil {
+				spec.name = s.Name.Name
+			}
+			specs[spec] = true
+		}
+		for _, name := range topLevelNames(f) {
+			other, ok := declared[name]
+			expect(!ok, "identifier %s is declared by both %s and %s. use different -name", name, other, c.Name)
+			declared[name] = c.Name
+		}
+		for name, n := range g.stubTypes() {
+			stubs[name] = n
+		}
+		if i == 0 {
+			// The comments above the package clause, e.g. the copyright notice.
+			prefix = src[:fset.Position(f.Package).Offset]
+		}
+		kind := g.Spec
+		if c.Set {
+			kind = "set of " + g.Spec
+		}
+		fmt.Fprintf(sections, "\n// --- %s (%s) ---\n\n", c.Name, kind)
+		sections.Write(bytes.TrimLeft(src[fset.Position(end).Offset:], "\n"))
+	}
+	sorted := make([]importSpec, 0, len(specs))
+	for s := range specs {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].path != sorted[j].path {
+			return sorted[i].path < sorted[j].path
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	b := bytes.NewBuffer(nil)
+	g.writeFileHeader(b)
+	b.Write(prefix)
+	fmt.Fprintf(b, "package %s\n\nimport (\n", g.Pkg)
+	for _, s := range sorted {
+		fmt.Fprintf(b, "\t%s\n", s)
+	}
+	b.WriteString(")\n")
+	b.Write(sections.Bytes())
+	g.write(g.Out, b.Bytes())
+	if g.Verify {
+		g.verify(stubs)
+	}
+	return nil
+}
+
+// topLevelNames returns the names of the top-level identifiers declared in the file,
+// excluding methods and blank identifiers.
+func topLevelNames(f *ast.File) []string {
+	var names []string
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				names = append(names, d.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				switch s := s.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						if n.Name != "_" {
+							names = append(names, n.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
+
+// fileFlags returns the flags of the command-line arguments, without the -name, -o and -pkg
+// flags and their values. The arguments must be parsed by fs.
+func fileFlags(fs *flag.FlagSet, args []string) []string {
+	var flags []string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		n := 1
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name = name[:j]
+		} else if f := fs.Lookup(name); f != nil {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				// The value is the next argument.
+				n = 2
+			}
+		}
+		if name != "name" && name != "o" && name != "pkg" {
+			flags = append(flags, args[i:i+n]...)
+		}
+		i += n - 1
+	}
+	return flags
+}
+
+// fileArgs returns the command-line arguments of the maps of the given output file: the
+// given flags, followed by the output file, its package, and the names and the types of
+// its maps.
+func fileArgs(flags []string, cs []Config, out string) []string {
+	args := append(append([]string(nil), flags...), "-o", out)
+	var types []string
+	for _, c := range cs {
+		if c.Out != out {
+			continue
+		}
+		if types == nil {
+			args = append(args, "-pkg", c.Pkg)
+		}
+		args = append(args, "-name", c.Name)
+		types = append(types, c.Spec)
+	}
+	return append(args, types...)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/types"
+	"text/template"
+)
+
+// multimapMethods maps the methods of the map that write the slices of the keys to the
+// unexported names they get in multimaps, in order to serialize them with Append.
+var multimapMethods = map[string]string{
+	"Store":         "store",
+	"LoadOrStore":   "loadOrStore",
+	"LoadAndDelete": "loadAndDelete",
+	"Delete":        "delete",
+	"Clear":         "clear",
+}
+
+// checkMultimap fails if the value type of -multimap is not a slice.
+func (g *Generator) checkMultimap() {
+	s, ok := g.mapType.Value.(*ast.ArrayType)
+	expect(ok && s.Len == nil, "-multimap: value type %s is not a slice. expected map[K][]V", g.value)
+}
+
+// addMultimap appends the Append and Get methods of multimaps. Append is a read-modify-write
+// of the slice of the key, and the writes of the map are serialized by a mutex, in order to
+// not lose the values that are appended or stored concurrently. The reads are not affected.
+// The slices are copied on write, and the slices that were loaded are never modified.
+func (g *Generator) addMultimap() {
+	for _, d := range g.file.Decls {
+		if d, ok := d.(*ast.GenDecl); ok {
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				appendFields(t.Type.(*ast.StructType), "writeMu sync.Mutex")
+			}
+		}
+	}
+	g.renameMethods(multimapMethods)
+	b := bytes.NewBuffer(nil)
+	err := multimapTmpl.Execute(b, struct {
+		helperData
+		Elem  string
+		Clear bool
+	}{g.helperData(), types.ExprString(g.mapType.Value.(*ast.ArrayType).Elt), g.Clear})
+	check(err, "execute multimap template")
+	g.appendSource(b.Bytes())
+}
+
+var multimapTmpl = template.Must(template.New("multimap").Parse(`
+// Append appends the values to the slice of the key, and stores the new slice. The values
+// appended by concurrent calls are not lost. The slice is copied, and the slices that
+// were loaded before are not modified.
+func (m *{{.Name}}) Append(key {{.Key}}, values ...{{.Elem}}) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	s, _ := m.{{.Load}}(key)
+	m.store(key, append(s[:len(s):len(s)], values...))
+}
+
+// Get returns the slice of the key, or nil if the key is not present. The slice is shared
+// with the map, and it must not be modified.
+func (m *{{.Name}}) Get(key {{.Key}}) {{.Value}} {
+	s, _ := m.{{.Load}}(key)
+	return s
+}
+
+// Store sets the slice of a key.
+func (m *{{.Name}}) Store(key {{.Key}}, value {{.Value}}) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.store(key, value)
+}
+
+// LoadOrStore returns the existing slice of the key if present.
+// Otherwise, it stores and returns the given slice.
+// The loaded result is true if the slice was loaded, false if stored.
+func (m *{{.Name}}) LoadOrStore(key {{.Key}}, value {{.Value}}) (actual {{.Value}}, loaded bool) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	return m.loadOrStore(key, value)
+}
+
+// LoadAndDelete deletes the slice of a key, returning the previous slice if any.
+// The loaded result reports whether the key was present.
+func (m *{{.Name}}) LoadAndDelete(key {{.Key}}) (value {{.Value}}, loaded bool) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	return m.loadAndDelete(key)
+}
+
+// Delete deletes the slice of a key.
+func (m *{{.Name}}) Delete(key {{.Key}}) {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.delete(key)
+}
+{{- if .Clear}}
+
+// Clear deletes all the entries, resulting in an empty map.
+func (m *{{.Name}}) Clear() {
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+	m.clear()
+}
+{{- end}}
+`))
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+)
+
+// lockerStub is the number of type parameters of the stub of a local -mutex-type in verify,
+// which is declared with the methods of sync.Locker.
+const lockerStub = -1
+
+// checkMutexType parses the type of -mutex-type. The type replaces sync.Mutex as the mu
+// field of the map, whose pointer calls Lock and Unlock. Hence, it must be a named type
+// whose pointer implements sync.Locker, and whose zero value is an unlocked mutex, as the
+// zero map is ready for use. Its package is imported like the packages of the map types.
+func (g *Generator) checkMutexType() {
+	x, err := parser.ParseExpr(g.MutexType)
+	check(err, "parse -mutex-type: %s", g.MutexType)
+	switch t := x.(type) {
+	case *ast.Ident:
+		expect(t.Name != "_", "-mutex-type: invalid type %s", g.MutexType)
+	case *ast.SelectorExpr:
+		_, ok := t.X.(*ast.Ident)
+		expect(ok, "-mutex-type: invalid type %s. expected a named type, e.g. pkg.Mutex", g.MutexType)
+	default:
+		expect(false, "-mutex-type: invalid type %s. expected a named type, e.g. pkg.Mutex", g.MutexType)
+	}
+	g.mutexType = x
+}
+
+// mutex returns the type of the mu field of the map.
+func (g *Generator) mutex() string {
+	if g.MutexType == "" {
+		return "sync.Mutex"
+	}
+	return g.MutexType
+}
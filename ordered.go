@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"text/template"
+)
+
+// orderedMethods maps the methods of the map that change its set of keys to the unexported
+// names they get in ordered maps, in order to wrap them with the methods that track the
+// insertion order.
+var orderedMethods = map[string]string{
+	"Store":         "store",
+	"LoadOrStore":   "loadOrStore",
+	"LoadAndDelete": "loadAndDelete",
+	"Delete":        "delete",
+	"Clear":         "clear",
+}
+
+// addOrdered tracks the insertion order of the keys in a list next to the map, and appends
+// the RangeOrdered method that iterates over the map in this order. The writes of the map
+// are wrapped to update the list, and are serialized by the mutex that guards it.
+func (g *Generator) addOrdered() {
+	g.logf("adding import \"container/list\" for the insertion order")
+	g.addImport(g.file, "", "container/list")
+	for _, d := range g.file.Decls {
+		if d, ok := d.(*ast.GenDecl); ok {
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				appendFields(t.Type.(*ast.StructType), fmt.Sprintf("orderMu sync.Mutex; order list.List; orderIndex map[%s]*list.Element", g.key))
+			}
+		}
+	}
+	g.renameMethods(orderedMethods)
+	b := bytes.NewBuffer(nil)
+	err := orderedTmpl.Execute(b, struct {
+		helperData
+		Clear bool
+	}{g.helperData(), g.Clear})
+	check(err, "execute ordered template")
+	g.appendSource(b.Bytes())
+}
+
+var orderedTmpl = template.Must(template.New("ordered").Parse(`
+// Store sets the value for a key. A new key is added at the end of the insertion order,
+// and an existing key keeps its position.
+func (m *{{.Name}}) Store(key {{.Key}}, value {{.Value}}) {
+	m.orderMu.Lock()
+	defer m.orderMu.Unlock()
+	m.store(key, value)
+	m.orderPush(key)
+}
+
+// LoadOrStore returns the existing value for the key if present.
+// Otherwise, it stores and returns the given value, and adds the key at the end
+// of the insertion order.
+// The loaded result is true if the value was loaded, false if stored.
+func (m *{{.Name}}) LoadOrStore(key {{.Key}}, value {{.Value}}) (actual {{.Value}}, loaded bool) {
+	m.orderMu.Lock()
+	defer m.orderMu.Unlock()
+	if actual, loaded = m.loadOrStore(key, value); !loaded {
+		m.orderPush(key)
+	}
+	return actual, loaded
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if any.
+// The loaded result reports whether the key was present.
+func (m *{{.Name}}) LoadAndDelete(key {{.Key}}) (value {{.Value}}, loaded bool) {
+	m.orderMu.Lock()
+	defer m.orderMu.Unlock()
+	value, loaded = m.loadAndDelete(key)
+	m.orderRemove(key)
+	return value, loaded
+}
+
+// Delete deletes the value for a key.
+func (m *{{.Name}}) Delete(key {{.Key}}) {
+	m.orderMu.Lock()
+	defer m.orderMu.Unlock()
+	m.delete(key)
+	m.orderRemove(key)
+}
+{{- if .Clear}}
+
+// Clear deletes all the entries, resulting in an empty map.
+func (m *{{.Name}}) Clear() {
+	m.orderMu.Lock()
+	defer m.orderMu.Unlock()
+	m.clear()
+	m.order.Init()
+	m.orderIndex = nil
+}
+{{- end}}
+
+// RangeOrdered calls f sequentially for each key and value present in the map, in the
+// order the keys were added to the map. If f returns false, range stops the iteration.
+//
+// RangeOrdered iterates over a snapshot of the keys, and loads their values as it goes.
+// Keys that are deleted during the iteration are skipped, and keys that are added are not
+// visited. f may call any of the map methods.
+func (m *{{.Name}}) RangeOrdered(f func(key {{.Key}}, value {{.Value}}) bool) {
+	m.orderMu.Lock()
+	keys := make([]{{.Key}}, 0, m.order.Len())
+	for e := m.order.Front(); e != nil; e = e.Next() {
+		keys = append(keys, e.Value.({{.Key}}))
+	}
+	m.orderMu.Unlock()
+	for _, key := range keys {
+		if value, ok := m.{{.Load}}(key); ok && !f(key, value) {
+			break
+		}
+	}
+}
+
+// orderPush adds the key at the end of the insertion order, if it is not there already.
+// It must be called with orderMu held.
+func (m *{{.Name}}) orderPush(key {{.Key}}) {
+	if _, ok := m.orderIndex[key]; ok {
+		return
+	}
+	if m.orderIndex == nil {
+		m.orderIndex = make(map[{{.Key}}]*list.Element)
+	}
+	m.orderIndex[key] = m.order.PushBack(key)
+}
+
+// orderRemove removes the key from the insertion order. It must be called with orderMu held.
+func (m *{{.Name}}) orderRemove(key {{.Key}}) {
+	if e, ok := m.orderIndex[key]; ok {
+		m.order.Remove(e)
+		delete(m.orderIndex, key)
+	}
+}
+`))
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// loadPackages loads the output package with go/packages, and collects the packages imported
+// by its files, keyed by the names they are imported as. Unlike parsePackage, the import
+// paths and the package names are resolved by the go command (e.g. vendored or replaced
+// modules), and all the packages imported using the same name are kept. The generated
+// file itself is excluded, as it may be stale.
+func (g *Generator) loadPackages() {
+	g.packages = make(map[string][]*packages.Package)
+	dir, err := filepath.Abs(filepath.Dir(g.Out))
+	check(err, "-packages: resolve output directory")
+	// The packages are not type-checked. The types they declare are looked up in their
+	// syntax by resolveImports, which does not depend on the export data format of the
+	// Go toolchain.
+	cfg := &packages.Config{
+		Mode:  packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps,
+		Dir:   dir,
+		Tests: g.testPkg(),
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	check(err, "-packages: load package %s", dir)
+	out := filepath.Join(dir, filepath.Base(g.Out))
+	fset := token.NewFileSet()
+	for _, pkg := range pkgs {
+		if pkg.Name != g.Pkg {
+			continue
+		}
+		g.logf("loaded package %s from %s", pkg.PkgPath, dir)
+		for _, path := range pkg.GoFiles {
+			if path == out {
+				continue
+			}
+			f, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+			check(err, "-packages: parse %s", path)
+			for _, spec := range f.Imports {
+				path, err := strconv.Unquote(spec.Path.Value)
+				check(err, "-packages: unquote import path %s", spec.Path.Value)
+				imported, ok := pkg.Imports[path]
+				if !ok {
+					continue
+				}
+				name := imported.Name
+				if spec.Name != nil {
+					name = spec.Name.Name
+				}
+				g.addPackage(name, imported)
+			}
+		}
+	}
+}
+
+// addPackage adds an imported package to the packages imported using the given name,
+// unless it is already there.
+func (g *Generator) addPackage(name string, pkg *packages.Package) {
+	for _, p := range g.packages[name] {
+		if p.PkgPath == pkg.PkgPath {
+			return
+		}
+	}
+	g.packages[name] = append(g.packages[name], pkg)
+}
+
+// resolveImports returns the imports of the packages referenced by the given types, using
+// the packages loaded by loadPackages. The package of a qualified type is the one
+// that declares it among the packages imported using its qualifier. Packages that are not
+// imported by the output package are left for goimports to resolve.
+func (g *Generator) resolveImports(xs []ast.Expr) []importSpec {
+	var sels []*ast.SelectorExpr
+	for _, x := range xs {
+		sels = append(sels, qualifiedTypes(x)...)
+	}
+	var specs []importSpec
+	for _, s := range sels {
+		name := s.X.(*ast.Ident).Name
+		imported := g.packages[name]
+		if len(imported) == 0 {
+			continue
+		}
+		var found []string
+		for _, pkg := range imported {
+			if declaresType(pkg, s.Sel.Name) {
+				found = append(found, pkg.PkgPath)
+			}
+		}
+		sort.Strings(found)
+		expect(len(found) > 0, "-packages: type %s.%s is not declared in the packages imported as %s: %s", name, s.Sel.Name, name, importPaths(imported))
+		expect(len(found) == 1, "-packages: type %s.%s is ambiguous. it is declared in: %s", name, s.Sel.Name, strings.Join(found, ", "))
+		if name == importName(found[0]) {
+			specs = append(specs, importSpec{path: found[0]})
+		} else {
+			specs = append(specs, importSpec{name: name, path: found[0]})
+		}
+	}
+	return specs
+}
+
+// declaresType reports whether the package declares the exported type name in one of its
+// files.
+func declaresType(pkg *packages.Package, name string) bool {
+	if !ast.IsExported(name) {
+		return false
+	}
+	fset := token.NewFileSet()
+	for _, path := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		check(err, "-packages: parse %s", path)
+		for _, d := range f.Decls {
+			if d, ok := d.(*ast.GenDecl); ok && d.Tok == token.TYPE {
+				for _, s := range d.Specs {
+					if s.(*ast.TypeSpec).Name.Name == name {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// qualifiedTypes returns the qualified identifiers in the given type expression (e.g.
+// "user.ID" in "*user.ID"), in order of appearance.
+func qualifiedTypes(x ast.Expr) []*ast.SelectorExpr {
+	var sels []*ast.SelectorExpr
+	ast.Inspect(x, func(n ast.Node) bool {
+		s, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if _, ok := s.X.(*ast.Ident); ok {
+			sels = append(sels, s)
+		}
+		return false
+	})
+	return sels
+}
+
+// importPaths returns the sorted, comma-separated paths of the given packages.
+func importPaths(pkgs []*packages.Package) string {
+	paths := make([]string, len(pkgs))
+	for i, pkg := range pkgs {
+		paths[i] = pkg.PkgPath
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, ", ")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+)
+
+// addParent adds a parent map to the map struct, and appends the constructor of the maps
+// that have a parent. The loads fall back to the parent on a local miss, and LoadOrStore
+// returns the value of the parent instead of storing it locally. The writes are local, and
+// the parent is never modified by its children. It runs before the options that wrap the
+// methods with defers, e.g. -metrics, so that the loads that are served by the parent are
+// reported as hits.
+func (g *Generator) addParent() {
+	var loads int
+	for _, d := range g.file.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				appendFields(t.Type.(*ast.StructType), "parent *"+g.Name)
+			}
+		case *ast.FuncDecl:
+			if !g.isMethod(d) {
+				continue
+			}
+			m := d.Recv.List[0].Names[0].Name
+			switch d.Name.Name {
+			case "Load":
+				key, ok := d.Type.Params.List[0].Names[0].Name, lastResult(d)
+				value := d.Type.Results.List[0].Names[0].Name
+				prepend(d, fmt.Sprintf(`defer func() {
+					if !%[3]s && %[1]s.parent != nil {
+						%[4]s, %[3]s = %[1]s.parent.Load(%[2]s)
+					}
+				}()`, m, key, ok, value))
+				loads++
+			case "LoadOrStore":
+				key, loaded := d.Type.Params.List[0].Names[0].Name, lastResult(d)
+				actual := d.Type.Results.List[0].Names[0].Name
+				prepend(d, fmt.Sprintf(`if %[1]s.parent != nil {
+					// The value of the parent is returned, unless the key is stored locally.
+					if %[4]s, %[3]s = %[1]s.Load(%[2]s); %[3]s {
+						return %[4]s, %[3]s
+					}
+				}`, m, key, loaded, actual))
+				loads++
+			}
+		}
+	}
+	expect(loads == 2, "parent: missing Load or LoadOrStore method")
+	g.appendSource([]byte(fmt.Sprintf(`
+// %[1]s returns an empty %[2]s that inherits the entries of parent. Its loads fall back
+// to parent if the key is not stored in the map, and its writes are local: Store shadows
+// the value of parent, and parent is not modified. Delete deletes only the local value,
+// and the value of parent is loaded again afterwards. Range, LoadAndDelete and the other
+// methods see only the local entries.
+//
+// The parent may have a parent of its own, and it must not be the map itself or one of
+// its descendants.
+func %[1]s(parent *%[2]s) *%[2]s {
+	m := new(%[2]s)
+	m.parent = parent
+	return m
+}
+`, g.parentFunc(), g.Name)))
+}
+
+// parentFunc returns the name of the constructor generated by -parent.
+func (g *Generator) parentFunc() string {
+	return "New" + g.Name + "WithParent"
+}
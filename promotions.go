@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+)
+
+// addPromotions counts the promotions of the dirty map to the read-only map in an atomic
+// counter, and appends the PromotionStats method that reports it with the current number
+// of misses. The promotions happen at the end of missLocked, after the early return of
+// the misses that do not reach the threshold, i.e. the size of the dirty map. Range also
+// promotes the dirty map, regardless of the misses, and it is not counted.
+func (g *Generator) addPromotions() {
+	fields := g.mapFields()
+	for _, name := range []string{"mu", "misses"} {
+		expect(fields[name] != nil, "promotions: unsupported map layout. missing field: %s", name)
+	}
+	var patched bool
+	for _, d := range g.file.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				// The counter is placed first to guarantee the 64-bit alignment required
+				// by the atomic operations on 32-bit platforms.
+				prependFields(t.Type.(*ast.StructType), "npromotions int64")
+			}
+		case *ast.FuncDecl:
+			if !g.isMethod(d) || d.Name.Name != "missLocked" {
+				continue
+			}
+			var early bool
+			for _, s := range d.Body.List {
+				if s, ok := s.(*ast.IfStmt); ok && len(s.Body.List) > 0 {
+					if _, ok := s.Body.List[len(s.Body.List)-1].(*ast.ReturnStmt); ok {
+						early = true
+					}
+				}
+			}
+			expect(early, "promotions: unsupported missLocked method. missing the early return of the misses below the threshold")
+			m := d.Recv.List[0].Names[0].Name
+			d.Body.List = append(d.Body.List, stmts(fmt.Sprintf("atomic.AddInt64(&%s.npromotions, 1)", m), d.Body.Rbrace)...)
+			patched = true
+		}
+	}
+	expect(patched, "promotions: missing missLocked method")
+	g.addImport(g.file, "", "sync/atomic")
+	g.appendSource([]byte(fmt.Sprintf(`
+// PromotionStats returns the number of promotions of the dirty map to the read-only map
+// that were triggered by misses, and the number of misses since the last promotion. The
+// loads that miss the read-only map take the lock, and the dirty map is promoted when
+// their number reaches the number of its keys. Frequent promotions indicate that the new
+// keys are loaded soon after they are stored, and that the loads often take the lock.
+// The promotions of Range, which promotes the dirty map before it iterates, are not
+// counted.
+func (m *%s) PromotionStats() (promotions int64, misses int) {
+	m.mu.Lock()
+	misses = m.misses
+	m.mu.Unlock()
+	return atomic.LoadInt64(&m.npromotions), misses
+}
+`, g.Name)))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// generatedMarker is the first line of every file generated by syncmap.
+	generatedMarker = "// Code generated by syncmap; DO NOT EDIT."
+	// commandPrefix prefixes the line following the marker that records
+	// the command-line arguments used to generate the file.
+	commandPrefix = "// syncmap "
+)
+
+// Regen regenerates all files generated by syncmap in the given paths (default "."), using
+// the command-line arguments recorded in their headers. Directories are walked recursively,
+// skipping nested "testdata", "vendor" and hidden directories, as the go tool does.
+func Regen(paths ...string) (err error) {
+	defer catch(&err)
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			check(err, "walk %q", path)
+			if info.IsDir() {
+				if path != root && skipDir(info.Name()) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasSuffix(path, ".go") {
+				return nil
+			}
+			args, ok := recordedArgs(path)
+			if !ok {
+				return nil
+			}
+			fs := flag.NewFlagSet("syncmap", flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+			cs, err := ParseConfigs(fs, args)
+			if err == nil {
+				for i := range cs {
+					cs[i].Out = path
+				}
+				err = GenerateFile(cs)
+			}
+			if e, ok := err.(genError); ok {
+				err = errors.New(e.msg)
+			}
+			check(err, "regenerate %s", path)
+			return nil
+		})
+		check(err, "walk %q", root)
+	}
+	return
+}
+
+func skipDir(name string) bool {
+	return name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// recordedArgs returns the command-line arguments recorded in the header of a generated file.
+func recordedArgs(path string) ([]string, bool) {
+	f, err := os.Open(path)
+	check(err, "open %q file", path)
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	if !s.Scan() || s.Text() != generatedMarker || !s.Scan() || !strings.HasPrefix(s.Text(), commandPrefix) {
+		return nil, false
+	}
+	args, err := splitArgs(strings.TrimPrefix(s.Text(), commandPrefix))
+	check(err, "parse command in %q", path)
+	return args, true
+}
+
+// quoteArgs joins the arguments by spaces, quoting the ones that can not be split back as-is.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t") || strconv.Quote(arg) != `"`+arg+`"` {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// splitArgs splits a line created by quoteArgs back to its arguments.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		i := strings.IndexAny(s, " \t")
+		if s[0] == '"' {
+			for i = 1; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		}
+		if i < 0 || i > len(s) {
+			i = len(s)
+		}
+		arg := s[:i]
+		if arg[0] == '"' {
+			var err error
+			if arg, err = strconv.Unquote(arg); err != nil {
+				return nil, err
+			}
+		}
+		args = append(args, arg)
+		s = s[i:]
+	}
+	return args, nil
+}
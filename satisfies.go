@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"strings"
+)
+
+// checkSatisfies parses the interfaces of -satisfies. They are named interface types, of
+// the output package or qualified by their package, and the generic ones are instantiated
+// with their type arguments, e.g. "store.Store[string, int]". The type arguments of the
+// interfaces of generic maps may be the type parameters of the map.
+func (g *Generator) checkSatisfies() {
+	for _, s := range g.Satisfies {
+		x, err := parser.ParseExpr(s)
+		check(err, "-satisfies: parse interface %s", s)
+		name := x
+		switch t := x.(type) {
+		case *ast.IndexExpr:
+			name = t.X
+		case *ast.IndexListExpr:
+			name = t.X
+		}
+		switch t := name.(type) {
+		case *ast.Ident:
+			expect(t.Name != "_", "-satisfies: invalid interface %s. expected a named interface type, e.g. pkg.Iface", s)
+		case *ast.SelectorExpr:
+		default:
+			expect(false, "-satisfies: invalid interface %s. expected a named interface type, e.g. pkg.Iface", s)
+		}
+		g.checkQualifiers("interface", s, x)
+		g.satisfies = append(g.satisfies, x)
+	}
+}
+
+// addSatisfies appends the compile-time assertions that the map implements the interfaces
+// of -satisfies, and adds the imports of their packages. It runs after all the methods are
+// added to the map. The assertions of generic maps are declared in a generic function, as
+// the type parameters are not in scope at the package level.
+func (g *Generator) addSatisfies() {
+	for _, s := range g.importsOf(g.satisfies...) {
+		g.logf("adding import %s for -satisfies", s)
+		g.addImport(g.file, s.name, s.path)
+	}
+	var assertions []string
+	for _, x := range g.satisfies {
+		assertions = append(assertions, types.ExprString(x))
+	}
+	if !g.Generic {
+		var b strings.Builder
+		fmt.Fprintf(&b, "\n// %s must implement the interfaces below. A mismatch of their method sets fails to compile.\nvar (\n", g.Name)
+		for _, a := range assertions {
+			fmt.Fprintf(&b, "\t_ %s = (*%s)(nil)\n", a, g.Name)
+		}
+		b.WriteString(")\n")
+		g.appendSource([]byte(b.String()))
+		return
+	}
+	var params, args []string
+	for _, p := range g.typeParams() {
+		params = append(params, p.name+" "+p.constraint)
+		args = append(args, p.name)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n// %s must implement the interfaces below. A mismatch of their method sets fails to compile.\nfunc _[%s]() {\n",
+		g.Name, strings.Join(params, ", "))
+	for _, a := range assertions {
+		fmt.Fprintf(&b, "\tvar _ %s = (*%s[%s])(nil)\n", a, g.Name, strings.Join(args, ", "))
+	}
+	b.WriteString("}\n")
+	g.appendSource([]byte(b.String()))
+}
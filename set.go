@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"strings"
+	"text/template"
+)
+
+// setMethods maps the exported methods of the map to the unexported names they get in
+// sets, in order to hide the struct{} values from the set API.
+var setMethods = map[string]string{
+	"Load":          "load",
+	"Store":         "store",
+	"LoadOrStore":   "loadOrStore",
+	"LoadAndDelete": "loadAndDelete",
+	"Delete":        "delete",
+	"Range":         "rangeEntries",
+}
+
+// method returns the name of the given map method in the generated code.
+func (g *Generator) method(name string) string {
+	if g.Set {
+		return setMethods[name]
+	}
+	return name
+}
+
+// addSet unexports the map methods, and appends the set API that wraps them.
+func (g *Generator) addSet() {
+	g.renameMethods(setMethods)
+	b := bytes.NewBuffer(nil)
+	err := setTmpl.Execute(b, struct {
+		helperData
+		Counter bool
+	}{g.helperData(), g.Counter})
+	check(err, "execute set template")
+	g.appendSource(b.Bytes())
+}
+
+// renameMethods renames the methods of the map by the given names, together with their
+// calls on the receiver in the other methods, and their doc comments.
+func (g *Generator) renameMethods(names map[string]string) {
+	for _, d := range g.file.Decls {
+		f, ok := d.(*ast.FuncDecl)
+		if !ok || !g.isMethod(f) {
+			continue
+		}
+		if name, ok := names[f.Name.Name]; ok {
+			if f.Doc != nil {
+				c := f.Doc.List[0]
+				c.Text = strings.Replace(c.Text, f.Name.Name, name, 1)
+			}
+			f.Name.Name = name
+		}
+		recv := f.Recv.List[0].Names[0].Name
+		ast.Inspect(f.Body, func(n ast.Node) bool {
+			if s, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := s.X.(*ast.Ident); ok && id.Name == recv && names[s.Sel.Name] != "" {
+					s.Sel.Name = names[s.Sel.Name]
+				}
+			}
+			return true
+		})
+	}
+}
+
+var setTmpl = template.Must(template.New("set").Parse(`
+// Add adds the key to the set.
+func (m *{{.Name}}) Add(key {{.Key}}) {
+	m.store(key, struct{}{})
+}
+
+// Contains reports whether the key is present in the set.
+func (m *{{.Name}}) Contains(key {{.Key}}) bool {
+	_, ok := m.load(key)
+	return ok
+}
+
+// Remove removes the key from the set.
+func (m *{{.Name}}) Remove(key {{.Key}}) {
+	m.delete(key)
+}
+
+// Range calls f sequentially for each key present in the set.
+// If f returns false, range stops the iteration.
+//
+// Range has the same semantics as the Range method of sync.Map. f may call any of
+// the set methods.
+func (m *{{.Name}}) Range(f func(key {{.Key}}) bool) {
+	m.rangeEntries(func(key {{.Key}}, _ struct{}) bool {
+		return f(key)
+	})
+}
+{{- if not .Counter}}
+
+// Len returns the number of keys in the set. It ranges over the set and
+// therefore runs in linear time.
+func (m *{{.Name}}) Len() int {
+	n := 0
+	m.rangeEntries(func(_ {{.Key}}, _ struct{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+{{- end}}
+`))
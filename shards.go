@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/types"
+	"strings"
+)
+
+// checkShards fails if the config of -shards is invalid, or if the key type has no builtin
+// hash and no -shard-hash function is given. The builtin hashes are a seeded maphash.Hash
+// for the keys whose underlying type is a string, and a multiplicative hash for the integer
+// keys. The string keys are written to the hash with WriteString, rather than hashed with
+// maphash.String, which requires Go 1.19, and the sharded maps require Go 1.14, which
+// added hash/maphash.
+func (g *Generator) checkShards() {
+	expect(g.Shards >= 2, "-shards: expected at least 2 shards: %d", g.Shards)
+	for _, t := range []struct {
+		flag string
+		set  bool
+	}{
+		{"-internal", g.Internal},
+		{"-capacity", g.Capacity},
+		{"-parent", g.Parent},
+		{"-generic", g.Generic},
+		{"-ordered", g.Ordered},
+		{"-metrics", g.Metrics},
+		{"-promotions", g.Promotions},
+	} {
+		expect(!t.set, "%s can not be used with -shards", t.flag)
+	}
+	if g.ShardHash != "" {
+		x, err := parser.ParseExpr(g.ShardHash)
+		check(err, "-shard-hash: parse function %s", g.ShardHash)
+		switch t := x.(type) {
+		case *ast.Ident:
+		case *ast.SelectorExpr:
+			_, ok := t.X.(*ast.Ident)
+			expect(ok, "-shard-hash: invalid function %s. expected a function name, e.g. pkg.Hash", g.ShardHash)
+		default:
+			expect(false, "-shard-hash: invalid function %s. expected a function name, e.g. pkg.Hash", g.ShardHash)
+		}
+		g.checkQualifiers("-shard-hash", g.ShardHash, x)
+		g.shardHash = x
+		return
+	}
+	expect(g.keyHash() != shardHashNone, "-shards: key type %s has no builtin hash. expected a string or an integer type, "+
+		"or use -shard-hash with a func(%s) uint64", g.key, g.key)
+}
+
+// The builtin hashes of the keys of -shards.
+const (
+	shardHashNone = iota
+	shardHashString
+	shardHashInt
+)
+
+// keyHash returns the builtin hash of the key type for -shards.
+func (g *Generator) keyHash() int {
+	id, ok := g.underlying(g.mapType.Key).(*ast.Ident)
+	if !ok {
+		return shardHashNone
+	}
+	obj, ok := types.Universe.Lookup(id.Name).(*types.TypeName)
+	if !ok {
+		return shardHashNone
+	}
+	b, ok := obj.Type().(*types.Basic)
+	switch {
+	case !ok:
+		return shardHashNone
+	case b.Info()&types.IsString != 0:
+		return shardHashString
+	case b.Info()&types.IsInteger != 0:
+		return shardHashInt
+	}
+	return shardHashNone
+}
+
+// addShards renames the map type to the type of its shards, and declares the map type as
+// an array of shards, selected by a hash of the key. The methods of the map that get a key
+// are forwarded to its shard, and the other ones are aggregated across the shards: Range
+// visits the shards in turn, the methods without results (e.g. Clear) are called on every
+// shard, and the results of the methods that return a count (e.g. Len) are summed. It runs
+// before the helpers, which are added to the sharded map.
+func (g *Generator) addShards() {
+	shard := g.shardType()
+	var (
+		doc     []string
+		methods []*ast.FuncDecl
+		api     = make(map[string]bool)
+	)
+	for _, name := range []string{"Load", "Store", "LoadOrStore", "LoadAndDelete", "Delete", "Range"} {
+		api[g.method(name)] = true
+	}
+	for _, d := range g.file.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				g.logf("renaming %s to %s", g.Name, shard)
+				expect(d.Doc != nil && len(d.Doc.List) > 0, "missing doc comment of %s", g.Name)
+				for _, c := range d.Doc.List {
+					doc = append(doc, c.Text)
+				}
+				// The comment group is shared with the comments of the file, and it
+				// is replaced in place by its last comment, adjacent to the type.
+				d.Doc.List = d.Doc.List[len(d.Doc.List)-1:]
+				d.Doc.List[0].Text = fmt.Sprintf("// %s is a shard of %s.", shard, g.Name)
+				t.Name.Name = shard
+			}
+		case *ast.FuncDecl:
+			if !g.isMethod(d) {
+				continue
+			}
+			if d.Name.IsExported() || api[d.Name.Name] {
+				methods = append(methods, d)
+			}
+			d.Recv.List[0].Type.(*ast.StarExpr).X.(*ast.Ident).Name = shard
+		}
+	}
+	expect(doc != nil, "-shards: missing type %s", g.Name)
+	hash := fmt.Sprintf("h := %s(key)", g.ShardHash)
+	switch {
+	case g.shardHash != nil:
+		for _, s := range g.importsOf(g.shardHash) {
+			g.logf("adding import %s for -shard-hash", s)
+			g.addImport(g.file, s.name, s.path)
+		}
+	case g.keyHash() == shardHashString:
+		g.addImport(g.file, "", "hash/maphash")
+		key := "key"
+		if g.key != "string" {
+			key = "string(key)"
+		}
+		hash = fmt.Sprintf("var hash maphash.Hash\n\thash.SetSeed(%s)\n\thash.WriteString(%s)\n\th := hash.Sum64()", g.shardSeed(), key)
+	default:
+		// The multiplier is 2^64 divided by the golden ratio, and the high bits of the
+		// product depend on all the bits of the key.
+		hash = "h := uint64(key) * 0x9e3779b97f4a7c15 >> 32"
+	}
+	b := bytes.NewBuffer(nil)
+	fmt.Fprintf(b, `
+%[1]s
+//
+// The entries are stored in %[2]d shards, each guarded by a mutex of its own, and the shard
+// of a key is selected by a hash of the key. The methods that get a key access only its
+// shard, and the other methods, e.g. Range, visit the shards in turn. Range is not
+// atomic across the shards.
+type %[3]s struct {
+	shards [%[2]d]%[4]s
+}
+`, strings.Join(doc, "\n"), g.Shards, g.Name, shard)
+	if g.shardHash == nil && g.keyHash() == shardHashString {
+		fmt.Fprintf(b, `
+// %s is the seed of the hash of the keys of %s. It is random, and the
+// keys are distributed differently among the shards in each process.
+var %[1]s = maphash.MakeSeed()
+`, g.shardSeed(), g.Name)
+	}
+	fmt.Fprintf(b, `
+// shardFor returns the shard of the key.
+func (m *%s) shardFor(key %s) *%s {
+	%s
+	return &m.shards[h%%%d]
+}
+`, g.Name, g.key, shard, hash, g.Shards)
+	for _, f := range methods {
+		b.WriteString(g.shardMethod(f))
+	}
+	g.appendSource(b.Bytes())
+}
+
+// shardMethod returns the source of the method of the sharded map that forwards the given
+// method of the shards, or aggregates it across them.
+func (g *Generator) shardMethod(f *ast.FuncDecl) string {
+	sig := bytes.NewBuffer(nil)
+	err := format.Node(sig, g.fset, f.Type)
+	check(err, "format signature of %s", f.Name.Name)
+	var (
+		args    []string
+		key     string
+		results = f.Type.Results.NumFields()
+		params  = f.Type.Params.List
+	)
+	for _, p := range params {
+		expect(len(p.Names) > 0, "-shards: method %s has unnamed parameters", f.Name.Name)
+		for _, n := range p.Names {
+			arg := n.Name
+			if _, ok := p.Type.(*ast.Ellipsis); ok {
+				arg += "..."
+			}
+			args = append(args, arg)
+			if key == "" && g.isKeyType(p.Type) {
+				key = n.Name
+			}
+		}
+	}
+	ret := ""
+	if results > 0 {
+		ret = "return "
+	}
+	var body string
+	switch fn, ok := rangeFunc(params); {
+	case key != "":
+		body = fmt.Sprintf("%sm.shardFor(%s).%s(%s)", ret, key, f.Name.Name, strings.Join(args, ", "))
+	case f.Name.Name == g.method("Range") || f.Name.Name == "Range":
+		expect(ok && results == 0, "-shards: unsupported signature of method %s", f.Name.Name)
+		var vars, decls []string
+		for _, p := range fn.Params.List {
+			names := p.Names
+			if len(names) == 0 {
+				// The parameters of the callback are unnamed.
+				names = []*ast.Ident{ast.NewIdent(fmt.Sprintf("a%d", len(vars)))}
+			}
+			for _, n := range names {
+				vars = append(vars, n.Name)
+				decls = append(decls, n.Name+" "+types.ExprString(p.Type))
+			}
+		}
+		body = fmt.Sprintf(`for i := range m.shards {
+		var stopped bool
+		m.shards[i].%[1]s(func(%[2]s) bool {
+			stopped = !%[3]s(%[4]s)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}`, f.Name.Name, strings.Join(decls, ", "), args[0], strings.Join(vars, ", "))
+	case len(params) == 0 && results == 0:
+		body = fmt.Sprintf("for i := range m.shards {\n\t\tm.shards[i].%s()\n\t}", f.Name.Name)
+	case len(params) == 0 && results == 1 && isCount(f.Type.Results.List[0].Type):
+		body = fmt.Sprintf("var n %s\n\tfor i := range m.shards {\n\t\tn += m.shards[i].%s()\n\t}\n\treturn n",
+			types.ExprString(f.Type.Results.List[0].Type), f.Name.Name)
+	default:
+		expect(false, "-shards: method %s can not be forwarded to a shard, as it gets no key, or aggregated across the shards", f.Name.Name)
+	}
+	doc := fmt.Sprintf("// %s calls %s on the shards of the map.", f.Name.Name, f.Name.Name)
+	if f.Doc != nil {
+		var lines []string
+		for _, c := range f.Doc.List {
+			lines = append(lines, c.Text)
+		}
+		doc = strings.Join(lines, "\n")
+	}
+	return fmt.Sprintf("\n%s\nfunc (m *%s) %s%s {\n\t%s\n}\n", doc, g.Name, f.Name.Name, strings.TrimPrefix(sig.String(), "func"), body)
+}
+
+// rangeFunc returns the type of the callback of a Range method, given its parameters.
+func rangeFunc(params []*ast.Field) (*ast.FuncType, bool) {
+	if len(params) != 1 || len(params[0].Names) != 1 {
+		return nil, false
+	}
+	fn, ok := params[0].Type.(*ast.FuncType)
+	if !ok || fn.Results.NumFields() != 1 || types.ExprString(fn.Results.List[0].Type) != "bool" {
+		return nil, false
+	}
+	return fn, true
+}
+
+// isCount reports whether the result type is a count, i.e. int or int64.
+func isCount(x ast.Expr) bool {
+	s := types.ExprString(x)
+	return s == "int" || s == "int64"
+}
+
+// shardType returns the name of the shard type generated by -shards.
+func (g *Generator) shardType() string {
+	return "shard" + strings.Title(g.Name)
+}
+
+// shardSeed returns the name of the hash seed generated by -shards for string keys.
+func (g *Generator) shardSeed() string {
+	return "shardSeed" + strings.Title(g.Name)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"text/template"
+)
+
+// simpleFile returns the AST of a plain Go map guarded by a mutex, that has the same
+// method set as sync.Map.
+func (g *Generator) simpleFile() *ast.File {
+	return g.templateFile(simpleTmpl)
+}
+
+// templateFile returns the AST of a map that is generated from the given template, instead
+// of sync/map.go.
+func (g *Generator) templateFile(t *template.Template) *ast.File {
+	b := bytes.NewBuffer(nil)
+	err := t.Execute(b, map[string]string{
+		"Pkg":   g.Pkg,
+		"Name":  g.Name,
+		"Key":   g.key,
+		"Value": g.value,
+		"Mutex": g.mutex(),
+	})
+	check(err, "execute %s map template", t.Name())
+	f, err := parser.ParseFile(g.fset, "", b.Bytes(), parser.ParseComments)
+	check(err, "parse %s map", t.Name())
+	return f
+}
+
+var simpleTmpl = template.Must(template.New("simple").Parse(`package {{.Pkg}}
+
+import "sync"
+
+// {{.Name}} is like a Go map[{{.Key}}]{{.Value}} but is safe for concurrent use by multiple
+// goroutines. Unlike sync.Map, it is a plain Go map guarded by a mutex. Hence, all
+// operations take the lock, and it does not scale like sync.Map under contention.
+//
+// The zero {{.Name}} is empty and ready for use. It must not be copied after first use.
+type {{.Name}} struct {
+	mu {{.Mutex}}
+	m  map[{{.Key}}]{{.Value}}
+}
+
+// Load returns the value stored in the map for a key, or the zero value if no
+// value is present.
+// The ok result indicates whether value was found in the map.
+func (m *{{.Name}}) Load(key {{.Key}}) (value {{.Value}}, ok bool) {
+	m.mu.Lock()
+	value, ok = m.m[key]
+	m.mu.Unlock()
+	return value, ok
+}
+
+// Store sets the value for a key.
+func (m *{{.Name}}) Store(key {{.Key}}, value {{.Value}}) {
+	m.mu.Lock()
+	if m.m == nil {
+		m.m = make(map[{{.Key}}]{{.Value}})
+	}
+	m.m[key] = value
+	m.mu.Unlock()
+}
+
+// LoadOrStore returns the existing value for the key if present.
+// Otherwise, it stores and returns the given value.
+// The loaded result is true if the value was loaded, false if stored.
+func (m *{{.Name}}) LoadOrStore(key {{.Key}}, value {{.Value}}) (actual {{.Value}}, loaded bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if actual, loaded = m.m[key]; loaded {
+		return actual, loaded
+	}
+	if m.m == nil {
+		m.m = make(map[{{.Key}}]{{.Value}})
+	}
+	m.m[key] = value
+	return value, false
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if any.
+// The loaded result reports whether the key was present.
+func (m *{{.Name}}) LoadAndDelete(key {{.Key}}) (value {{.Value}}, loaded bool) {
+	m.mu.Lock()
+	value, loaded = m.m[key]
+	delete(m.m, key)
+	m.mu.Unlock()
+	return value, loaded
+}
+
+// Delete deletes the value for a key.
+func (m *{{.Name}}) Delete(key {{.Key}}) {
+	m.LoadAndDelete(key)
+}
+
+// Range calls f sequentially for each key and value present in the map.
+// If f returns false, range stops the iteration.
+//
+// Range iterates over a copy of the map taken under the lock, so f may call any
+// of the map methods. Stores and deletes made during the call are not reflected
+// in the iteration.
+func (m *{{.Name}}) Range(f func(key {{.Key}}, value {{.Value}}) bool) {
+	m.mu.Lock()
+	s := make(map[{{.Key}}]{{.Value}}, len(m.m))
+	for key, value := range m.m {
+		s[key] = value
+	}
+	m.mu.Unlock()
+	for key, value := range s {
+		if !f(key, value) {
+			break
+		}
+	}
+}
+`))
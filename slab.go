@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"strings"
+	"text/template"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// addSlabValues replaces the entry of the map with an entry that stores its value in a slab
+// of the map, instead of an atomic pointer to a copy of the value. The entries are created
+// by the methods of the map with the slab of the map, which is allocated on first use.
+func (g *Generator) addSlabValues() {
+	slab, newEntry := g.slabType(), g.renames()["newEntry"]
+	g.replaceEntry("-slab", slabTmpl, map[string]string{"Name": g.Name, "Slab": slab})
+	for _, d := range g.file.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				appendFields(t.Type.(*ast.StructType), "slab *"+slab)
+			}
+		case *ast.FuncDecl:
+			if d.Recv == nil || len(d.Recv.List[0].Names) == 0 {
+				continue
+			}
+			recv := d.Recv.List[0].Names[0].Name
+			astutil.Apply(d.Body, func(c *astutil.Cursor) bool {
+				call, ok := c.Node().(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				if id, ok := call.Fun.(*ast.Ident); ok && id.Name == newEntry {
+					g.logf("passing the slab of %s to %s in %s", g.Name, newEntry, d.Name.Name)
+					arg, err := parser.ParseExpr(recv + ".slabLocked()")
+					check(err, "parse slab argument")
+					setAllPos(arg, call.Lparen)
+					call.Args = append([]ast.Expr{arg}, call.Args...)
+				}
+				return true
+			}, nil)
+		}
+	}
+	g.appendSource([]byte(fmt.Sprintf(`
+// slabLocked returns the slab of the values of the map, allocating it on first use.
+//
+// m.mu must be held.
+func (m *%[1]s) slabLocked() *%[2]s {
+	if m.slab == nil {
+		m.slab = new(%[2]s)
+	}
+	return m.slab
+}
+`, g.Name, slab)))
+}
+
+// slabType returns the name of the slab type generated by -slab.
+func (g *Generator) slabType() string {
+	return "slab" + strings.Title(g.Name)
+}
+
+// pointers returns a type in the given type expression that holds pointers (e.g. "string"
+// in "[2]string"), or an empty string if there is none. Types from other packages are
+// assumed to have no pointers, and so are the type parameters of -generic.
+func (g *Generator) pointers(x ast.Expr) string {
+	seen := make(map[ast.Expr]bool)
+	var find func(x ast.Expr) ast.Expr
+	find = func(x ast.Expr) ast.Expr {
+		u := g.underlying(x)
+		if seen[u] {
+			return nil
+		}
+		seen[u] = true
+		switch t := u.(type) {
+		case *ast.Ident:
+			switch t.Name {
+			case "string", "error", "any":
+				return t
+			}
+		case *ast.SelectorExpr:
+			if id, ok := t.X.(*ast.Ident); ok && id.Name == "unsafe" && t.Sel.Name == "Pointer" {
+				return t
+			}
+		case *ast.StarExpr, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType:
+			return x
+		case *ast.ArrayType:
+			if t.Len == nil {
+				return x
+			}
+			return find(t.Elt)
+		case *ast.StructType:
+			for _, f := range t.Fields.List {
+				if p := find(f.Type); p != nil {
+					return p
+				}
+			}
+		case *ast.IndexExpr, *ast.IndexListExpr:
+			// The fields of generic types depend on their type arguments.
+			return x
+		}
+		return nil
+	}
+	p := find(x)
+	if p == nil {
+		return ""
+	}
+	return types.ExprString(p)
+}
+
+var slabTmpl = template.Must(template.New("slab").Funcs(template.FuncMap{"article": article}).Parse(`
+// An entry is a slot in the map corresponding to a particular key.
+//
+// Unlike the entry of sync.Map, the value is not stored as an atomic pointer to a copy
+// of it, but in a slot of the slab of the map, and the entry holds the handle of the
+// slot. The handle is replaced atomically, and the slot of the previous value is
+// released when it is replaced or deleted.
+type {{.Entry}} struct {
+	// p is the handle of the slot of the value in s.
+	//
+	// If p == 0, the entry has been deleted, and either m.dirty == nil or
+	// m.dirty[key] is e.
+	//
+	// If p == {{.Expunged}}, the entry has been deleted, m.dirty != nil, and the entry
+	// is missing from m.dirty.
+	p int64
+	s *{{.Slab}}
+}
+
+// {{.Expunged}} is the handle of the entries that have been deleted from the dirty map.
+const {{.Expunged}} = -1
+
+func {{.NewEntry}}(s *{{.Slab}}, i {{.Value}}) *{{.Entry}} {
+	return &{{.Entry}}{p: s.alloc(i), s: s}
+}
+
+func (e *{{.Entry}}) load() (value {{.Value}}, ok bool) {
+	for {
+		p := atomic.LoadInt64(&e.p)
+		if p == 0 || p == {{.Expunged}} {
+			return value, false
+		}
+		// The slot was released if the value was replaced or deleted after p was
+		// loaded. Load the new handle.
+		if value, ok = e.s.load(p); ok {
+			return value, true
+		}
+	}
+}
+
+// tryStore stores a value if the entry has not been expunged.
+//
+// If the entry is expunged, tryStore returns false and leaves the entry
+// unchanged.
+func (e *{{.Entry}}) tryStore(i *{{.Value}}) bool {
+	p := atomic.LoadInt64(&e.p)
+	if p == {{.Expunged}} {
+		return false
+	}
+	h := e.s.alloc(*i)
+	for {
+		if atomic.CompareAndSwapInt64(&e.p, p, h) {
+			if p != 0 {
+				e.s.release(p)
+			}
+			return true
+		}
+		p = atomic.LoadInt64(&e.p)
+		if p == {{.Expunged}} {
+			e.s.release(h)
+			return false
+		}
+	}
+}
+
+// unexpungeLocked ensures that the entry is not marked as expunged.
+//
+// If the entry was previously expunged, it must be added to the dirty map
+// before m.mu is unlocked.
+func (e *{{.Entry}}) unexpungeLocked() (wasExpunged bool) {
+	return atomic.CompareAndSwapInt64(&e.p, {{.Expunged}}, 0)
+}
+
+// storeLocked unconditionally stores a value to the entry.
+//
+// The entry must be known not to be expunged.
+func (e *{{.Entry}}) storeLocked(i *{{.Value}}) {
+	if p := atomic.SwapInt64(&e.p, e.s.alloc(*i)); p != 0 {
+		e.s.release(p)
+	}
+}
+
+// tryLoadOrStore atomically loads or stores a value if the entry is not
+// expunged.
+//
+// If the entry is expunged, tryLoadOrStore leaves the entry unchanged and
+// returns with ok==false.
+func (e *{{.Entry}}) tryLoadOrStore(i {{.Value}}) (actual {{.Value}}, loaded, ok bool) {
+	var h int64 // the handle of i, allocated on the first attempt to store it.
+	for {
+		p := atomic.LoadInt64(&e.p)
+		if p == {{.Expunged}} {
+			break
+		}
+		if p != 0 {
+			if actual, loaded = e.s.load(p); !loaded {
+				continue
+			}
+			if h != 0 {
+				e.s.release(h)
+			}
+			return actual, true, true
+		}
+		if h == 0 {
+			h = e.s.alloc(i)
+		}
+		if atomic.CompareAndSwapInt64(&e.p, 0, h) {
+			return i, false, true
+		}
+	}
+	if h != 0 {
+		e.s.release(h)
+	}
+	return actual, false, false
+}
+
+func (e *{{.Entry}}) delete() (value {{.Value}}, ok bool) {
+	for {
+		p := atomic.LoadInt64(&e.p)
+		if p == 0 || p == {{.Expunged}} {
+			return value, false
+		}
+		if atomic.CompareAndSwapInt64(&e.p, p, 0) {
+			return e.s.release(p), true
+		}
+	}
+}
+
+func (e *{{.Entry}}) tryExpungeLocked() (isExpunged bool) {
+	p := atomic.LoadInt64(&e.p)
+	for p == 0 {
+		if atomic.CompareAndSwapInt64(&e.p, 0, {{.Expunged}}) {
+			return true
+		}
+		p = atomic.LoadInt64(&e.p)
+	}
+	return p == {{.Expunged}}
+}
+
+// A {{.Slab}} holds the values of {{article .Name}} {{.Name}} in a slice, instead of a copy of each
+// value allocated on the heap. It improves the cache locality of the values, and spares
+// the garbage collector their allocations. If the value type has no pointers, the slice
+// is not scanned by the garbage collector at all. The trade-offs are:
+//
+//   - Every access locks the slab, which is shared by all the entries of the map. Loads
+//     take a read lock, and stores and deletes take a write lock. Hence, unlike
+//     sync.Map, the map does not scale with concurrent stores of disjoint keys.
+//   - The slots of the replaced and the deleted values are reused by the next stores,
+//     but the slice never shrinks. Clear releases the slab of the map.
+//   - Values with pointers are scanned by the garbage collector as part of the slice,
+//     which is unsuitable for maps of many such values.
+//
+// Each slot has a generation, which is part of its handle and changes when the slot is
+// released. Hence, an entry that loads a stale handle finds that the slot was released,
+// and possibly reused by another entry, instead of loading a value it never held.
+type {{.Slab}} struct {
+	mu     sync.RWMutex
+	values []{{.Value}}
+	gens   []uint32 // generations of the slots, between 1 and 1<<31-1.
+	free   []uint32 // indices of the released slots.
+}
+
+// alloc stores the value in a free slot, and returns the handle of the slot: its index
+// in the low 32 bits, and its generation in the high 32 bits. The handle is positive.
+func (s *{{.Slab}}) alloc(v {{.Value}}) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.free); n > 0 {
+		i := s.free[n-1]
+		s.free = s.free[:n-1]
+		s.values[i] = v
+		return int64(s.gens[i])<<32 | int64(i)
+	}
+	i := len(s.values)
+	if uint64(i) >= 1<<32 {
+		panic("syncmap: the slab of {{.Name}} is full")
+	}
+	s.values = append(s.values, v)
+	s.gens = append(s.gens, 1)
+	return 1<<32 | int64(i)
+}
+
+// load returns the value in the slot of the handle, or false if the slot was released.
+func (s *{{.Slab}}) load(h int64) (value {{.Value}}, ok bool) {
+	i := uint32(h)
+	s.mu.RLock()
+	if s.gens[i] == uint32(h>>32) {
+		value, ok = s.values[i], true
+	}
+	s.mu.RUnlock()
+	return value, ok
+}
+
+// release releases the slot of the handle for reuse, and returns its value. The slot is
+// reset, to release the memory its value references.
+func (s *{{.Slab}}) release(h int64) (value {{.Value}}) {
+	i := uint32(h)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, s.values[i] = s.values[i], value
+	s.gens[i] = s.gens[i]%(1<<31-1) + 1
+	s.free = append(s.free, i)
+	return value
+}
+`))
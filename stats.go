@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// Stats holds the statistics of the generation of a map, e.g. for monitoring the generation
+// of many maps, or for finding its slow steps.
+type Stats struct {
+	Decls   int           // declarations of sync/map.go handled. 0 if generated from a cached template.
+	Imports int           // imports added to the generated files.
+	Files   int           // generated files, including the unchanged ones.
+	Bytes   int           // size of the generated files.
+	Mutate  time.Duration // time spent by Mutate.
+	Format  time.Duration // time spent by Gen formatting the files, e.g. running goimports.
+	Gen     time.Duration // time spent by Gen, including Format.
+}
+
+// GenerateStats is like Generate, but also returns the statistics of the generation.
+func GenerateStats(c Config) (Stats, error) {
+	g, err := NewGenerator(c)
+	if err != nil {
+		return Stats{}, err
+	}
+	if err := g.Mutate(); err != nil {
+		return g.Stats(), err
+	}
+	err = g.Gen()
+	return g.Stats(), err
+}
+
+// Stats returns the statistics of the generation since the last reset of the generator.
+func (g *Generator) Stats() Stats {
+	return g.stats
+}
@@ -5,76 +5,618 @@ import (
 	"flag"
 	"fmt"
 	"go/ast"
+	"go/build/constraint"
 	"go/format"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"go/types"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
-	"runtime"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/imports"
 )
 
-var (
-	out   = flag.String("o", "", "")
-	pkg   = flag.String("pkg", "main", "")
-	name  = flag.String("name", "Map", "")
-	usage = `Usage: syncmap [options...] map[T1]T2
+const usage = `Usage: syncmap [options...] map[T1]T2
+       syncmap -set [options...] T
+       syncmap -o file -name Name1 -name Name2 [options...] map[T1]T2 map[T3]T4
+       syncmap -name Name1 -o file1 -name Name2 -o file2 [options...] map[T1]T2 map[T3]T4
+       syncmap -stdin [options...] < file
+       syncmap regen [paths...]
+       syncmap bench [options...] map[T1]T2
 
 Options:
-  -o         Specify file output. If none is specified, the name
-             will be derived from the map type.
-  -pkg       Package name to use in the generated code. If none is
-             specified, the name will main.
-  -name      Struct name to use in the generated code. If none is
-             specified, the name will be Map.
 `
-)
+
+const commands = `
+Commands:
+  regen
+    	Regenerate all files generated by syncmap in the given paths
+    	(default "."), using the command recorded in their headers.
+  doctor [file]
+    	Report the compatibility of the given sync/map.go (default the one
+    	of GOROOT) with syncmap: the declarations it does not recognize or
+    	misses, and whether it can be mutated.
+  bench [options...] map[T1]T2
+    	Benchmark the Store, Load, LoadOrStore and Range methods of the
+    	map generated with the given options against a type-safe wrapper
+    	of sync.Map, and report the comparison. -benchtime duration is
+    	passed to go test (default 1s). Requires the go command.
+`
+
+// stdin is the input of the -stdin flag.
+var stdin io.Reader = os.Stdin
 
 func main() {
-	flag.Usage = func() {
-		fmt.Fprint(os.Stderr, fmt.Sprintf(usage))
+	if len(os.Args) > 1 && os.Args[1] == "regen" {
+		err := Regen(os.Args[2:]...)
+		failOnErr(err)
+		return
 	}
-	flag.Parse()
-	g, err := NewGenerator()
-	failOnErr(err)
-	err = g.Mutate()
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		var path string
+		if len(os.Args) > 2 {
+			path = os.Args[2]
+		}
+		err := Doctor(os.Stdout, path)
+		failOnErr(err)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		err := Bench(os.Stdout, os.Args[2:])
+		failOnErr(err)
+		return
+	}
+	cs, err := ParseConfigs(flag.CommandLine, os.Args[1:])
 	failOnErr(err)
-	err = g.Gen()
+	err = GenerateFiles(cs)
 	failOnErr(err)
 }
 
+// Config holds the options of the generator.
+type Config struct {
+	Args            []string    // command-line arguments, recorded in the file header.
+	Spec            string      // map type. e.g. map[T1]T2.
+	Pkg             string      // package name.
+	Out             string      // file name.
+	Name            string      // struct name.
+	Suffix          string      // file name suffix.
+	Header          string      // file header template.
+	Methods         string      // template file of custom methods.
+	Size            int         // expected struct size.
+	Zero            bool        // generate a test that the zero value is ready for use.
+	NoHeader        bool        // omit the generated code marker.
+	NoGofmt         bool        // skip the gofmt pass.
+	Helpers         []string    // optional methods to generate.
+	Metrics         bool        // track operation counters.
+	Iter            bool        // generate the iter.Seq2 All method.
+	ChanIter        bool        // generate the channel Iter method.
+	Inline          bool        // store the values inline in the entries.
+	Slab            bool        // store the values in a slab of the map.
+	KeyInline       bool        // store the keys in the entries, for Range.
+	Set             bool        // generate a set of the spec type.
+	Tags            string      // build constraint of the optional generated files.
+	Ext             bool        // generate the optional methods in an extension file.
+	Simple          bool        // generate a plain mutex-guarded map.
+	Frozen          bool        // generate a map that is read-only after Freeze.
+	Clear           bool        // generate the Clear method.
+	Compact         bool        // generate the Compact method.
+	ApproxLen       bool        // generate the constant-time ApproxLen method.
+	Promotions      bool        // count the promotions of the dirty map, and generate the PromotionStats method.
+	Counter         bool        // count the keys, and generate the constant-time Len method.
+	Wait            bool        // generate the LoadOrWait method.
+	Compute         bool        // generate the LoadOrCompute method.
+	Trace           bool        // call the trace hook at the entry of the methods.
+	Parent          bool        // generate the constructor of the maps that inherit a parent.
+	CAS             bool        // generate the CompareAndSwap method.
+	LoadAndDelete   bool        // generate the LoadAndDelete method, if sync/map.go lacks it.
+	Ordered         bool        // track the insertion order of the keys.
+	Multimap        bool        // generate the Append and Get methods of a map of slices.
+	Capacity        bool        // generate a constructor with a capacity hint.
+	NoCopy          bool        // add a noCopy guard to the map struct.
+	MutexType       string      // type of the mutex of the map. sync.Mutex if empty.
+	Internal        bool        // embed the implementation of the map as an unexported type.
+	Comparable      bool        // generate a compile-time check that the key type is comparable.
+	Satisfies       []string    // interfaces that the map must implement, asserted at compile time.
+	Shards          int         // number of shards of the map. not sharded if zero.
+	ShardHash       string      // hash function of the keys of the shards. builtin if empty.
+	Line            bool        // map the generated lines back to sync/map.go.
+	Generic         bool        // generate a generic map of the type parameters of the spec.
+	KeyConstraint   string      // constraint of the key type parameter. comparable if empty.
+	ValueConstraint string      // constraint of the value type parameter. any if empty.
+	Packages        bool        // resolve the imports of the map types by loading the output package.
+	Verify          bool        // build and vet the generated files in a throwaway package.
+	Source          string      // path of the sync/map.go to specialize.
+	BestEffort      bool        // generate the map from an unsupported sync/map.go, with warnings.
+	CRLF            bool        // use CRLF line endings in the generated files.
+	Perm            fs.FileMode // permissions of the generated files. 0644 if zero.
+	Verbose         bool        // log the mutation steps to stderr.
+
+	// Format formats the source of each generated file, instead of goimports and gofmt.
+	// The source it gets is printed from the AST, and its imports are not resolved yet.
+	// i.e. Format is responsible for adding the missing imports and removing the unused
+	// ones. If nil, goimports and gofmt are used.
+	Format func(src []byte) ([]byte, error)
+
+	// WriteFile writes each generated file, instead of writing it to the file system.
+	// e.g. for tools that manage their own virtual file system, or for testing.
+	WriteFile func(name string, data []byte, perm fs.FileMode) error
+
+	// PostMutate is called at the end of Mutate with the AST of the main file and its file
+	// set, for custom transformations, e.g. adding declarations or rewriting nodes, and its
+	// error fails Mutate. The file is printed and formatted by Gen afterwards. The imports
+	// of the standard packages that the file uses are fixed after it returns, and the
+	// other imports that it needs are left for goimports, or must be added by it. The
+	// extension file of -ext is not part of the AST. Maps with a PostMutate hook are
+	// generated by the AST mutation, even if Fast is set.
+	PostMutate func(f *ast.File, fset *token.FileSet) error
+
+	// Fast generates the map by substituting its name and types in a template of the
+	// generated code, instead of mutating the AST of sync/map.go. The templates are cached
+	// by the Generator across resets, which saves most of the work and memory when it is
+	// reused for generating many maps with the same options. The output is identical.
+	// Maps of types that can not be substituted as text (e.g. generic instantiations, or
+	// function types) are generated by the AST mutation.
+	Fast bool
+}
+
+// ParseConfig parses the command-line arguments (without the program name) using the given flag set.
+// It fails if the arguments describe several maps. See ParseConfigs.
+func ParseConfig(fs *flag.FlagSet, args []string) (c Config, err error) {
+	cs, err := ParseConfigs(fs, args)
+	if err != nil {
+		return c, err
+	}
+	if len(cs) > 1 {
+		return c, genError{"several -name flags. use ParseConfigs"}
+	}
+	return cs[0], nil
+}
+
+// ParseConfigs parses the command-line arguments (without the program name) using the given flag
+// set, and returns the config of each map. The -name flag is repeated, with a type argument for
+// each name, in order to generate several maps into one file. See GenerateFile.
+func ParseConfigs(fs *flag.FlagSet, args []string) (cs []Config, err error) {
+	defer catch(&err)
+	var (
+		c         Config
+		fromStdin bool
+		satisfies nameList
+		names     = nameList{names: []string{"Map"}}
+		outs      = nameList{names: []string{""}}
+		pkgs      = nameList{names: []string{"main"}}
+	)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+		fmt.Fprint(fs.Output(), commands)
+	}
+	fs.Var(&outs, "o", "Output `file`. If none is specified, the name will be derived from the struct name.\n"+
+		"Repeat it with several -name to generate each map into its own file, in the same order.")
+	fs.Var(&pkgs, "pkg", "Package `name` to use in the generated code. For an external test package (e.g.\n"+
+		"foo_test), all the generated files are test files, e.g. \"map_test.go\". Repeat it with\n"+
+		"several -o, for the outputs in different packages.")
+	fs.Var(&names, "name", "Struct `name` to use in the generated code. Repeat it with -o to generate several\n"+
+		"maps into one file, with a type argument for each name, in the same order.")
+	fs.StringVar(&c.Suffix, "suffix", "", "File name `suffix` to add before the \".go\" extension of the derived name,\n"+
+		"e.g. \"_gen\" yields \"map_gen.go\". Ignored if -o is specified.")
+	fs.StringVar(&c.Header, "header", "", "Header `template` to add as a comment at the top of the generated file.\n"+
+		"{{.Year}} is replaced with the current year, e.g. \"Copyright {{.Year}} Acme Inc.\".")
+	fs.StringVar(&c.Methods, "methods", "", "Template `file` of custom methods to append to the generated file. The template\n"+
+		"gets the {{.Name}}, {{.Key}} and {{.Value}} of the map, and the {{.Recv}} type of its\n"+
+		"methods. It must render Go declarations, without imports. The relative paths are\n"+
+		"resolved against the output directory.")
+	fs.Var(&satisfies, "satisfies", "Interface `type` that the map must implement, e.g. \"store.Store\", asserted by a declaration\n"+
+		"that fails to compile on a mismatch of the method sets. The generic interfaces are\n"+
+		"instantiated with their type arguments, e.g. \"store.Store[string, int]\". Repeat it for\n"+
+		"several interfaces. Its package is imported like the packages of the map types.")
+	fs.BoolVar(&c.Comparable, "assert-comparable", false, "Generate a declaration that fails to compile if the key type is not comparable,\n"+
+		"with an explicit comment next to it.")
+	fs.StringVar(&c.Source, "source", "", "Path of the `file` to specialize instead of the sync/map.go of GOROOT, e.g. a\n"+
+		"patched fork of it. The file must have the same declarations as sync/map.go.")
+	fs.BoolVar(&c.BestEffort, "best-effort", false, "Experimental. Generate the map from a sync/map.go with declarations that syncmap\n"+
+		"does not recognize, e.g. of gotip, instead of failing. The interface{} types of the\n"+
+		"unrecognized declarations are replaced by the key and the value types heuristically,\n"+
+		"and a warning is printed for each of them, and for each missing declaration. The\n"+
+		"generated code may not compile.")
+	fs.BoolVar(&c.Line, "line", false, "Generate //line directives that map the declarations of the generated code back\n"+
+		"to their positions in sync/map.go, for debugging the generated internals. Note\n"+
+		"that the directives contain the path of the GOROOT used for generation.")
+	fs.IntVar(&c.Size, "assert-size", 0, "Expected `size` in bytes of the generated struct. If set, a test file\n"+
+		"guarding the struct size is generated next to the output file.")
+	fs.BoolVar(&c.Zero, "assert-zero", false, "Generate a test file next to the output file, that checks that the zero value of\n"+
+		"the map is ready for use without initialization, as the zero sync.Map is.")
+	fs.BoolVar(&c.NoHeader, "no-header", false, "Omit the \"Code generated by syncmap; DO NOT EDIT.\" marker from the\n"+
+		"generated file. Files generated without it can not be regenerated by the regen\n"+
+		"command.")
+	fs.Var((*perm)(&c.Perm), "perm", "Permissions of the generated files, in `octal`, e.g. 0444 for read-only files\n"+
+		"that discourage edits. (default 0644)")
+	fs.BoolVar(&c.CRLF, "crlf", false, "Use CRLF line endings in the generated files, instead of LF, for repositories that\n"+
+		"check them out with CRLF line endings.")
+	fs.BoolVar(&c.Verbose, "v", false, "Log the mutation steps to stderr: the handled declarations of sync/map.go, the\n"+
+		"renamed identifiers, the added imports and the written files. The generated code\n"+
+		"is not affected.")
+	fs.BoolVar(&fromStdin, "stdin", false, "Read the map type (or the set element type) from the standard input instead of\n"+
+		"the last argument, e.g. for types that are hard to quote in the shell. The type is\n"+
+		"recorded as the last argument in the header of the generated file.")
+	fs.BoolVar(&c.Packages, "packages", false, "Resolve the imports of the packages referenced by the map types by loading the\n"+
+		"output package with go/packages, instead of matching the names of the imports of\n"+
+		"its files. The package of a type is the imported package that declares it, even if\n"+
+		"other packages are imported using the same name. Slower, as the package is\n"+
+		"type-checked.")
+	fs.BoolVar(&c.Verify, "verify", false, "Build and vet the generated files after writing them, in a throwaway package with\n"+
+		"stubs of the types of the output package they reference, and fail with the compile\n"+
+		"errors. Requires the go command.")
+	fs.BoolVar(&c.Generic, "generic", false, "Generate a generic map, whose key and value types are the type parameters named\n"+
+		"by the map type, e.g. \"map[K]V\" generates Map[K comparable, V any]. Requires Go 1.18\n"+
+		"or later.")
+	fs.StringVar(&c.KeyConstraint, "key-constraint", "comparable", "Constraint `expr` of the key type parameter of -generic.")
+	fs.StringVar(&c.ValueConstraint, "value-constraint", "any", "Constraint `expr` of the value type parameter of -generic, e.g.\n"+
+		"\"comparable\" for -cas, or a constraint interface, e.g. \"metrics.Sample\", whose methods\n"+
+		"the custom methods of -methods can call on the values. The packages of the constraints\n"+
+		"are imported like the packages of the map types.")
+	fs.StringVar(&c.ValueConstraint, "vconstraint", "any", "Alias of -value-constraint.")
+	fs.BoolVar(&c.NoGofmt, "no-gofmt", false, "Skip the gofmt pass that runs after goimports, for faster generation.")
+	fs.Var((*list)(&c.Helpers), "helpers", "Comma-separated `list` of optional methods to generate on top of the map.\n"+
+		"Available helpers are: "+helperNames()+".")
+	fs.BoolVar(&c.Metrics, "metrics", false, "Track the number of hits, misses, stores and deletes of the map using\n"+
+		"atomic counters, and generate a Stats method that reports them.")
+	fs.BoolVar(&c.Iter, "iter", false, "Generate an All method that returns an iter.Seq2 of the map entries,\n"+
+		"for ranging over the map with a for loop. Requires Go 1.23 or later.")
+	fs.BoolVar(&c.ChanIter, "chaniter", false, "Generate an Iter method that returns a channel of the map entries, for\n"+
+		"ranging over the map with a for loop on Go versions before 1.23. The channel\n"+
+		"must be drained, or else the goroutine that sends on it is leaked.")
+	fs.BoolVar(&c.Clear, "clear", false, "Generate a Clear method that deletes all the entries at once, like the Clear\n"+
+		"method of sync.Map in Go 1.23, instead of ranging over the map.")
+	fs.BoolVar(&c.Compact, "compact", false, "Generate a Compact method that rebuilds the internal maps without the deleted\n"+
+		"entries, in order to release their memory. Go maps do not shrink, and a map that\n"+
+		"grew large and was mostly deleted keeps its memory until it is compacted.")
+	fs.BoolVar(&c.ApproxLen, "approxlen", false, "Generate an ApproxLen method that returns the number of keys of the internal maps\n"+
+		"in constant time, instead of ranging over the map like the len helper. It may count\n"+
+		"the deleted entries that are not removed from the internal maps yet.")
+	fs.BoolVar(&c.Promotions, "promotions", false, "Count the promotions of the dirty map to the read-only map that are triggered by\n"+
+		"the misses of the loads, and generate a PromotionStats method that reports them with\n"+
+		"the current number of misses, for diagnosing the access patterns that promote often.")
+	fs.BoolVar(&c.Counter, "counter", false, "Count the keys of the map in an atomic counter that is updated by the methods that\n"+
+		"add and delete them, and generate a Len method that returns it in constant time.")
+	fs.BoolVar(&c.Wait, "wait", false, "Generate a LoadOrWait method that blocks until a key is stored in the map, or until\n"+
+		"its context is done. The writes of a map that nobody waits on only load an atomic count.")
+	fs.BoolVar(&c.Compute, "compute", false, "Generate a LoadOrCompute(key, compute) method that calls compute for the missing\n"+
+		"keys and stores its value, for using the map as a read-through cache. The concurrent\n"+
+		"misses of a key wait for a single call of compute.")
+	fs.BoolVar(&c.Trace, "trace", false, "Declare a <name>Trace hook, func(op string, key interface{}), and call it at the entry\n"+
+		"of the exported methods of the map with their name and key, for tracing the operations\n"+
+		"while debugging. The hook is nil by default, and the methods only check it.")
+	fs.BoolVar(&c.Parent, "parent", false, "Generate a New<name>WithParent(parent) constructor of maps that inherit the entries of\n"+
+		"a parent map. Their loads fall back to the parent on a local miss, and their writes are\n"+
+		"local, e.g. for the per-request overrides of a default configuration.")
+	fs.BoolVar(&c.CAS, "cas", false, "Generate a CompareAndSwap(key, old, new) method, like the one of sync.Map in Go\n"+
+		"1.20, for the versions of sync/map.go that predate it. The values are compared\n"+
+		"with ==, and the value type must be comparable.")
+	fs.BoolVar(&c.LoadAndDelete, "loadanddelete", false, "Generate a LoadAndDelete method, like the one of sync.Map in Go 1.15, for the\n"+
+		"versions of sync/map.go that predate it. Maps that have the method are not affected.")
+	fs.BoolVar(&c.Ordered, "ordered", false, "Track the insertion order of the keys, and generate a RangeOrdered method that\n"+
+		"iterates over the map in this order. The writes of the map are serialized by a\n"+
+		"mutex that guards the order, and the reads are not affected.")
+	fs.BoolVar(&c.Multimap, "multimap", false, "Generate a multimap, whose values are slices, e.g. map[string][]int, with an\n"+
+		"Append(key, values...) method that appends to the slice of the key, and a Get(key)\n"+
+		"method that returns it. The writes of the map are serialized by a mutex, so that\n"+
+		"concurrent appends are not lost, and the reads are not affected.")
+	fs.BoolVar(&c.Capacity, "capacity", false, "Generate a New<name>WithCapacity(n int) constructor that pre-sizes the map for n\n"+
+		"entries, for maps that are bulk-loaded with a known approximate size.")
+	fs.BoolVar(&c.NoCopy, "nocopy", false, "Add a noCopy guard field to the map struct, like the one of the standard library,\n"+
+		"so that the copylocks check of go vet reports copies of the map (e.g. of structs\n"+
+		"that embed it by value).")
+	fs.BoolVar(&c.Internal, "internal", false, "Generate the map as an unexported implementation type, embedded in the exported\n"+
+		"map struct, whose methods are promoted from it. The docs of the map show only its\n"+
+		"exported methods, and the fields of the implementation are hidden behind the\n"+
+		"embedded field. The zero value of the map is ready for use.")
+	fs.StringVar(&c.MutexType, "mutex-type", "", "Type of the mutex of the map, instead of sync.Mutex, e.g. \"lockstat.Mutex\" for a lock\n"+
+		"that tracks contention. Its pointer must implement sync.Locker, and its zero value\n"+
+		"must be an unlocked mutex. Its package is imported like the packages of the map types.")
+	fs.IntVar(&c.Shards, "shards", 0, "Generate a sharded map of `n` shards, each a map of its own with a mutex of its own, for\n"+
+		"writes with more contention than a single map can handle. The shard of a key is selected\n"+
+		"by a hash of the key, which is builtin for string and integer keys, and is set by\n"+
+		"-shard-hash for the other key types. Range visits the shards in turn.")
+	fs.StringVar(&c.ShardHash, "shard-hash", "", "Hash `func` of the keys of -shards, e.g. \"user.HashID\", a func(K) uint64 of the key\n"+
+		"type. Equal keys must have equal hashes. Its package is imported like the packages of the\n"+
+		"map types.")
+	fs.BoolVar(&c.Simple, "simple", false, "Generate a plain Go map guarded by a mutex, with the same method set, instead\n"+
+		"of specializing sync/map.go. It does not scale like sync.Map under contention,\n"+
+		"but is smaller and easier to audit.")
+	fs.BoolVar(&c.Frozen, "readonly-after-init", false, "Experimental. Generate a map with a Freeze method, for maps that are written\n"+
+		"during initialization and only read afterwards. Before Freeze, it is a plain Go map\n"+
+		"guarded by a mutex. After Freeze, writes panic and reads take no lock. Freeze must\n"+
+		"happen-before the reads, i.e. it must not be called concurrently with them.")
+	fs.BoolVar(&c.Inline, "valueinline", false, "Experimental. Store the values inline in the map entries, guarded by a\n"+
+		"mutex, instead of as atomic pointers to copies of the values. This saves an\n"+
+		"allocation on every store, but locks the entry on every load. Use it only for\n"+
+		"small values that are immutable by convention, e.g. int or a small struct of\n"+
+		"comparable fields. Values that are pointers or contain pointers gain nothing\n"+
+		"from it.")
+	fs.BoolVar(&c.Slab, "slab", false, "Experimental. Store the values in a slab of the map, a slice of values whose\n"+
+		"slots are reused after deletes, instead of as atomic pointers to copies of the\n"+
+		"values. This improves the cache locality of the values and spares the garbage\n"+
+		"collector their allocations, but locks the slab, which is shared by all the\n"+
+		"entries, on every access. The slab never shrinks. Use it for many small values\n"+
+		"without pointers. Values with pointers are still scanned by the garbage collector.")
+	fs.BoolVar(&c.KeyInline, "keyinline", false, "Experimental. Store a copy of the key in each map entry, and read the keys from\n"+
+		"the entries in Range instead of from the internal map, for the locality of Range\n"+
+		"in Range-dominated workloads, e.g. exports. Every entry grows by the size of the\n"+
+		"key type.")
+	fs.BoolVar(&c.Set, "set", false, "Generate a concurrent set. The argument is the element type, and the\n"+
+		"generated type has an Add, Contains, Remove, Range and Len API. Internally, it\n"+
+		"is a map with struct{} values, whose methods are unexported.")
+	fs.BoolVar(&c.Ext, "ext", false, "Generate the helpers and the iteration methods in a separate \"_ext.go\" file,\n"+
+		"next to the output file, in order to keep the output file stable when they change.")
+	fs.StringVar(&c.Tags, "tags", "", "Build `constraint` for the optional generated code. If set, implies -ext, and\n"+
+		"the extension file and the generated tests are guarded by the constraint,\n"+
+		"e.g. \"debug || test\". \"@file\" reads the constraint from the first line of file,\n"+
+		"relative to the output directory. It can not be used with -metrics, whose counters\n"+
+		"are part of the map.")
+	err = fs.Parse(args)
+	check(err, "parse arguments")
+	c.Satisfies = satisfies.names
+	types := typeArgs(fs.Args())
+	if len(names.names) > 1 {
+		expect(!fromStdin, "-stdin can not be used with several -name")
+		for _, out := range outs.names {
+			expect(out != "", "-o is required with several -name")
+		}
+		expect(len(types) == len(names.names), "expected a type argument for each -name: %d names and %d types", len(names.names), len(types))
+		for _, l := range []struct {
+			flag   string
+			values []string
+		}{{"-o", outs.names}, {"-pkg", pkgs.names}} {
+			expect(len(l.values) == 1 || len(l.values) == len(names.names), "expected one %[1]s, or a %[1]s for each -name: %[2]d names and %[3]d %[1]s",
+				l.flag, len(names.names), len(l.values))
+		}
+		c.Args = args
+		for i, name := range names.names {
+			c.Name, c.Spec = name, types[i]
+			c.Out, c.Pkg = outs.names[0], pkgs.names[0]
+			if len(outs.names) > 1 {
+				c.Out = outs.names[i]
+			}
+			if len(pkgs.names) > 1 {
+				c.Pkg = pkgs.names[i]
+			}
+			cs = append(cs, c)
+		}
+		if len(outs.names) > 1 {
+			// Each file records only the command of its own maps, in order to regenerate
+			// it on its own.
+			flags := fileFlags(fs, args[:len(args)-len(fs.Args())])
+			for i := range cs {
+				cs[i].Args = fileArgs(flags, cs, cs[i].Out)
+			}
+		}
+		return
+	}
+	expect(len(outs.names) == 1 && len(pkgs.names) == 1, "several -o or -pkg require a -name for each of them")
+	c.Name, c.Out, c.Pkg = names.names[0], outs.names[0], pkgs.names[0]
+	if fromStdin {
+		expect(len(types) == 0, "unexpected argument %q. the type is read from stdin", fs.Arg(0))
+		var b []byte
+		b, err = ioutil.ReadAll(stdin)
+		check(err, "read stdin")
+		c.Spec = strings.TrimSpace(string(b))
+		expect(c.Spec != "", "missing type in stdin. expected map[T1]T2")
+		// The type is recorded instead of the flag, in order to regenerate the file
+		// without stdin.
+		for _, arg := range args {
+			if name := strings.TrimLeft(arg, "-"); name == arg || name != "stdin" && !strings.HasPrefix(name, "stdin=") {
+				c.Args = append(c.Args, arg)
+			}
+		}
+		c.Args = append(c.Args, c.Spec)
+		return []Config{c}, nil
+	}
+	if c.Set {
+		expect(len(types) > 0, "missing argument. expected the set element type")
+	}
+	expect(len(types) > 0, "missing argument. expected map[T1]T2")
+	c.Args = args
+	c.Spec = types[len(types)-1]
+	return []Config{c}, nil
+}
+
+// typeArgs returns the type arguments of the command line, without a trailing line comment
+// split into separate arguments, e.g. by a go:generate line that ends with "// cache".
+func typeArgs(args []string) []string {
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "//") {
+			return args[:i]
+		}
+	}
+	return args
+}
+
+// cleanSpec returns the type argument without its surrounding quotes and its trailing line
+// comment, e.g. as pasted into a go:generate line: "map[string]int // cache".
+func cleanSpec(s string) string {
+	s = trimComment(s)
+	if len(s) >= 2 && strings.ContainsRune("\"'`", rune(s[0])) && s[len(s)-1] == s[0] {
+		if u, err := strconv.Unquote(s); err == nil && s[0] == '"' {
+			s = u
+		} else {
+			s = s[1 : len(s)-1]
+		}
+		s = trimComment(s)
+	}
+	return s
+}
+
+// checkSpec fails with a targeted message for the common mistakes in the map type, given
+// the result of its parsing: slices, arrays, map literals, types that are not maps, and
+// incomplete map types. Other specs are left to the generic errors of the caller.
+func checkSpec(spec string, x ast.Expr, err error) {
+	if err != nil {
+		if strings.HasPrefix(spec, "map[") && strings.HasSuffix(spec, "]") && strings.Count(spec, "[") == strings.Count(spec, "]") {
+			expect(false, "incomplete map type %s: missing the value type. expected map[T1]T2, e.g. %sint", spec, spec)
+		}
+		if strings.HasPrefix(spec, "map") {
+			expect(false, "invalid map type %s: %v. expected map[T1]T2, e.g. map[string]int", spec, err)
+		}
+		return
+	}
+	switch x := x.(type) {
+	case *ast.MapType:
+	case *ast.ArrayType:
+		elt := types.ExprString(x.Elt)
+		switch x.Len.(type) {
+		case nil:
+			expect(false, "%s is a slice type. expected a map type, e.g. map[int]%s", spec, elt)
+		case *ast.Ident, *ast.SelectorExpr:
+			// [K]V parses as an array whose length is a named constant.
+			expect(false, "missing the map keyword in %s. expected map%s", spec, spec)
+		default:
+			expect(false, "%s is an array type. expected a map type, e.g. map[int]%s", spec, elt)
+		}
+	case *ast.CompositeLit:
+		if _, ok := x.Type.(*ast.MapType); ok {
+			expect(false, "%s is a map literal. expected the map type, without braces: %s", spec, types.ExprString(x.Type))
+		}
+		expect(false, "%s is a composite literal. expected map[T1]T2", spec)
+	default:
+		expect(false, "%s is not a map type. expected map[T1]T2, e.g. map[string]%s. use -set to generate a set of %s", spec, spec, spec)
+	}
+}
+
+// trimComment returns the source without its trailing line comment and surrounding spaces.
+// Comment markers in string literals (e.g. struct tags) are kept.
+func trimComment(s string) string {
+	var sc scanner.Scanner
+	fset := token.NewFileSet()
+	f := fset.AddFile("", fset.Base(), len(s))
+	sc.Init(f, []byte(s), nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			return strings.TrimSpace(s)
+		}
+		if tok == token.COMMENT && strings.HasPrefix(lit, "//") {
+			return strings.TrimSpace(s[:f.Offset(pos)])
+		}
+	}
+}
+
+// nameList is a flag.Value for the flags that can be repeated, e.g. -name. The first value
+// that is set replaces the default one.
+type nameList struct {
+	names []string
+	set   bool
+}
+
+func (l *nameList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(l.names, ",")
+}
+
+func (l *nameList) Set(s string) error {
+	if !l.set {
+		l.names, l.set = nil, true
+	}
+	l.names = append(l.names, s)
+	return nil
+}
+
+// Generate generates the typed syncmap object for the given config.
+func Generate(c Config) error {
+	_, err := GenerateStats(c)
+	return err
+}
+
 // Generator generates the typed syncmap object.
 type Generator struct {
 	// flag options.
-	pkg   string // package name.
-	out   string // file name.
-	name  string // struct name.
-	key   string // map key type.
-	value string // map value type.
+	Config
+	key         string                         // map key type.
+	value       string                         // map value type.
+	mapType     *ast.MapType                   // parsed map type.
+	mutexType   ast.Expr                       // parsed -mutex-type, or nil.
+	satisfies   []ast.Expr                     // parsed -satisfies.
+	constraints []ast.Expr                     // parsed constraints of the type parameters of -generic.
+	shardHash   ast.Expr                       // parsed -shard-hash, or nil.
+	locals      map[string]ast.Expr            // types declared in the output package.
+	decls       map[string]string              // identifiers declared in the output package.
+	recvs       map[string]map[string]bool     // methods of the types of the output package, mapped to whether their receiver is a pointer.
+	imports     map[string]string              // imports of the output package.
+	packages    map[string][]*packages.Package // packages imported by the output package, loaded by -packages.
+	ext         []byte                         // source of the extension file.
+	extImports  []string                       // imports of the extension file.
+	header      *template.Template             // file header.
+	methods     *template.Template             // custom methods of -methods.
+	goroot      string                         // root of the Go tree to read sync/map.go from.
+	stderr      io.Writer                      // destination of warnings and logs.
+	src         []byte                         // content of sync/map.go, kept across resets.
+	srcPath     string                         // path of sync/map.go, or of its embedded copy.
+	templates   map[string]*fastTemplate       // cached templates of Fast, kept across resets.
+	body        []byte                         // source of the main file, if generated from a template.
+	generated   []generatedFile                // files written by the generator, for -verify.
+	stats       Stats                          // statistics of the generation.
 	// mutation state and traversal handlers.
 	file   *ast.File
 	fset   *token.FileSet
 	funcs  map[string]func(*ast.FuncDecl)
 	types  map[string]func(*ast.TypeSpec)
-	values map[string]func(*ast.ValueSpec)
+	values map[string]func(*ast.GenDecl)
 }
 
 // NewGenerator returns a new generator for syncmap.
-func NewGenerator() (g *Generator, err error) {
+func NewGenerator(c Config) (*Generator, error) {
+	g := newGenerator()
+	if err := g.Reset(c); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Reset resets the state of the generator and configures it for the given config, so it
+// can be reused for generating another map. The content of sync/map.go is read only once
+// and is kept across resets, unless the config has a different Source.
+func (g *Generator) Reset(c Config) (err error) {
 	defer catch(&err)
-	g = &Generator{fset: token.NewFileSet(), pkg: *pkg, out: *out, name: *name}
-	g.funcs = g.Funcs()
-	g.types = g.Types()
-	g.values = g.Values()
-	exp, err := parser.ParseExpr(os.Args[len(os.Args)-1])
-	check(err, "parse expr: %s", os.Args[len(os.Args)-1])
+	src, srcPath := g.src, g.srcPath
+	if c.Source != g.Source {
+		// The cached content is of another file.
+		src, srcPath = nil, ""
+	}
+	*g = Generator{Config: c, fset: token.NewFileSet(), goroot: g.goroot, stderr: g.stderr, src: src, srcPath: srcPath, templates: g.templates}
+	if g.templates == nil {
+		g.templates = make(map[string]*fastTemplate)
+	}
+	g.Spec = cleanSpec(g.Spec)
+	if g.Header != "" {
+		g.header, err = template.New("header").Parse(g.Header)
+		check(err, "parse header template")
+	}
+	spec := g.Spec
+	if g.Set {
+		spec = fmt.Sprintf("map[%s]struct{}", spec)
+	}
+	exp, err := parser.ParseExpr(spec)
+	if !g.Set {
+		checkSpec(spec, exp, err)
+	}
+	check(err, "parse expr: %s", g.Spec)
 	m, ok := exp.(*ast.MapType)
 	expect(ok, "invalid argument. expected map[T1]T2")
+	g.mapType = m
 	b := bytes.NewBuffer(nil)
 	err = format.Node(b, g.fset, m.Key)
 	check(err, "format map key")
@@ -83,79 +625,880 @@ func NewGenerator() (g *Generator, err error) {
 	err = format.Node(b, g.fset, m.Value)
 	check(err, "format map value")
 	g.value = b.String()
-	if g.out == "" {
-		g.out = strings.ToLower(g.name) + ".go"
+	if g.Generic {
+		g.checkTypeParams()
+	}
+	if g.MutexType != "" {
+		g.checkMutexType()
+	}
+	expect(token.IsIdentifier(g.Pkg), "invalid package name: %q", g.Pkg)
+	if g.Out == "" {
+		g.Out = strings.ToLower(g.Name) + g.Suffix + ".go"
+		if g.testPkg() {
+			g.Out = strings.ToLower(g.Name) + g.Suffix + "_test.go"
+		}
+	}
+	// The files of external test packages are compiled only with the tests of the
+	// package under test.
+	expect(!g.testPkg() || strings.HasSuffix(g.Out, "_test.go"), "output file of the external test package %s must end with _test.go: %s", g.Pkg, g.Out)
+	g.checkQualifiers("key", g.key, g.mapType.Key)
+	g.checkQualifiers("value", g.value, g.mapType.Value)
+	for i, x := range g.constraints {
+		g.checkQualifiers("constraint", []string{g.KeyConstraint, g.ValueConstraint}[i], x)
+	}
+	if len(g.Satisfies) > 0 {
+		g.checkSatisfies()
+	}
+	expect(g.Shards != 0 || g.ShardHash == "", "-shard-hash requires -shards")
+	if g.Shards != 0 {
+		g.checkShards()
+	}
+	g.checkCollisions()
+	for _, name := range g.Helpers {
+		h := lookupHelper(name)
+		expect(h != nil, "unknown helper %q. expected one of: %s", name, helperNames())
+		if h.check != nil {
+			h.check(g)
+		}
+	}
+	expect(!g.Simple || !g.Inline, "-valueinline can not be used with -simple")
+	expect(!g.Simple || !g.Line, "-line can not be used with -simple")
+	if g.Frozen {
+		expect(!g.Simple, "-readonly-after-init can not be used with -simple")
+		expect(!g.Inline, "-valueinline can not be used with -readonly-after-init")
+		expect(!g.Line, "-line can not be used with -readonly-after-init")
+		expect(!g.Clear, "-clear can not be used with -readonly-after-init")
+		expect(!g.Compact, "-compact can not be used with -readonly-after-init")
+	}
+	if g.KeyInline {
+		expect(!g.Simple, "-keyinline can not be used with -simple")
+		expect(!g.Frozen, "-keyinline can not be used with -readonly-after-init")
+	}
+	if g.Slab {
+		expect(!g.Simple, "-slab can not be used with -simple")
+		expect(!g.Frozen, "-slab can not be used with -readonly-after-init")
+		expect(!g.Inline, "-slab can not be used with -valueinline")
+		expect(!g.Set, "-slab can not be used with -set")
+		if p := g.pointers(g.mapType.Value); p != "" {
+			if p == g.value {
+				p = ""
+			} else {
+				p = " (" + p + ")"
+			}
+			fmt.Fprintf(g.stderr, "syncmap: warning: value type %s has pointers%s, and the slab of -slab is "+
+				"scanned by the garbage collector. -slab is meant for values without pointers\n", g.value, p)
+		}
+	}
+	if g.CAS {
+		expect(!g.Set, "-cas can not be used with -set")
+		expect(g.comparable(g.mapType.Value), "-cas: value type %s is not comparable", g.value)
+	}
+	if g.Packages {
+		g.loadPackages()
+		// Fail early if a type can not be resolved.
+		g.typeImports()
+		if g.mutexType != nil {
+			g.importsOf(g.mutexType)
+		}
+		g.importsOf(g.constraints...)
+	}
+	if g.Ordered {
+		expect(!g.Set, "-ordered can not be used with -set")
+		expect(!g.Frozen, "-ordered can not be used with -readonly-after-init")
+	}
+	if g.Promotions {
+		expect(!g.Simple, "-promotions can not be used with -simple")
+		expect(!g.Frozen, "-promotions can not be used with -readonly-after-init")
+	}
+	if g.Wait {
+		expect(!g.Set, "-wait can not be used with -set")
+	}
+	if g.Compute {
+		expect(!g.Set, "-compute can not be used with -set")
+	}
+	if g.Parent {
+		expect(!g.Set, "-parent can not be used with -set")
+	}
+	if g.Multimap {
+		expect(!g.Set, "-multimap can not be used with -set")
+		expect(!g.Frozen, "-multimap can not be used with -readonly-after-init")
+		expect(!g.Ordered, "-multimap can not be used with -ordered")
+		g.checkMultimap()
+	}
+	for _, t := range []struct {
+		kind, name string
+		expr       ast.Expr
+	}{
+		{"key", g.key, g.mapType.Key},
+		{"value", g.value, g.mapType.Value},
+	} {
+		if lock := g.lockPath(t.expr); lock != "" {
+			fmt.Fprintf(g.stderr, "syncmap: warning: %s type %s contains a %s, and is copied by the map methods. "+
+				"go vet reports the copies. use a pointer type instead, e.g. *%s\n", t.kind, t.name, lock, t.name)
+		}
+	}
+	if strings.HasPrefix(g.Tags, "@") {
+		g.Tags = g.readTags(g.Tags[1:])
+	}
+	if g.Methods != "" {
+		g.methods = g.readMethods(g.Methods)
+	}
+	if g.Tags != "" {
+		_, err := constraint.Parse("//go:build " + g.Tags)
+		check(err, "invalid -tags constraint: %q", g.Tags)
+		// The counters are fields of the map, and its Stats method reads them.
+		expect(!g.Metrics, "-metrics can not be used with -tags, as the counters of the metrics are part of the map "+
+			"and can not be guarded by the build constraint")
 	}
 	return
 }
 
+// KeyType returns the parsed key type of the map, as written in the config. It is shared
+// with the generator, and must not be modified.
+func (g *Generator) KeyType() ast.Expr { return g.mapType.Key }
+
+// ValueType returns the parsed value type of the map, as written in the config. The value
+// type of sets is struct{}. It is shared with the generator, and must not be modified.
+func (g *Generator) ValueType() ast.Expr { return g.mapType.Value }
+
+// TypeImports returns the imports of the packages referenced by the key and value types,
+// as import specs (e.g. `u "example.com/user"`). Only the imports that are resolved using
+// the other files of the output package are returned. The others are left for goimports.
+func (g *Generator) TypeImports() []string {
+	var specs []string
+	for _, s := range g.typeImports() {
+		specs = append(specs, s.String())
+	}
+	return specs
+}
+
 // Mutate mutates the original `sync/map` AST and brings it to the desired state.
 // It fails if it encounters an unrecognized node in the AST.
 func (g *Generator) Mutate() (err error) {
 	defer catch(&err)
-	path := fmt.Sprintf("%s/src/sync/map.go", runtime.GOROOT())
-	b, err := ioutil.ReadFile(path)
-	check(err, "read %q file", path)
-	f, err := parser.ParseFile(g.fset, "", b, parser.ParseComments)
+	defer func(start time.Time) { g.stats.Mutate += time.Since(start) }(time.Now())
+	// The custom methods, the PostMutate hook and the unsupported sources of -best-effort
+	// are not part of the cached templates.
+	if g.Fast && g.methods == nil && g.PostMutate == nil && !g.Generic && !g.BestEffort && g.fastTypes() {
+		g.logf("generating %s from a cached template", g.Name)
+		g.mutateFast()
+		return
+	}
+	if g.Simple {
+		g.logf("generating %s as a simple map", g.Name)
+		g.file = g.simpleFile()
+	} else if g.Frozen {
+		g.logf("generating %s as a freezable map", g.Name)
+		g.file = g.templateFile(freezeTmpl)
+	} else {
+		g.file = g.mutateSource()
+		if g.Line {
+			g.addLineDirectives()
+		}
+	}
+	if !g.Generic {
+		// Package variables can not be generic. Generic maps use *new(V) instead.
+		g.addZeroValue()
+	}
+	if g.Inline {
+		g.addInlineValues()
+	}
+	if g.Slab {
+		g.addSlabValues()
+	}
+	if g.KeyInline {
+		g.addEntryKeys()
+	}
+	if g.LoadAndDelete {
+		// Before the methods are wrapped by other options, e.g. -metrics.
+		g.addLoadAndDelete()
+	}
+	g.addTypeImports()
+	if g.Parent {
+		// Before the methods are wrapped by other options, e.g. -metrics.
+		g.addParent()
+	}
+	if g.Metrics {
+		g.addMetrics()
+	}
+	if g.Clear {
+		g.addClear()
+	}
+	if g.Compact {
+		g.addCompact()
+	}
+	if g.ApproxLen {
+		g.addApproxLen()
+	}
+	if g.Promotions {
+		g.addPromotions()
+	}
+	if g.Counter {
+		// Before the methods are wrapped by -ordered, -multimap and -set.
+		g.addCounter()
+	}
+	if g.Wait {
+		g.addWait()
+	}
+	if g.Capacity {
+		g.addCapacity()
+	}
+	if g.NoCopy {
+		g.addNoCopy()
+	}
+	if g.CAS {
+		g.addCompareAndSwap()
+	}
+	if g.Ordered {
+		g.addOrdered()
+	}
+	if g.Multimap {
+		g.addMultimap()
+	}
+	if g.Compute {
+		// After the methods are wrapped by -ordered and -multimap.
+		g.addCompute()
+	}
+	if g.Comparable {
+		g.appendSource([]byte(fmt.Sprintf(`
+// The map key type must be comparable. If the declaration below fails to compile,
+// %s can not be used as a map key.
+var _ map[%s]struct{}
+`, g.key, g.key)))
+	}
+	if g.Set {
+		g.addSet()
+	}
+	if g.Trace {
+		// After the API of -set, and before the helpers that call it.
+		g.addTrace()
+	}
+	if g.Shards != 0 {
+		// After all the methods of the shards are added, and before the helpers.
+		g.addShards()
+	}
+	if len(g.Helpers) > 0 || g.Iter || g.ChanIter {
+		g.addHelpers()
+	}
+	if g.methods != nil {
+		g.addMethods()
+	}
+	if g.Internal {
+		// After all the methods are added to the implementation.
+		g.addInternal()
+	}
+	if g.Generic {
+		g.addTypeParams()
+	}
+	if len(g.Satisfies) > 0 {
+		// After all the methods are added, and the generic types are instantiated.
+		g.addSatisfies()
+	}
+	if g.PostMutate != nil {
+		err := g.PostMutate(g.file, g.fset)
+		check(err, "post-mutate hook")
+	}
+	// After all the mutations, as they may add or remove the uses of the packages.
+	g.fixStdImports()
+	return
+}
+
+// mutateSource parses sync/map.go, and returns its mutated AST.
+func (g *Generator) mutateSource() *ast.File {
+	var err error
+	path := fmt.Sprintf("%s/src/sync/map.go", g.goroot)
+	if g.Source != "" {
+		path = g.Source
+	}
+	if g.src == nil {
+		g.src, err = ioutil.ReadFile(path)
+		g.srcPath = path
+		if os.IsNotExist(err) && g.Source == "" {
+			fmt.Fprintf(g.stderr, "syncmap: warning: %q does not exist. using the embedded copy from %s, "+
+				"which may differ from the installed version\n", path, embeddedVersion)
+			g.src, g.srcPath, err = embeddedSource, "sync/map.go", nil
+		}
+		check(err, "read %q file", path)
+		g.logf("read %s", g.srcPath)
+	}
+	// The handlers are consumed by the traversal below, and are rebuilt on each call.
+	g.funcs = g.Funcs()
+	g.types = g.Types()
+	g.values = g.Values()
+	f, err := parser.ParseFile(g.fset, "", g.src, parser.ParseComments)
 	check(err, "parse %q file", path)
-	f.Name.Name = g.pkg
-	astutil.AddImport(g.fset, f, "sync")
+	f.Name.Name = g.Pkg
+	g.addImport(f, "", "sync")
+	g.checkGeneric(f, path)
 	for _, d := range f.Decls {
 		switch d := d.(type) {
 		case *ast.FuncDecl:
+			g.logf("handling func %s", d.Name.Name)
 			handler, ok := g.funcs[d.Name.Name]
+			if !ok && g.BestEffort {
+				g.skipUnrecognized("func", d.Name.Name, d)
+				g.stats.Decls++
+				continue
+			}
 			expect(ok, "unrecognized function: %s", d.Name.Name)
-			handler(d)
+			g.handle("func", d.Name.Name, d, func() { handler(d) })
+			g.stats.Decls++
 			delete(g.funcs, d.Name.Name)
 		case *ast.GenDecl:
-			switch d := d.Specs[0].(type) {
+			switch s := d.Specs[0].(type) {
 			case *ast.TypeSpec:
-				handler, ok := g.types[d.Name.Name]
-				expect(ok, "unrecognized type: %s", d.Name.Name)
-				handler(d)
-				delete(g.types, d.Name.Name)
+				g.logf("handling type %s", s.Name.Name)
+				handler, ok := g.types[s.Name.Name]
+				if !ok && g.BestEffort {
+					g.skipUnrecognized("type", s.Name.Name, d)
+					g.stats.Decls++
+					continue
+				}
+				expect(ok, "unrecognized type: %s", s.Name.Name)
+				g.handle("type", s.Name.Name, d, func() { handler(s) })
+				g.stats.Decls++
+				delete(g.types, s.Name.Name)
 			case *ast.ValueSpec:
-				handler, ok := g.values[d.Names[0].Name]
-				expect(ok, "unrecognized value: %s", d.Names[0].Name)
-				handler(d)
-				expect(len(d.Names) == 1, "mismatch values length: %d", len(d.Names))
-				delete(g.values, d.Names[0].Name)
+				g.logf("handling value %s", s.Names[0].Name)
+				handler, ok := g.values[s.Names[0].Name]
+				if !ok && g.BestEffort {
+					g.skipUnrecognized("value", s.Names[0].Name, d)
+					g.stats.Decls++
+					continue
+				}
+				expect(ok, "unrecognized value: %s", s.Names[0].Name)
+				g.handle("value", s.Names[0].Name, d, func() { handler(d) })
+				g.stats.Decls++
+				expect(len(s.Names) == 1, "mismatch values length: %d", len(s.Names))
+				delete(g.values, s.Names[0].Name)
 			}
 		default:
 			expect(false, "unrecognized type: %s", d)
 		}
 	}
+	if g.LoadAndDelete {
+		// sync/map.go predates LoadAndDelete, and it is added by addLoadAndDelete.
+		delete(g.funcs, "LoadAndDelete")
+	}
+	if g.BestEffort {
+		g.warnMissing()
+		g.funcs, g.types, g.values = nil, nil, nil
+	}
+	if _, ok := g.funcs["LoadAndDelete"]; ok && len(g.funcs) == 1 {
+		expect(false, "sync/map.go has no LoadAndDelete method, as in Go 1.14 and earlier. use -loadanddelete to generate it")
+	}
 	expect(len(g.funcs) == 0, "function was deleted")
 	expect(len(g.types) == 0, "type was deleted")
 	expect(len(g.values) == 0, "value was deleted")
-	rename(f, map[string]string{
-		"Map":      g.name,
-		"entry":    "entry" + strings.Title(g.name),
-		"readOnly": "readOnly" + strings.Title(g.name),
-		"expunged": "expunged" + strings.Title(g.name),
-		"newEntry": "newEntry" + strings.Title(g.name),
-	})
-	g.file = f
-	return
+	renames := g.renames()
+	if g.Verbose {
+		var names []string
+		for name := range renames {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			g.logf("renaming %s to %s", name, renames[name])
+		}
+	}
+	rename(f, renames)
+	g.documentMap(f)
+	return f
+}
+
+// appendSource appends the given declarations to the mutated file. The file is formatted
+// together with the source, and then parsed back into a new file set. Imports that are
+// used by the declarations must be added to the file beforehand.
+func (g *Generator) appendSource(src []byte) {
+	b := bytes.NewBuffer(nil)
+	err := format.Node(b, g.fset, g.file)
+	check(err, "format mutated code")
+	if g.Line {
+		fmt.Fprintf(b, "\n%s\n", lineOut)
+	}
+	b.Write(src)
+	g.fset = token.NewFileSet()
+	g.file, err = parser.ParseFile(g.fset, "", b.Bytes(), parser.ParseComments)
+	check(err, "parse appended source")
+}
+
+// renames returns the mapping from the top-level identifiers of `sync/map` to the
+// identifiers used in the generated code.
+func (g *Generator) renames() map[string]string {
+	if g.Simple || g.Frozen {
+		return map[string]string{"Map": g.Name}
+	}
+	return map[string]string{
+		"Map":      g.Name,
+		"entry":    "entry" + strings.Title(g.Name),
+		"readOnly": "readOnly" + strings.Title(g.Name),
+		"expunged": "expunged" + strings.Title(g.Name),
+		"newEntry": "newEntry" + strings.Title(g.Name),
+	}
+}
+
+// checkCollisions fails if an identifier declared by the generated code is already
+// declared in another file of the output package (e.g. by another generated map).
+func (g *Generator) checkCollisions() {
+	var names []string
+	for _, name := range g.renames() {
+		names = append(names, name)
+	}
+	names = append(names, g.zeroValue())
+	if g.Metrics {
+		names = append(names, g.Name+"Stats")
+	}
+	if g.Capacity {
+		names = append(names, g.capacityFunc())
+	}
+	if g.NoCopy {
+		names = append(names, g.noCopyType())
+	}
+	if g.Slab {
+		names = append(names, g.slabType())
+	}
+	if g.Internal {
+		names = append(names, g.internalType())
+	}
+	if g.Shards != 0 {
+		names = append(names, g.shardType(), g.shardSeed())
+	}
+	if g.Compute {
+		names = append(names, g.computeType())
+	}
+	if g.Trace {
+		names = append(names, g.traceVar())
+	}
+	if g.Parent {
+		names = append(names, g.parentFunc())
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path, ok := g.declared()[name]
+		expect(!ok, "identifier %s is already declared in %s. use a different -name", name, path)
+	}
 }
 
 // Gen dumps the mutated AST to a file in the configured destination.
 func (g *Generator) Gen() (err error) {
 	defer catch(&err)
-	b := bytes.NewBuffer([]byte("// Code generated by syncmap; DO NOT EDIT.\n\n"))
-	err = format.Node(b, g.fset, g.file)
-	check(err, "format mutated code")
-	src, err := imports.Process(g.out, b.Bytes(), nil)
-	check(err, "running goimports on: %s", g.out)
-	err = ioutil.WriteFile(g.out, src, 0644)
-	check(err, "writing file: %s", g.out)
+	defer func(start time.Time) { g.stats.Gen += time.Since(start) }(time.Now())
+	b := bytes.NewBuffer(nil)
+	g.writeFileHeader(b)
+	b.Write(g.source())
+	g.write(g.Out, b.Bytes())
+	if g.ext != nil {
+		g.genExt()
+	}
+	if g.Size > 0 {
+		g.genSizeTest()
+	}
+	if g.Zero {
+		g.genZeroTest()
+	}
+	if g.Verify {
+		g.verify(g.stubTypes())
+	}
 	return
 }
 
-// Values returns all ValueSpec handlers for AST mutation.
-func (g *Generator) Values() map[string]func(*ast.ValueSpec) {
-	return map[string]func(*ast.ValueSpec){
-		"expunged": func(v *ast.ValueSpec) { g.replaceValue(v) },
+// writeFileHeader writes the header of the main file: the generated code marker, the
+// recorded command and the header template.
+func (g *Generator) writeFileHeader(b *bytes.Buffer) {
+	if !g.NoHeader {
+		b.WriteString(generatedMarker + "\n")
+		if g.Args != nil {
+			fmt.Fprintf(b, "%s%s\n", commandPrefix, quoteArgs(g.Args))
+		}
+		b.WriteString("\n")
+	}
+	if g.header != nil {
+		g.writeHeader(b)
+	}
+}
+
+// source returns the source of the main file, printed from the mutated AST, or substituted
+// in the template of Fast. The imports of the source are not resolved yet.
+func (g *Generator) source() []byte {
+	if g.body != nil {
+		return g.body
+	}
+	b := bytes.NewBuffer(nil)
+	if err := formatNode(b, g.fset, g.file); err != nil {
+		g.dumpMalformed()
+		check(err, "format mutated code (the AST is printed above)")
+	}
+	return b.Bytes()
+}
+
+// formatNode is like format.Node, but returns the panics of the printer as errors, e.g.
+// for nodes with missing fields.
+func formatNode(w io.Writer, fset *token.FileSet, node interface{}) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("panic: %v", e)
+		}
+	}()
+	return format.Node(w, fset, node)
+}
+
+// dumpMalformed prints the AST of the declarations of the mutated file that can not be
+// formatted to stderr, for diagnosing the mutations that produce them (e.g. a substituted
+// type with invalid positions). If each declaration can be formatted on its own, the AST
+// of the entire file is printed.
+func (g *Generator) dumpMalformed() {
+	var bad []ast.Decl
+	for _, d := range g.file.Decls {
+		if err := formatNode(ioutil.Discard, g.fset, d); err != nil {
+			bad = append(bad, d)
+		}
+	}
+	var node interface{} = g.file
+	if len(bad) > 0 {
+		node = bad
+	}
+	fmt.Fprintln(g.stderr, "syncmap: the mutated code can not be formatted. AST of the malformed declarations:")
+	ast.Fprint(g.stderr, g.fset, node, ast.NotNilFilter)
+}
+
+// write formats the given source and writes it to path. The written source ends with a
+// single newline, and uses the configured line endings.
+func (g *Generator) write(path string, b []byte) {
+	var src []byte
+	var err error
+	start := time.Now()
+	if g.Format != nil {
+		src, err = g.Format(b)
+		check(err, "formatting file: %s", path)
+	} else {
+		src, err = imports.Process(path, b, nil)
+		check(err, "running goimports on: %s", path)
+		if !g.NoGofmt {
+			// goimports may format the code differently than the gofmt of the installed
+			// Go version. Run gofmt explicitly to keep the output stable across machines.
+			src, err = format.Source(src)
+			check(err, "running gofmt on: %s", path)
+		}
+	}
+	g.stats.Format += time.Since(start)
+	src = bytes.ReplaceAll(src, []byte("\r\n"), []byte("\n"))
+	src = append(bytes.TrimRight(fixLineDirectives(path, src), "\n"), '\n')
+	if g.CRLF {
+		// The carriage returns in raw strings are discarded by the compiler, so the
+		// line endings do not change the meaning of the code.
+		src = bytes.ReplaceAll(src, []byte("\n"), []byte("\r\n"))
+	}
+	g.stats.Files++
+	g.stats.Bytes += len(src)
+	g.generated = append(g.generated, generatedFile{path, src})
+	g.writeFile(path, src)
+}
+
+// perm is a flag.Value for file permissions in octal.
+type perm fs.FileMode
+
+func (p *perm) String() string {
+	if *p == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%#o", uint32(*p))
+}
+
+func (p *perm) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v == 0 || v > uint64(fs.ModePerm) {
+		return fmt.Errorf("invalid permissions %q. expected an octal number between 1 and 0777", s)
+	}
+	*p = perm(v)
+	return nil
+}
+
+// writeFile writes the source to path using the WriteFile hook of the config. By default,
+// the source is written to a temporary file in the directory of path, and then renamed to
+// path. Hence, readers of path (e.g. file watchers) never see a partially written file.
+// If path already has the same content, it is left untouched to keep its modification time.
+func (g *Generator) writeFile(path string, src []byte) {
+	mode := g.Perm
+	if mode == 0 {
+		mode = 0644
+	}
+	if g.WriteFile != nil {
+		err := g.WriteFile(path, src, mode)
+		check(err, "writing file: %s", path)
+		return
+	}
+	if b, err := ioutil.ReadFile(path); err == nil && bytes.Equal(b, src) {
+		g.logf("skipping unchanged %s", path)
+		// The permissions may have changed. Unlike the content, they do not affect the
+		// modification time.
+		err = os.Chmod(path, mode)
+		check(err, "writing file: %s", path)
+		return
+	}
+	g.logf("writing %s", path)
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	check(err, "writing file: %s", path)
+	defer os.Remove(f.Name())
+	_, err = f.Write(src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	check(err, "writing file: %s", path)
+	err = os.Chmod(f.Name(), mode)
+	check(err, "writing file: %s", path)
+	err = os.Rename(f.Name(), path)
+	check(err, "writing file: %s", path)
+}
+
+// genExt generates the extension file that holds the helpers, guarded by the build constraint
+// if there is one.
+func (g *Generator) genExt() {
+	b := bytes.NewBuffer(nil)
+	g.writeSiblingHeader(b)
+	b.WriteString(g.buildLines())
+	fmt.Fprintf(b, "package %s\n\n", g.Pkg)
+	for _, path := range g.extImports {
+		g.stats.Imports++
+		fmt.Fprintf(b, "import %q\n", path)
+	}
+	b.Write(g.ext)
+	g.write(g.siblingFile("_ext.go"), b.Bytes())
+}
+
+// writeSiblingHeader writes the header of the files generated next to the main file: the
+// generated code marker and the header template. Unlike the main file, the command is not
+// recorded in them.
+func (g *Generator) writeSiblingHeader(b *bytes.Buffer) {
+	if !g.NoHeader {
+		b.WriteString(generatedMarker + "\n\n")
+	}
+	if g.header != nil {
+		g.writeHeader(b)
+	}
+}
+
+// readTags reads the build constraint from the first non-empty line of the given file.
+// The line is either a "//go:build" line or a bare expression. Relative paths are resolved
+// against the directory of the output file, which is the package directory when running
+// by go generate.
+func (g *Generator) readTags(path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(g.Out), path)
+	}
+	b, err := ioutil.ReadFile(path)
+	check(err, "read -tags file")
+	for _, l := range strings.Split(string(b), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			return strings.TrimSpace(strings.TrimPrefix(l, "//go:build"))
+		}
+	}
+	expect(false, "-tags file %s is empty", path)
+	return ""
+}
+
+// buildLines returns the build constraint lines of the optional generated files.
+func (g *Generator) buildLines() string {
+	if g.Tags == "" {
+		return ""
+	}
+	expr, err := constraint.Parse("//go:build " + g.Tags)
+	check(err, "parse build constraint: %q", g.Tags)
+	lines, err := constraint.PlusBuildLines(expr)
+	check(err, "convert build constraint: %q", g.Tags)
+	return fmt.Sprintf("//go:build %s\n%s\n\n", expr, strings.Join(lines, "\n"))
+}
+
+// testPkg reports whether the generated code is in an external test package (e.g. foo_test).
+func (g *Generator) testPkg() bool {
+	return strings.HasSuffix(g.Pkg, "_test")
+}
+
+// siblingFile returns the path of a file that is generated next to the output file, with
+// the given suffix (e.g. "_ext.go"). In external test packages, all generated files are
+// test files, and the suffix is added before the "_test.go" of the output file.
+func (g *Generator) siblingFile(suffix string) string {
+	if !g.testPkg() {
+		return strings.TrimSuffix(g.Out, ".go") + suffix
+	}
+	if !strings.HasSuffix(suffix, "_test.go") {
+		suffix = strings.TrimSuffix(suffix, ".go") + "_test.go"
+	}
+	return strings.TrimSuffix(g.Out, "_test.go") + suffix
+}
+
+// genSizeTest generates a test file that fails if the size of the generated struct changes.
+func (g *Generator) genSizeTest() {
+	b := bytes.NewBuffer(nil)
+	g.writeSiblingHeader(b)
+	err := sizeTest.Execute(b, map[string]interface{}{
+		"Pkg":   g.Pkg,
+		"Name":  g.Name,
+		"Title": strings.Title(g.Name),
+		"Size":  g.Size,
+		"Build": g.buildLines(),
+	})
+	check(err, "execute size test template")
+	g.write(g.siblingFile("_size_test.go"), b.Bytes())
+}
+
+var sizeTest = template.Must(template.New("size").Parse(`{{.Build}}package {{.Pkg}}
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func Test{{.Title}}Size(t *testing.T) {
+	if size := unsafe.Sizeof({{.Name}}{}); size != {{.Size}} {
+		t.Fatalf("unexpected size of {{.Name}}: %d, want {{.Size}}", size)
+	}
+}
+`))
+
+// genZeroTest generates a test file that fails if the zero value of the generated map can
+// not be used without initialization.
+func (g *Generator) genZeroTest() {
+	b := bytes.NewBuffer(nil)
+	g.writeSiblingHeader(b)
+	err := zeroTest.Execute(b, map[string]interface{}{
+		"Pkg":    g.Pkg,
+		"Name":   g.Name,
+		"Title":  strings.Title(g.Name),
+		"Key":    g.key,
+		"Value":  g.value,
+		"Load":   g.method("Load"),
+		"Store":  g.method("Store"),
+		"Delete": g.method("Delete"),
+		"Range":  g.method("Range"),
+		"Build":  g.buildLines(),
+	})
+	check(err, "execute zero test template")
+	g.write(g.siblingFile("_zero_test.go"), b.Bytes())
+}
+
+var zeroTest = template.Must(template.New("zero").Parse(`{{.Build}}package {{.Pkg}}
+
+import "testing"
+
+func Test{{.Title}}ZeroValue(t *testing.T) {
+	var (
+		m     {{.Name}}
+		key   {{.Key}}
+		value {{.Value}}
+	)
+	if _, ok := m.{{.Load}}(key); ok {
+		t.Fatal("the zero {{.Name}} should be empty")
+	}
+	m.{{.Store}}(key, value)
+	if _, ok := m.{{.Load}}(key); !ok {
+		t.Fatal("the zero {{.Name}} should be ready for use")
+	}
+	n := 0
+	m.{{.Range}}(func({{.Key}}, {{.Value}}) bool {
+		n++
+		return true
+	})
+	if n != 1 {
+		t.Fatalf("unexpected number of entries in {{.Name}}: %d, want 1", n)
+	}
+	m.{{.Delete}}(key)
+	if _, ok := m.{{.Load}}(key); ok {
+		t.Fatal("deleted key should not be found in {{.Name}}")
+	}
+}
+`))
+
+// writeHeader executes the header template and writes its lines as comments.
+func (g *Generator) writeHeader(b *bytes.Buffer) {
+	h := bytes.NewBuffer(nil)
+	err := g.header.Execute(h, struct{ Year int }{time.Now().Year()})
+	check(err, "execute header template")
+	for _, l := range strings.Split(strings.TrimSpace(h.String()), "\n") {
+		if !strings.HasPrefix(l, "//") {
+			l = strings.TrimSpace("// " + l)
+		}
+		b.WriteString(l + "\n")
+	}
+	b.WriteString("\n")
+}
+
+// Values returns all ValueSpec handlers for AST mutation. The handlers get the declaration
+// of the value, in order to be able to document it.
+func (g *Generator) Values() map[string]func(*ast.GenDecl) {
+	return map[string]func(*ast.GenDecl){
+		// expunged must not point to a zero-sized value (e.g. struct{}), as all zero-sized
+		// allocations may share the same address, including the ones of the stored values.
+		"expunged": func(d *ast.GenDecl) {
+			replaceIface(d.Specs[0], "byte")
+			appendDoc(d.Doc, "It is only compared by pointer identity with the entry pointers, and never",
+				"dereferenced. It points to a byte rather than to a value of the map, as values",
+				"of zero size (e.g. struct{}) may share the same address.")
+		},
+	}
+}
+
+// appendDoc appends a paragraph of the given lines to the comment.
+func appendDoc(doc *ast.CommentGroup, lines ...string) {
+	expect(doc != nil && len(doc.List) > 0, "missing doc comment")
+	c := doc.List[len(doc.List)-1]
+	c.Text += "\n//"
+	for _, l := range lines {
+		c.Text += "\n// " + l
+	}
+}
+
+var (
+	docArticle = regexp.MustCompile(`\b[Aa] Map\b`)
+	docName    = regexp.MustCompile(`\bMap\b`)
+	docType    = regexp.MustCompile(`map\[(interface\{\}|any)\](interface\{\}|any)`)
+)
+
+// documentMap rewrites the doc comment of the map type, that describes sync.Map, to describe
+// the generated map. As for sync.Map, the doc states that the zero value is ready for use.
+func (g *Generator) documentMap(f *ast.File) {
+	for _, d := range f.Decls {
+		d, ok := d.(*ast.GenDecl)
+		if !ok || d.Tok != token.TYPE || d.Specs[0].(*ast.TypeSpec).Name.Name != g.Name {
+			continue
+		}
+		expect(d.Doc != nil && len(d.Doc.List) > 0, "missing doc comment of %s", g.Name)
+		// The map type is printed on a single line, as the formatted types may span
+		// multiple lines (e.g. structs with several fields). It is the map of struct{}
+		// values of sets.
+		spec := types.ExprString(g.mapType)
+		for _, c := range d.Doc.List {
+			c.Text = docArticle.ReplaceAllStringFunc(c.Text, func(s string) string {
+				a := article(g.Name)
+				if s[0] == 'A' {
+					a = strings.Title(a)
+				}
+				return a + " " + g.Name
+			})
+			c.Text = docName.ReplaceAllLiteralString(c.Text, g.Name)
+			c.Text = docType.ReplaceAllLiteralString(c.Text, spec)
+		}
+		if zero := fmt.Sprintf("The zero %s is empty and ready for use.", g.Name); !strings.Contains(d.Doc.Text(), zero) {
+			appendDoc(d.Doc, zero)
+		}
+	}
+}
+
+// article returns the indefinite article of the given name, picked by its first letter.
+// e.g. "an" for IntMap.
+func article(name string) string {
+	if name != "" && strings.ContainsRune("aeiouAEIOU", rune(name[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+// documentRange states in the doc comment of Range that f may call the methods of the map,
+// as the doc of sync.Map does in newer versions of Go. The semantics are the same in the
+// older versions, whose doc does not state it.
+func documentRange(f *ast.FuncDecl) {
+	if f.Doc != nil && !strings.Contains(f.Doc.Text(), "f itself may call") {
+		appendDoc(f.Doc, "Range does not block other methods on the receiver; even f itself may call any",
+			"method on m.")
 	}
 }
 
@@ -164,7 +1507,7 @@ func (g *Generator) Types() map[string]func(*ast.TypeSpec) {
 	return map[string]func(*ast.TypeSpec){
 		"Map": func(t *ast.TypeSpec) {
 			l := t.Type.(*ast.StructType).Fields.List[0]
-			l.Type = expr("sync.Mutex", l.Type.Pos())
+			l.Type = expr(g.mutex(), l.Type.Pos())
 			g.replaceKey(t.Type)
 		},
 		"readOnly": func(t *ast.TypeSpec) { g.replaceKey(t) },
@@ -179,11 +1522,11 @@ func (g *Generator) Funcs() map[string]func(*ast.FuncDecl) {
 		"Load": func(f *ast.FuncDecl) {
 			g.replaceKey(f.Type.Params)
 			g.replaceValue(f.Type.Results)
-			renameNil(f.Body, f.Type.Results.List[0].Names[0].Name)
+			g.returnZero(f.Body)
 		},
 		"load": func(f *ast.FuncDecl) {
 			g.replaceValue(f)
-			renameNil(f.Body, f.Type.Results.List[0].Names[0].Name)
+			g.returnZero(f.Body)
 		},
 		"Store": func(f *ast.FuncDecl) {
 			g.renameTuple(f.Type.Params)
@@ -195,14 +1538,15 @@ func (g *Generator) Funcs() map[string]func(*ast.FuncDecl) {
 		"LoadAndDelete": func(f *ast.FuncDecl) {
 			g.replaceKey(f.Type.Params)
 			g.replaceValue(f.Type.Results)
-			renameNil(f.Body, f.Type.Results.List[0].Names[0].Name)
+			g.returnZero(f.Body)
 		},
 		"tryLoadOrStore": func(f *ast.FuncDecl) {
 			g.replaceValue(f)
-			renameNil(f.Body, f.Type.Results.List[0].Names[0].Name)
+			g.returnZero(f.Body)
 		},
 		"Range": func(f *ast.FuncDecl) {
 			g.renameTuple(f.Type.Params.List[0].Type.(*ast.FuncType).Params)
+			documentRange(f)
 		},
 		"Delete":      func(f *ast.FuncDecl) { g.replaceKey(f) },
 		"newEntry":    func(f *ast.FuncDecl) { g.replaceValue(f) },
@@ -211,7 +1555,7 @@ func (g *Generator) Funcs() map[string]func(*ast.FuncDecl) {
 		"storeLocked": func(f *ast.FuncDecl) { g.replaceValue(f) },
 		"delete": func(f *ast.FuncDecl) {
 			g.replaceValue(f)
-			renameNil(f.Body, f.Type.Results.List[0].Names[0].Name)
+			g.returnZero(f.Body)
 		},
 		"missLocked":       nop,
 		"unexpungeLocked":  nop,
@@ -219,6 +1563,42 @@ func (g *Generator) Funcs() map[string]func(*ast.FuncDecl) {
 	}
 }
 
+// logf logs a mutation step to stderr in verbose mode.
+func (g *Generator) logf(format string, args ...interface{}) {
+	if g.Verbose {
+		fmt.Fprintf(g.stderr, "syncmap: "+format+"\n", args...)
+	}
+}
+
+// checkGeneric fails if the source declares generic types or functions, e.g. forks of
+// sync/map.go that use type parameters internally. They can not be specialized by
+// replacing the interface{} types.
+func (g *Generator) checkGeneric(f *ast.File, path string) {
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			generic := d.Type.TypeParams != nil
+			if d.Recv != nil {
+				t := d.Recv.List[0].Type
+				if s, ok := t.(*ast.StarExpr); ok {
+					t = s.X
+				}
+				switch t.(type) {
+				case *ast.IndexExpr, *ast.IndexListExpr:
+					generic = true
+				}
+			}
+			expect(!generic, "%s: generic function %s is not supported", path, d.Name.Name)
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				if s, ok := s.(*ast.TypeSpec); ok {
+					expect(s.TypeParams == nil, "%s: generic type %s is not supported", path, s.Name.Name)
+				}
+			}
+		}
+	}
+}
+
 // replaceKey replaces all `interface{}` occurrences in the given Node with the key node.
 func (g *Generator) replaceKey(n ast.Node) { replaceIface(n, g.key) }
 
@@ -253,7 +1633,10 @@ func rename(f *ast.File, oldnew map[string]string) {
 	astutil.Apply(f, func(c *astutil.Cursor) bool {
 		switch n := c.Node().(type) {
 		case *ast.Ident:
-			if name, ok := oldnew[n.Name]; ok {
+			// The identifiers of the key and value types are parsed separately from the file,
+			// and therefore unresolved. They are left as is, even if the user named a type
+			// like one of the sync/map.go internals (e.g. entry).
+			if name, ok := oldnew[n.Name]; ok && n.Obj != nil {
 				n.Name = name
 				n.Obj.Name = name
 			}
@@ -266,17 +1649,6 @@ func rename(f *ast.File, oldnew map[string]string) {
 	}, nil)
 }
 
-func renameNil(n ast.Node, name string) {
-	astutil.Apply(n, func(c *astutil.Cursor) bool {
-		if _, ok := c.Parent().(*ast.ReturnStmt); ok {
-			if i, ok := c.Node().(*ast.Ident); ok && i.Name == new(types.Nil).String() {
-				i.Name = name
-			}
-		}
-		return true
-	}, nil)
-}
-
 func expr(s string, pos token.Pos) ast.Expr {
 	exp, err := parser.ParseExpr(s)
 	check(err, "parse expr: %q", s)
@@ -298,21 +1670,34 @@ func setPos(n ast.Node, p token.Pos) {
 	case *ast.FieldList:
 		n.Closing = p
 		n.Opening = p
-		if len(n.List) > 0 {
-			setPos(n.List[0], p)
+		for _, f := range n.List {
+			setPos(f, p)
 		}
 	case *ast.Field:
 		setPos(n.Type, p)
-		if len(n.Names) > 0 {
-			setPos(n.Names[0], p)
+		for _, id := range n.Names {
+			setPos(id, p)
 		}
+		setPos(n.Tag, p)
 	case *ast.FuncType:
 		n.Func = p
 		setPos(n.Params, p)
 		setPos(n.Results, p)
 	case *ast.ArrayType:
 		n.Lbrack = p
+		if n.Len != nil {
+			setPos(n.Len, p)
+		}
 		setPos(n.Elt, p)
+	case *ast.BasicLit:
+		n.ValuePos = p
+	case *ast.BinaryExpr:
+		n.OpPos = p
+		setPos(n.X, p)
+		setPos(n.Y, p)
+	case *ast.UnaryExpr:
+		n.OpPos = p
+		setPos(n.X, p)
 	case *ast.StructType:
 		n.Struct = p
 		setPos(n.Fields, p)
@@ -329,8 +1714,20 @@ func setPos(n ast.Node, p token.Pos) {
 		setPos(n.Value, p)
 	case *ast.ParenExpr:
 		setPos(n.X, p)
+	case *ast.IndexExpr:
+		n.Lbrack = p
+		n.Rbrack = p
+		setPos(n.X, p)
+		setPos(n.Index, p)
+	case *ast.IndexListExpr:
+		n.Lbrack = p
+		n.Rbrack = p
+		setPos(n.X, p)
+		for _, x := range n.Indices {
+			setPos(x, p)
+		}
 	default:
-		panic(fmt.Sprintf("unknown type: %v", n))
+		expect(false, "unsupported type expression: %T", n)
 	}
 }
 
@@ -355,11 +1752,13 @@ type genError struct {
 
 func (p genError) Error() string { return fmt.Sprintf("syncmap: %s", p.msg) }
 
+// catch recovers from a panic and stores it in err. Unexpected panics (i.e. bugs in
+// the generator) are reported as internal errors instead of crashing the caller.
 func catch(err *error) {
 	if e := recover(); e != nil {
 		gerr, ok := e.(genError)
 		if !ok {
-			panic(e)
+			gerr = genError{fmt.Sprintf("internal error: %v", e)}
 		}
 		*err = gerr
 	}
@@ -0,0 +1,2929 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/tools/imports"
+)
+
+// generate runs the generator with the given command-line arguments in a temporary
+// directory, and returns the generated source.
+func generate(t *testing.T, args ...string) string {
+	t.Helper()
+	src, err := run(t.TempDir(), args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// run runs the generator with the given command-line arguments against the copy
+// of sync/map.go in testdata/testdata, and returns the source generated in dir.
+func run(dir string, args ...string) (string, error) {
+	c, err := ParseConfig(flag.NewFlagSet("syncmap", flag.ContinueOnError), args)
+	if err != nil {
+		return "", err
+	}
+	c.Out = filepath.Join(dir, "map.go")
+	if err := runConfig(c); err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(c.Out)
+	return string(b), err
+}
+
+// runConfig runs the generator with the given config against the copy of sync/map.go
+// in testdata/testdata.
+func runConfig(c Config) error {
+	g, err := NewGenerator(c)
+	if err != nil {
+		return err
+	}
+	g.goroot = "testdata/testdata"
+	if err := g.Mutate(); err != nil {
+		return err
+	}
+	return g.Gen()
+}
+
+func TestHelpersAliases(t *testing.T) {
+	src := generate(t, "-name", "RuneMap", "-helpers", "keys,values,string", "map[rune]byte")
+	for _, s := range []string{
+		"func (m *RuneMap) Keys() []rune {",
+		"func (m *RuneMap) Values() []byte {",
+		"s := make(map[rune]byte)",
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated code should contain: %s", s)
+		}
+	}
+	for _, s := range []string{"int32", "uint8"} {
+		if strings.Contains(src, s) {
+			t.Errorf("generated code should not contain: %s", s)
+		}
+	}
+}
+
+func TestNoHeader(t *testing.T) {
+	src := generate(t, "-no-header", "map[int]int")
+	if !strings.HasPrefix(src, "// Copyright 2016 The Go Authors.") {
+		t.Fatalf("generated code should start with the sync/map.go header:\n%s", src[:100])
+	}
+	src = generate(t, "-no-header", "-header", "Copyright Acme Inc.", "map[int]int")
+	if !strings.HasPrefix(src, "// Copyright Acme Inc.\n\n// Copyright 2016 The Go Authors.") {
+		t.Fatalf("generated code should start with the custom header:\n%s", src[:100])
+	}
+	// The generated test files have the same header.
+	dir := t.TempDir()
+	if _, err := run(dir, "-no-header", "-header", "Copyright Acme Inc.", "-assert-size", "40", "-assert-zero", "map[int]int"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"map_size_test.go", "map_zero_test.go"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(b), "// Copyright Acme Inc.\n\npackage main\n") {
+			t.Errorf("%s should start with the custom header:\n%s", name, b)
+		}
+	}
+}
+
+func TestCatch(t *testing.T) {
+	tests := []struct {
+		panic interface{}
+		err   string
+	}{
+		{genError{"invalid argument"}, "syncmap: invalid argument"},
+		{"unknown type", "syncmap: internal error: unknown type"},
+		{fmt.Errorf("index out of range"), "syncmap: internal error: index out of range"},
+	}
+	for _, tt := range tests {
+		err := func() (err error) {
+			defer catch(&err)
+			panic(tt.panic)
+		}()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error: %v, want %s", err, tt.err)
+		}
+	}
+}
+
+func TestHelpersEncoding(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "event.go"), []byte(`package main
+
+type (
+	Event   struct{ Name string }
+	Handler func(Event)
+	Events  (chan Event)
+	Level   int
+	Point   struct{ X, Y int }
+	Version struct{ Major, Minor int }
+	Tag     struct{ Name string }
+)
+
+func (v Version) MarshalText() ([]byte, error) { return nil, nil }
+
+func (t *Tag) MarshalText() ([]byte, error) { return nil, nil }
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-helpers", "len,keys", "map[string]Handler"}, ""},
+		{[]string{"-helpers", "json", "map[string]Event"}, ""},
+		{[]string{"-helpers", "json", "map[string]Handler"}, "syncmap: json helper: value type Handler is a function and can not be encoded"},
+		{[]string{"-helpers", "gob", "map[string]func()"}, "syncmap: gob helper: value type func() is a function and can not be encoded"},
+		{[]string{"-helpers", "json", "map[Events]int"}, "syncmap: json helper: key type Events is a channel and can not be encoded"},
+		{[]string{"-helpers", "json", "map[Level]int"}, ""},
+		{[]string{"-helpers", "json", "map[Version]int"}, ""},
+		{[]string{"-helpers", "json", "map[*Tag]int"}, ""},
+		{[]string{"-helpers", "json", "map[u.ID]int"}, ""},
+		{[]string{"-helpers", "json", "-generic", "map[K]V"}, ""},
+		{[]string{"-helpers", "json", "map[Point]int"}, "syncmap: json helper: key type Point can not be the key of a JSON object. " +
+			"expected a string, an integer, or a type that implements encoding.TextMarshaler"},
+		{[]string{"-helpers", "json", "map[Tag]int"}, "syncmap: json helper: key type Tag can not be the key of a JSON object. " +
+			"expected a string, an integer, or a type that implements encoding.TextMarshaler"},
+		{[]string{"-helpers", "json", "map[float64]int"}, "syncmap: json helper: key type float64 can not be the key of a JSON object. " +
+			"expected a string, an integer, or a type that implements encoding.TextMarshaler"},
+		{[]string{"-helpers", "jsonstream", "map[Version]Event"}, ""},
+		{[]string{"-helpers", "jsonstream", "map[Point]int"}, "syncmap: jsonstream helper: key type Point can not be the key of a JSON object. " +
+			"expected a string, an integer, or a type that implements encoding.TextMarshaler"},
+		{[]string{"-helpers", "gob", "map[Point]int"}, ""},
+		{[]string{"-helpers", "binary", "map[Level]bool"}, ""},
+		{[]string{"-helpers", "binary", "-set", "string"}, ""},
+		{[]string{"-helpers", "binary", "map[string]Handler"}, "syncmap: binary helper: value type Handler can not be encoded. " +
+			"expected a number, a boolean, a string, or a type that implements encoding.BinaryMarshaler"},
+		{[]string{"-helpers", "binary", "-generic", "map[K]V"}, "syncmap: binary helper: key type K is a type parameter and its encoding is unknown"},
+		{[]string{"-helpers", "loadnonzero", "map[string]Event"}, ""},
+		{[]string{"-helpers", "loadnonzero", "map[string]Handler"}, "syncmap: loadnonzero helper: value type Handler is not comparable"},
+		{[]string{"-helpers", "loadnonzero", "map[string][]int"}, "syncmap: loadnonzero helper: value type []int is not comparable"},
+		{[]string{"-helpers", "loadptr", "map[string]Event"}, ""},
+		{[]string{"-helpers", "loadptr", "map[string]*Event"}, "syncmap: loadptr helper: value type *Event is a pointer. use Load instead"},
+	}
+	for _, tt := range tests {
+		_, err := run(dir, tt.args...)
+		if tt.err == "" && err != nil {
+			t.Errorf("unexpected error for %v: %v", tt.args, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("unexpected error for %v: %v, want %s", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "cache.go"), []byte(`package main
+
+type readOnlyCache struct{}
+
+func newEntryStore() {}
+
+var zeroValueLookup int
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{"Entry", ""},
+		{"Cache", "syncmap: identifier readOnlyCache is already declared in " + filepath.Join(dir, "cache.go") + ". use a different -name"},
+		{"Store", "syncmap: identifier newEntryStore is already declared in " + filepath.Join(dir, "cache.go") + ". use a different -name"},
+		{"readOnlyCache", "syncmap: identifier readOnlyCache is already declared in " + filepath.Join(dir, "cache.go") + ". use a different -name"},
+		{"Lookup", "syncmap: identifier zeroValueLookup is already declared in " + filepath.Join(dir, "cache.go") + ". use a different -name"},
+	}
+	for _, tt := range tests {
+		_, err := run(dir, "-name", tt.name, "map[int]int")
+		if tt.err == "" && err != nil {
+			t.Errorf("unexpected error for %s: %v", tt.name, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("unexpected error for %s: %v, want %s", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestEmbeddedSource(t *testing.T) {
+	want := generate(t, "-name", "IntMap", "map[int]int")
+	g, err := NewGenerator(Config{Name: "IntMap", Pkg: "main", Spec: "map[int]int", Out: filepath.Join(t.TempDir(), "map.go")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.goroot = t.TempDir()
+	if err := g.Mutate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Gen(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(g.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The command is not recorded when the config is not parsed from arguments.
+	want = strings.Replace(want, "// syncmap -name IntMap map[int]int\n", "", 1)
+	if string(b) != want {
+		t.Fatalf("unexpected generated code using the embedded source:\n%s", b)
+	}
+}
+
+func TestTypeImports(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "session.go"), []byte(`package main
+
+import (
+	"example.com/session/v2"
+	u "example.com/user"
+)
+
+var (
+	_ *u.ID
+	_ *session.Token
+)
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := run(dir, "map[*u.ID]*session.Token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"\t\"example.com/session/v2\"\n",
+		"\tu \"example.com/user\"\n",
+		"func (m *Map) Load(key *u.ID) (value *session.Token, ok bool) {",
+		"func (m *Map) Store(key *u.ID, value *session.Token) {",
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated code should contain: %s", s)
+		}
+	}
+}
+
+func TestNestedMaps(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		// The module of -packages.
+		"go.mod":    "module example.com/region\n",
+		"region.go": "package main\n\ntype Region string\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := ParseConfig(flag.NewFlagSet("syncmap", flag.ContinueOnError), []string{"-name", "ZoneMap", "map[string]int"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Out = filepath.Join(dir, "zonemap.go")
+	if err := runConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	imports := func(src string) (paths []string) {
+		f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range f.Imports {
+			paths = append(paths, s.Path.Value)
+		}
+		return paths
+	}
+	want := imports(generate(t, "map[string]int"))
+	for _, args := range [][]string{
+		{"-name", "RegionMap", "map[Region]*ZoneMap"},
+		{"-name", "RegionMap", "-helpers", "tomap,storeall", "map[Region]*ZoneMap"},
+		{"-name", "RegionMap", "-packages", "map[Region]*ZoneMap"},
+	} {
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := imports(src); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: unexpected imports for a local type: %v, want %v", args, got, want)
+		}
+		for _, s := range []string{
+			"func (m *RegionMap) Load(key Region) (value *ZoneMap, ok bool) {",
+			"func (m *RegionMap) Store(key Region, value *ZoneMap) {",
+		} {
+			if !strings.Contains(src, s) {
+				t.Errorf("%v: generated code should contain: %s", args, s)
+			}
+		}
+		typeCheck(t, dir, "map.go", "zonemap.go", "region.go")
+	}
+}
+
+func TestExternalTestPackage(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "url_test.go"), []byte(`package main_test
+
+import u "net/url"
+
+type ID int
+
+var _ *u.URL
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{Name: "URLMap", Pkg: "main_test", Spec: "map[ID]*u.URL", Ext: true, Helpers: []string{"keys"}, Size: 40, Zero: true}
+	g, err := NewGenerator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Out != "urlmap_test.go" {
+		t.Errorf("unexpected output file: %s, want urlmap_test.go", g.Out)
+	}
+	c.Out = filepath.Join(dir, "urlmap_test.go")
+	if err := runConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"url_test.go", "urlmap_test.go", "urlmap_ext_test.go", "urlmap_size_test.go", "urlmap_zero_test.go"}
+	for _, name := range names[1:] {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), "\npackage main_test\n") {
+			t.Errorf("%s should be in the external test package:\n%s", name, b)
+		}
+	}
+	typeCheck(t, dir, names...)
+	for _, c := range []Config{
+		{Name: "Map", Pkg: "main_test", Spec: "map[int]int", Out: filepath.Join(dir, "map.go")},
+		{Name: "Map", Pkg: "main-test", Spec: "map[int]int"},
+	} {
+		if _, err := NewGenerator(c); err == nil {
+			t.Errorf("expected an error for package %s and output file %q", c.Pkg, c.Out)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "testdata/testdata/src/sync/map.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decls := 0
+	for _, d := range f.Decls {
+		if d, ok := d.(*ast.GenDecl); !ok || d.Tok != token.IMPORT {
+			decls++
+		}
+	}
+	dir := t.TempDir()
+	c := Config{Name: "Map", Pkg: "main", Spec: "map[string]int", Helpers: []string{"keys", "string"}, Ext: true, Size: 40, Source: "testdata/testdata/src/sync/map.go", Out: filepath.Join(dir, "map.go")}
+	s, err := GenerateStats(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := 0
+	for _, name := range []string{"map.go", "map_ext.go", "map_size_test.go"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += len(b)
+	}
+	// The sync import is added to the main file, and the fmt import to the extension file.
+	if s.Decls != decls || s.Imports != 2 || s.Files != 3 || s.Bytes != size {
+		t.Errorf("unexpected stats: %+v, want %d decls, 2 imports, 3 files and %d bytes", s, decls, size)
+	}
+	if s.Mutate <= 0 || s.Format <= 0 || s.Gen < s.Format {
+		t.Errorf("unexpected durations: %+v", s)
+	}
+	// Maps generated from a cached template handle no declarations.
+	c.Fast = true
+	g, err := NewGenerator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := g.Reset(c); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Mutate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Gen(); err != nil {
+			t.Fatal(err)
+		}
+		if fs := g.Stats(); fs.Decls != 0 || fs.Imports != s.Imports || fs.Files != s.Files || fs.Bytes != s.Bytes {
+			t.Errorf("unexpected stats of a cached template: %+v, want %+v", fs, s)
+		}
+	}
+}
+
+func TestTypeInfo(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "session.go"), []byte(`package main
+
+import (
+	"example.com/session/v2"
+	u "example.com/user"
+)
+
+var (
+	_ *u.ID
+	_ *session.Token
+)
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		c          Config
+		key, value string
+		imports    []string
+	}{
+		{Config{Spec: "map[*u.ID][]session.Token"}, "*u.ID", "[]session.Token", []string{`u "example.com/user"`, `"example.com/session/v2"`}},
+		{Config{Spec: "map[string]*http.Request"}, "string", "*http.Request", nil},
+		{Config{Spec: "u.ID", Set: true}, "u.ID", "struct{}", []string{`u "example.com/user"`}},
+	}
+	for _, tt := range tests {
+		tt.c.Name, tt.c.Pkg, tt.c.Out = "Map", "main", filepath.Join(dir, "map.go")
+		g, err := NewGenerator(tt.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := types.ExprString(g.KeyType()); s != tt.key {
+			t.Errorf("unexpected key type of %s: %s, want %s", tt.c.Spec, s, tt.key)
+		}
+		if s := types.ExprString(g.ValueType()); s != tt.value {
+			t.Errorf("unexpected value type of %s: %s, want %s", tt.c.Spec, s, tt.value)
+		}
+		if imports := g.TypeImports(); !reflect.DeepEqual(imports, tt.imports) {
+			t.Errorf("unexpected imports of %s: %q, want %q", tt.c.Spec, imports, tt.imports)
+		}
+	}
+}
+
+func TestPackages(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"go.mod":           "module example.com/app\n",
+		"admin/user/id.go": "package user\n\ntype ID int\n\ntype Role string\n",
+		"guest/user/id.go": "package user\n\ntype Token string\n\ntype Role string\n",
+		"admin.go":         "package main\n\nimport \"example.com/app/admin/user\"\n\nvar _ user.Role\n",
+		"guest.go":         "package main\n\nimport \"example.com/app/guest/user\"\n\nvar _ user.Role\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		spec    string
+		imports []string
+		err     string
+	}{
+		{"map[user.ID]int", []string{`"example.com/app/admin/user"`}, ""},
+		{"map[string]user.Token", []string{`"example.com/app/guest/user"`}, ""},
+		{"map[user.ID]user.Token", []string{`"example.com/app/admin/user"`, `"example.com/app/guest/user"`}, ""},
+		{"map[string]*http.Request", nil, ""},
+		{"map[user.Role]int", nil, "syncmap: -packages: type user.Role is ambiguous. it is declared in: example.com/app/admin/user, example.com/app/guest/user"},
+		{"map[user.Name]int", nil, "syncmap: -packages: type user.Name is not declared in the packages imported as user: example.com/app/admin/user, example.com/app/guest/user"},
+	}
+	for _, tt := range tests {
+		g, err := NewGenerator(Config{Name: "Map", Pkg: "main", Spec: tt.spec, Out: filepath.Join(dir, "map.go"), Packages: true})
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("unexpected error for %s: %v, want %s", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imports := g.TypeImports(); !reflect.DeepEqual(imports, tt.imports) {
+			t.Errorf("unexpected imports of %s: %q, want %q", tt.spec, imports, tt.imports)
+		}
+	}
+}
+
+func TestLockValues(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := run(dir, "-name", "UserMap", "map[string]int"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "map.go"), filepath.Join(dir, "usermap.go")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		spec, warning string
+	}{
+		{"map[string]UserMap", "syncmap: warning: value type UserMap contains a sync.Mutex, and is copied by the map methods. go vet reports the copies. use a pointer type instead, e.g. *UserMap\n"},
+		{"map[string][2]sync.Map", "syncmap: warning: value type [2]sync.Map contains a sync.Map, and is copied by the map methods. go vet reports the copies. use a pointer type instead, e.g. *[2]sync.Map\n"},
+		{"map[string]*UserMap", ""},
+		{"map[string][]sync.Mutex", ""},
+	}
+	for _, tt := range tests {
+		log := bytes.NewBuffer(nil)
+		g := &Generator{goroot: "testdata/testdata", stderr: log}
+		err := g.Reset(Config{Name: "Map", Pkg: "main", Spec: tt.spec, Out: filepath.Join(dir, "map.go")})
+		if err == nil {
+			err = g.Mutate()
+		}
+		if err == nil {
+			err = g.Gen()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if log.String() != tt.warning {
+			t.Errorf("unexpected warning for %s: %q, want %q", tt.spec, log, tt.warning)
+		}
+		// Values that contain locks are copied, but the generated code still compiles.
+		typeCheck(t, dir, "map.go", "usermap.go")
+	}
+}
+
+func TestTags(t *testing.T) {
+	dir := t.TempDir()
+	src, err := run(dir, "-tags", "debug || test", "-helpers", "len,string", "-assert-size", "40", "map[int]int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"func (m *Map) Len() int {", "\"fmt\""} {
+		if strings.Contains(src, s) {
+			t.Errorf("generated code should not contain: %s", s)
+		}
+	}
+	for _, name := range []string{"map_ext.go", "map_size_test.go"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), "//go:build debug || test\n// +build debug test\n\npackage main\n") {
+			t.Errorf("%s should be guarded by the build constraint:\n%s", name, b)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "map_ext.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"import \"fmt\"", "func (m *Map) Len() int {", "func (m *Map) String() string {"} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("extension file should contain: %s", s)
+		}
+	}
+	if _, err := run(dir, "-tags", "debug ||", "map[int]int"); err == nil {
+		t.Error("expected an error for an invalid build constraint")
+	}
+	if _, err := run(dir, "-tags", "debug", "-metrics", "map[int]int"); err == nil || err.Error() != "syncmap: -metrics can not be used with -tags, "+
+		"as the counters of the metrics are part of the map and can not be guarded by the build constraint" {
+		t.Errorf("unexpected error for -metrics with -tags: %v", err)
+	}
+}
+
+func TestAssertZero(t *testing.T) {
+	tests := [][]string{
+		{"map[string]int"},
+		{"-valueinline", "map[string]int"},
+		{"-simple", "map[string]int"},
+		{"-readonly-after-init", "map[string]int"},
+		{"-set", "string"},
+		{"map[string]struct{ Name, Role string; Age int }"},
+	}
+	for _, args := range tests {
+		dir := t.TempDir()
+		src, err := run(dir, append([]string{"-name", "UserMap", "-assert-zero"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(src, "\n// The zero UserMap is empty and ready for use.") {
+			t.Errorf("%v: the doc of UserMap should state that its zero value is ready for use", args)
+		}
+		if strings.Contains(src, "map[interface{}]interface{}") {
+			t.Errorf("%v: the doc of UserMap should not refer to the types of sync.Map", args)
+		}
+		typeCheck(t, dir, "map.go", "map_zero_test.go")
+	}
+}
+
+func TestIter(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "pair.go"), []byte(`package main
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := run(dir, "-iter", "map[string]Pair[int, string]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"import (\n\t\"iter\"\n",
+		"func (m *Map) Load(key string) (value Pair[int, string], ok bool) {",
+		"func (m *Map) All() iter.Seq2[string, Pair[int, string]] {",
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated code should contain: %s", s)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	fs := flag.NewFlagSet("syncmap", flag.ContinueOnError)
+	b := bytes.NewBuffer(nil)
+	fs.SetOutput(b)
+	if _, err := ParseConfig(fs, []string{"-h"}); err == nil {
+		t.Fatal("expected an error for -h")
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Usage == "" {
+			t.Errorf("flag -%s has no usage", f.Name)
+		}
+		if !strings.Contains(b.String(), "  -"+f.Name) {
+			t.Errorf("usage should describe flag -%s", f.Name)
+		}
+	})
+	if !strings.HasPrefix(b.String(), usage) || !strings.HasSuffix(b.String(), commands) {
+		t.Errorf("unexpected usage:\n%s", b)
+	}
+}
+
+func TestStdin(t *testing.T) {
+	defer func(r io.Reader) { stdin = r }(stdin)
+	tests := []struct {
+		args  []string
+		input string
+		spec  string
+		err   bool
+	}{
+		{args: []string{"-stdin", "-name", "stdin"}, input: "map[string]struct{ Name, Role string }\n", spec: "map[string]struct{ Name, Role string }"},
+		{args: []string{"--stdin=true", "-set"}, input: " string ", spec: "string"},
+		{args: []string{"-stdin", "map[int]int"}, input: "map[int]int", err: true},
+		{args: []string{"-stdin"}, input: "\n", err: true},
+	}
+	for _, tt := range tests {
+		stdin = strings.NewReader(tt.input)
+		c, err := ParseConfig(flag.NewFlagSet("syncmap", flag.ContinueOnError), tt.args)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%v: expected an error for input %q", tt.args, tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Spec != tt.spec {
+			t.Errorf("%v: unexpected spec: %q, want %q", tt.args, c.Spec, tt.spec)
+		}
+		// The recorded arguments regenerate the file without stdin.
+		stdin = strings.NewReader("")
+		r, err := ParseConfig(flag.NewFlagSet("syncmap", flag.ContinueOnError), c.Args)
+		if err != nil || r.Spec != tt.spec || r.Name != c.Name || r.Set != c.Set {
+			t.Errorf("%v: unexpected config for the recorded arguments %q: %+v, %v", tt.args, c.Args, r, err)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	src := generate(t, "-set", "-metrics", "-helpers", "keys,json", "string")
+	for _, s := range []string{
+		"func (m *Map) loadOrStore(key string, value struct{}) (actual struct{}, loaded bool) {",
+		"func (m *Map) Range(f func(key string) bool) {",
+		"func (m *Map) Keys() []string {\n\tvar keys []string\n\tm.rangeEntries(",
+		"\t\tm.store(key, value)\n",
+		"func (m *Map) Stats() MapStats {",
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated code should contain: %s", s)
+		}
+	}
+	for _, s := range []string{"func (m *Map) Load(", "func (m *Map) Store(", "m.LoadAndDelete("} {
+		if strings.Contains(src, s) {
+			t.Errorf("generated code should not contain: %s", s)
+		}
+	}
+	if _, err := run(t.TempDir(), "-set", "-helpers", "len", "string"); err == nil || err.Error() != "syncmap: len helper: sets already have a Len method" {
+		t.Errorf("unexpected error for the len helper: %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	dir := t.TempDir()
+	var calls int
+	c := Config{Name: "Map", Pkg: "main", Spec: "map[int]int", Size: 40, Out: filepath.Join(dir, "map.go")}
+	c.Format = func(src []byte) ([]byte, error) {
+		calls++
+		src, err := imports.Process("", src, nil)
+		return append([]byte("// Formatted.\n"), src...), err
+	}
+	g, err := NewGenerator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.goroot = "testdata/testdata"
+	if err := g.Mutate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Gen(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("unexpected number of calls to Format: %d, want 2", calls)
+	}
+	for _, name := range []string{"map.go", "map_size_test.go"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(b), "// Formatted.\n") {
+			t.Errorf("%s should be formatted by the custom formatter:\n%s", name, b[:100])
+		}
+	}
+	g.Format = func([]byte) ([]byte, error) { return nil, fmt.Errorf("unsupported syntax") }
+	if err := g.Gen(); err == nil || err.Error() != "syncmap: formatting file: "+c.Out+": unsupported syntax" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPostMutate(t *testing.T) {
+	dir := t.TempDir()
+	var calls int
+	c := Config{Name: "Map", Pkg: "main", Spec: "map[string]int", Source: "testdata/testdata/src/sync/map.go", Fast: true, Out: filepath.Join(dir, "map.go")}
+	c.PostMutate = func(f *ast.File, fset *token.FileSet) error {
+		calls++
+		if f.Name.Name != "main" || fset.File(f.Pos()) == nil {
+			t.Errorf("unexpected file of the hook: %s", f.Name.Name)
+		}
+		// A declaration without positions, that uses a standard package.
+		d, err := parser.ParseFile(token.NewFileSet(), "", `package p
+
+func (m *Map) Has(key string) bool {
+	_, ok := m.Load(key)
+	return ok && atomic.LoadInt32(new(int32)) == 0
+}`, 0)
+		if err != nil {
+			return err
+		}
+		f.Decls = append(f.Decls, d.Decls...)
+		return nil
+	}
+	if err := Generate(c); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("unexpected number of calls to PostMutate: %d, want 1", calls)
+	}
+	b, err := ioutil.ReadFile(c.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "func (m *Map) Has(key string) bool {") {
+		t.Errorf("generated code should contain the declaration of the hook:\n%s", b)
+	}
+	typeCheck(t, dir, "map.go")
+	c.PostMutate = func(*ast.File, *token.FileSet) error { return fmt.Errorf("unsupported node") }
+	if err := Generate(c); err == nil || err.Error() != "syncmap: post-mutate hook: unsupported node" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStdImports(t *testing.T) {
+	for _, args := range [][]string{
+		{"map[int]int"},
+		{"-valueinline", "map[int]int"},
+		{"-slab", "-metrics", "map[int]int"},
+		{"-simple", "-counter", "map[int]int"},
+		{"-readonly-after-init", "map[int]int"},
+		{"-wait", "-compute", "-ordered", "map[int]int"},
+		{"-generic", "-helpers", "keys,gob", "map[K]V"},
+	} {
+		dir := t.TempDir()
+		c, err := ParseConfig(flag.NewFlagSet("syncmap", flag.ContinueOnError), append([]string{"-source", "testdata/testdata/src/sync/map.go"}, args...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Out = filepath.Join(dir, "map.go")
+		// The imports are not resolved by the formatter.
+		c.Format = func(src []byte) ([]byte, error) { return src, nil }
+		if err := Generate(c); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		typeCheck(t, dir, "map.go")
+	}
+}
+
+func TestQualifiers(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "types.go"), []byte(`package main
+
+import . "time"
+
+type ID int
+
+var _ Duration
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for spec, want := range map[string]string{
+		"map[string][a.b.C]int":          "syncmap: value type [a.b.C]int: a.b.C is not a qualified identifier. expected a package name before the dot, e.g. pkg.Type",
+		"map[string]_.T":                 "syncmap: value type _.T: the blank identifier can not qualify T. expected a package name",
+		"map[string]*string.Builder":     "syncmap: value type *string.Builder: string is a predeclared identifier, not a package name",
+		"map[ID.String]int":              "syncmap: key type ID.String: ID is declared in the output package, and is not a package name",
+		"map[string]struct{ N user.id }": "syncmap: value type struct{ N user.id }: user.id is not exported by package user",
+		"map[ID]Duration":                `syncmap: value type Duration: Duration is not declared in the output package, and may be declared by its dot import of "time", which is not supported. use a qualified type instead`,
+		"map[ID]func(d Duration) error":  `syncmap: value type func(d Duration) error: Duration is not declared in the output package, and may be declared by its dot import of "time", which is not supported. use a qualified type instead`,
+	} {
+		if _, err := run(dir, spec); err == nil || err.Error() != want {
+			t.Errorf("%s: unexpected error: %v, want %s", spec, err, want)
+		}
+	}
+	if _, err := run(dir, "map[ID]struct{ Name string; At time.Time }"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompositeKeys(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "point.go"), []byte(`package main
+
+const N = 2
+
+type Point struct{ X, Y int }
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []string{
+		"map[[4]uint16]int",
+		"map[[N]Point]int",
+		"map[[2 * N]int]int",
+		"map[[N + 1][2]byte]int",
+		"map[struct{ X, Y int }]int",
+		"map[struct{ Point; Z int }]int",
+		"map[struct{ *Point; Tags [2]string }]int",
+		"map[struct{ A struct{ B [3]bool } }][N]int",
+		"map[struct{ Name string `json:\"name\"` }]int",
+		"map[[1]struct{ Point }]struct{ Point }",
+	}
+	for _, spec := range tests {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := run(dir, spec); err != nil {
+				t.Fatal(err)
+			}
+			typeCheck(t, dir, "map.go", "point.go")
+		})
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "types.go"), []byte(`package main
+
+type (
+	entry    struct{ ID int }
+	readOnly string
+	expunged bool
+	newEntry []entry
+)
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args       []string
+		key, value string
+	}{
+		{[]string{"map[entry]string"}, "main.entry", "string"},
+		{[]string{"map[readOnly]newEntry"}, "main.readOnly", "main.newEntry"},
+		{[]string{"map[expunged]*entry"}, "main.expunged", "*main.entry"},
+		{[]string{"-valueinline", "map[entry]entry"}, "main.entry", "main.entry"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if _, err := run(dir, tt.args...); err != nil {
+				t.Fatal(err)
+			}
+			pkg := typeCheck(t, dir, "map.go", "types.go")
+			checkLoad(t, pkg, tt.key, tt.value)
+		})
+	}
+}
+
+func TestGenericTypes(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "types.go"), []byte(`package main
+
+type (
+	ID                   string
+	Key[T comparable]    struct{ V T }
+	List[T any]          []T
+	Result[T any, E any] struct {
+		Value T
+		Err   E
+	}
+)
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args       []string
+		key, value string
+	}{
+		{[]string{"map[Key[int]]List[int]"}, "main.Key[int]", "main.List[int]"},
+		{[]string{"map[ID]Result[string, error]"}, "main.ID", "main.Result[string, error]"},
+		{[]string{"map[Key[ID]]*Result[List[int], []error]"}, "main.Key[main.ID]", "*main.Result[main.List[int], []error]"},
+		{[]string{"-valueinline", "-helpers", "keys,values", "map[Key[string]]List[Key[string]]"}, "main.Key[string]", "main.List[main.Key[string]]"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			if _, err := run(dir, tt.args...); err != nil {
+				t.Fatal(err)
+			}
+			pkg := typeCheck(t, dir, "map.go", "types.go")
+			checkLoad(t, pkg, tt.key, tt.value)
+		})
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	tests := []struct {
+		args []string
+		use  string
+	}{
+		{[]string{"map[K]V"}, "var m Map[string, []int]; m.Store(\"a\", nil)"},
+		{[]string{"-valueinline", "-cas", "-value-constraint", "comparable", "map[K]V"}, "var m Map[int, string]; m.CompareAndSwap(1, \"a\", \"b\")"},
+		{[]string{"-slab", "-cas", "-clear", "-value-constraint", "comparable", "map[K]V"}, "var m Map[int, string]; m.CompareAndSwap(1, \"a\", \"b\"); m.Clear()"},
+		{[]string{"-simple", "-capacity", "-metrics", "map[Key]Value"}, "m := NewMapWithCapacity[string, error](1); _ = m.Stats()"},
+		{[]string{"-readonly-after-init", "-helpers", "len", "map[K]V"}, "var m Map[string, int]; m.Freeze(); _ = m.Len()"},
+		{[]string{"-set", "-clear", "K"}, "var s Map[string]; s.Add(\"a\"); s.Clear()"},
+		{[]string{"-ordered", "-compact", "-helpers", "keys,loadordefault,json", "-key-constraint", "~string | ~int", "map[K]V"}, "var m Map[string, int]; m.Compact(); _ = m.Keys()"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			dir := t.TempDir()
+			src, err := run(dir, append([]string{"-generic"}, tt.args...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(src, "type Map[") {
+				t.Errorf("Map should be generic:\n%s", src)
+			}
+			err = ioutil.WriteFile(filepath.Join(dir, "use.go"), []byte("package main\n\nfunc use() {\n\t"+tt.use+"\n}\n"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			typeCheck(t, dir, "map.go", "use.go")
+		})
+	}
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"map[string]V"}, "syncmap: -generic: key type string is not a type parameter name. expected map[K]V"},
+		{[]string{"map[K][]V"}, "syncmap: -generic: value type []V is not a type parameter name. expected map[K]V"},
+		{[]string{"map[K]K"}, "syncmap: -generic: value type K is not a type parameter name. expected map[K]V"},
+		{[]string{"-key-constraint", "any", "map[K]V"}, "syncmap: -generic: key constraint any is not comparable"},
+		{[]string{"-cas", "map[K]V"}, "syncmap: -cas: value type V is not comparable"},
+		{[]string{"-assert-zero", "map[K]V"}, "syncmap: -assert-zero can not be used with -generic"},
+		{[]string{"-value-constraint", "_.Stringer", "map[K]V"}, "syncmap: constraint type _.Stringer: the blank identifier can not qualify Stringer. expected a package name"},
+	} {
+		if _, err := run(t.TempDir(), append([]string{"-generic"}, tt.args...)...); err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error for %v: %v, want %s", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestGenericConstraint(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"types.go": "package main\n\nimport \"fmt\"\n\nvar _ fmt.Stringer\n",
+		"methods.tmpl": `
+// Strings returns the string representations of the values of the map.
+func (m {{.Recv}}) Strings() (s []string) {
+	m.{{.Range}}(func(_ {{.Key}}, v {{.Value}}) bool {
+		s = append(s, v.String())
+		return true
+	})
+	return s
+}
+`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := ParseConfig(flag.NewFlagSet("syncmap", flag.ContinueOnError), []string{"-source", "testdata/testdata/src/sync/map.go",
+		"-generic", "-vconstraint", "fmt.Stringer", "-methods", filepath.Join(dir, "methods.tmpl"), "map[K]V"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Out = filepath.Join(dir, "map.go")
+	// The import of the constraint is not resolved by the formatter.
+	c.Format = func(src []byte) ([]byte, error) { return src, nil }
+	if err := Generate(c); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(c.Out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "type Map[K comparable, V fmt.Stringer] struct {") {
+		t.Errorf("V should be constrained by fmt.Stringer:\n%s", b)
+	}
+	typeCheck(t, dir, "map.go", "types.go")
+}
+
+func TestDocComments(t *testing.T) {
+	var all []string
+	for _, h := range helpers {
+		all = append(all, h.name)
+	}
+	tests := [][]string{
+		{"-helpers", strings.Join(all, ","), "-cas", "-iter", "-chaniter", "-metrics", "-clear", "-capacity", "map[string]int"},
+		{"-valueinline", "-helpers", "len,keys,values", "map[string]int"},
+		{"-simple", "-helpers", "keys", "-metrics", "-clear", "-capacity", "map[string]int"},
+		{"-set", "-metrics", "-clear", "string"},
+	}
+	for _, args := range tests {
+		src := generate(t, args...)
+		f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range f.Decls {
+			var name string
+			var doc *ast.CommentGroup
+			switch d := d.(type) {
+			case *ast.FuncDecl:
+				name, doc = d.Name.Name, d.Doc
+			case *ast.GenDecl:
+				s, ok := d.Specs[0].(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+				name, doc = s.Name.Name, d.Doc
+			}
+			if !ast.IsExported(name) {
+				continue
+			}
+			if doc == nil || !strings.HasPrefix(doc.Text(), name+" ") {
+				t.Errorf("%v: %s should have a doc comment that starts with its name", args, name)
+			}
+			if name == "Range" && doc != nil && !strings.Contains(doc.Text(), "may call any") {
+				t.Errorf("%v: the doc of Range should state that f may call the map methods", args)
+			}
+		}
+	}
+}
+
+func TestDocArticle(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-name", "IntMap", "-metrics", "map[int]int"}, "use of an IntMap"},
+		{[]string{"-name", "IntMap", "-metrics", "map[int]int"}, "counters of an IntMap"},
+		{[]string{"-name", "IntMap", "-slab", "map[int]int"}, "values of an IntMap"},
+		{[]string{"-name", "ScoreMap", "map[string]int"}, "use of a ScoreMap"},
+	}
+	for _, tt := range tests {
+		src := generate(t, tt.args...)
+		if !strings.Contains(src, tt.want) {
+			t.Errorf("%v: expected the docs to contain %q", tt.args, tt.want)
+		}
+		if strings.Contains(src, "a IntMap") {
+			t.Errorf("%v: unexpected article in the docs: a IntMap", tt.args)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	dir := t.TempDir()
+	configs := []Config{
+		{Name: "IntMap", Pkg: "main", Spec: "map[int]int", Helpers: []string{"keys"}, Out: filepath.Join(dir, "intmap.go")},
+		{Name: "StringSet", Pkg: "main", Spec: "string", Set: true, Metrics: true, Out: filepath.Join(dir, "stringset.go")},
+	}
+	var want []string
+	for _, c := range configs {
+		g, err := NewGenerator(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.goroot = "testdata/testdata"
+		if err := g.Mutate(); err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Gen(); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(c.Out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, string(b))
+	}
+	g := &Generator{goroot: "testdata/testdata"}
+	for i, c := range append(configs, configs...) {
+		if err := g.Reset(c); err != nil {
+			t.Fatal(err)
+		}
+		// Mutate can be called more than once.
+		for j := 0; j < 2; j++ {
+			if err := g.Mutate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := g.Gen(); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(c.Out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want[i%len(configs)] {
+			t.Errorf("unexpected code generated by a reused generator for %s:\n%s", c.Name, b)
+		}
+	}
+}
+
+func TestAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := run(dir, "-assert-size", "40", "map[int]int"); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+		if f.Mode().Perm() != 0644 {
+			t.Errorf("unexpected mode of %s: %v", f.Name(), f.Mode())
+		}
+	}
+	if want := []string{"map.go", "map_size_test.go"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("unexpected files: %v, want %v", names, want)
+	}
+}
+
+func TestExt(t *testing.T) {
+	dir := t.TempDir()
+	src, err := run(dir, "-ext", "-helpers", "keys,json", "-chaniter", "map[string]int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"func (m *Map) Keys()", "func (m *Map) Iter()", "encoding/json"} {
+		if strings.Contains(src, s) {
+			t.Errorf("generated code should not contain: %s", s)
+		}
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "map_ext.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), generatedMarker+"\n\npackage main\n\nimport \"encoding/json\"\n") {
+		t.Errorf("unexpected extension file header:\n%s", b)
+	}
+	for _, s := range []string{"func (m *Map) Keys() []string {", "func (m *Map) MarshalJSON() ([]byte, error) {", "func (m *Map) Iter() <-chan struct {"} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("extension file should contain: %s", s)
+		}
+	}
+}
+
+func TestUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := run(dir, "map[int]int"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "map.go")
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(dir, "map[int]int"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("unchanged file should not be rewritten. modified at: %v", info.ModTime())
+	}
+	if _, err := run(dir, "map[int]string"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if info.ModTime().Equal(mtime) {
+		t.Error("changed file should be rewritten")
+	}
+}
+
+func TestSimple(t *testing.T) {
+	src := generate(t, "-simple", "-set", "string")
+	for _, s := range []string{
+		"type Map struct {\n\tmu sync.Mutex\n\tm  map[string]struct{}\n}",
+		"func (m *Map) loadAndDelete(key string) (value struct{}, loaded bool) {",
+		"func (m *Map) delete(key string) {\n\tm.loadAndDelete(key)\n}",
+		"func (m *Map) Contains(key string) bool {",
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated code should contain: %s", s)
+		}
+	}
+	if strings.Contains(src, "entry") || strings.Contains(src, "unsafe") {
+		t.Error("generated code should not contain the internals of sync.Map")
+	}
+	if _, err := run(t.TempDir(), "-simple", "-valueinline", "map[int]int"); err == nil || err.Error() != "syncmap: -valueinline can not be used with -simple" {
+		t.Errorf("unexpected error for -simple -valueinline: %v", err)
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	src := generate(t, "-readonly-after-init", "-set", "string")
+	for _, s := range []string{
+		"func (m *Map) Freeze() {",
+		"func (m *Map) Contains(key string) bool {",
+	} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated code should contain: %s", s)
+		}
+	}
+	for _, flag := range []string{"-simple", "-valueinline", "-line", "-clear"} {
+		_, err := run(t.TempDir(), "-readonly-after-init", flag, "map[int]int")
+		if err == nil || !strings.HasSuffix(err.Error(), "can not be used with -readonly-after-init") && !strings.HasPrefix(err.Error(), "syncmap: -readonly-after-init can not be used with") {
+			t.Errorf("unexpected error for -readonly-after-init %s: %v", flag, err)
+		}
+	}
+}
+
+func TestSlab(t *testing.T) {
+	dir := t.TempDir()
+	src, err := run(dir, "-slab", "-clear", "-capacity", "-metrics", "map[string]int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"\tslab   *slabMap\n", "m.dirty[key] = newEntryMap(m.slabLocked(), value)", "\tm.slab = nil\n", "const expungedMap = -1"} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated code should contain: %s", s)
+		}
+	}
+	typeCheck(t, dir, "map.go")
+	for flag, spec := range map[string]string{"-simple": "map[string]int", "-readonly-after-init": "map[string]int", "-valueinline": "map[string]int", "-set": "string"} {
+		want := "syncmap: -slab can not be used with " + flag
+		if _, err := run(t.TempDir(), flag, "-slab", spec); err == nil || err.Error() != want {
+			t.Errorf("unexpected error for %s: %v, want %s", flag, err, want)
+		}
+	}
+	for spec, warning := range map[string]string{
+		"map[string]int":               "",
+		"map[string][4]float64":        "",
+		"map[string]*int":              "syncmap: warning: value type *int has pointers, and the slab of -slab is scanned by the garbage collector. -slab is meant for values without pointers\n",
+		"map[string]struct{ A []int }": "syncmap: warning: value type struct{ A []int } has pointers ([]int), and the slab of -slab is scanned by the garbage collector. -slab is meant for values without pointers\n",
+	} {
+		log := bytes.NewBuffer(nil)
+		g := &Generator{goroot: "testdata/testdata", stderr: log}
+		if err := g.Reset(Config{Name: "Map", Pkg: "main", Spec: spec, Out: filepath.Join(dir, "map.go"), Slab: true}); err != nil {
+			t.Fatal(err)
+		}
+		if log.String() != warning {
+			t.Errorf("unexpected warning for %s: %q, want %q", spec, log, warning)
+		}
+	}
+}
+
+func TestKeyInline(t *testing.T) {
+	for _, args := range [][]string{
+		{"-keyinline", "map[string]int"},
+		{"-keyinline", "-slab", "-cas", "map[[2]string]int"},
+		{"-keyinline", "-valueinline", "-set", "int"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"\tkey ", "m.dirty[key] = newEntryMap(key, ", "\tfor _, e := range read.m {", "f(e.key, v)"} {
+			if !strings.Contains(src, s) {
+				t.Errorf("%v: generated code should contain: %s", args, s)
+			}
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	for _, flag := range []string{"-simple", "-readonly-after-init"} {
+		want := "syncmap: -keyinline can not be used with " + flag
+		if _, err := run(t.TempDir(), flag, "-keyinline", "map[string]int"); err == nil || err.Error() != want {
+			t.Errorf("unexpected error for %s: %v, want %s", flag, err, want)
+		}
+	}
+}
+
+func TestClear(t *testing.T) {
+	src := generate(t, "-simple", "-clear", "map[int]int")
+	if !strings.Contains(src, "func (m *Map) Clear() {\n\tm.mu.Lock()\n\tm.m = nil\n") {
+		t.Errorf("unexpected Clear method of a simple map:\n%s", src)
+	}
+	layout := pointerLayout(t, (*Generator).addClear)
+	if !strings.Contains(layout, "\tm.read.Store(&readOnlyMap{})\n") {
+		t.Errorf("unexpected Clear method of an atomic.Pointer layout:\n%s", layout)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	for _, args := range [][]string{
+		{"-cas", "map[string]*int"},
+		{"-cas", "-valueinline", "-metrics", "map[string]int"},
+		{"-cas", "-slab", "-ordered", "map[string]int"},
+		{"-cas", "-simple", "map[string]int"},
+		{"-cas", "-readonly-after-init", "map[string]int"},
+		{"-cas", "map[string]struct{ Name string; IDs [2]int }"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(src, "func (m *Map) CompareAndSwap(key string, old, new ") {
+			t.Errorf("%v: missing CompareAndSwap method:\n%s", args, src)
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	for _, args := range [][]string{
+		{"-cas", "map[string]func()"},
+		{"-cas", "map[string][]int"},
+		{"-cas", "map[string]struct{ IDs []int }"},
+		{"-cas", "-set", "string"},
+	} {
+		if _, err := run(t.TempDir(), args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+// go114Source writes a sync/map.go as of Go 1.14 to dir, and returns its path. That is,
+// the copy in testdata/testdata without LoadAndDelete, whose entries can not return the
+// deleted values.
+func go114Source(t *testing.T, dir string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile("testdata/testdata/src/sync/map.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := string(b)
+	start, end := strings.Index(src, "// LoadAndDelete deletes"), strings.Index(src, "// Range calls f")
+	src = src[:start] + `// Delete deletes the value for a key.
+func (m *Map) Delete(key interface{}) {
+	read, _ := m.read.Load().(readOnly)
+	e, ok := read.m[key]
+	if !ok && read.amended {
+		m.mu.Lock()
+		read, _ = m.read.Load().(readOnly)
+		e, ok = read.m[key]
+		if !ok && read.amended {
+			delete(m.dirty, key)
+		}
+		m.mu.Unlock()
+	}
+	if ok {
+		e.delete()
+	}
+}
+
+func (e *entry) delete() (hadValue bool) {
+	for {
+		p := atomic.LoadPointer(&e.p)
+		if p == nil || p == expunged {
+			return false
+		}
+		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
+			return true
+		}
+	}
+}
+
+` + src[end:]
+	path := filepath.Join(dir, "map114.go")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAndDelete(t *testing.T) {
+	source := go114Source(t, t.TempDir())
+	for _, args := range [][]string{
+		{"map[string]int"},
+		{"-metrics", "-ordered", "map[string]*int"},
+		{"-valueinline", "-keyinline", "map[string]int"},
+		{"-slab", "-cas", "map[string]int"},
+		{"-simple", "map[string]int"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, append([]string{"-source", source, "-loadanddelete"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(src, "func (m *Map) LoadAndDelete(key string) (value ") {
+			t.Errorf("%v: missing LoadAndDelete method", args)
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	want := "syncmap: sync/map.go has no LoadAndDelete method, as in Go 1.14 and earlier. use -loadanddelete to generate it"
+	if _, err := run(t.TempDir(), "-source", source, "map[string]int"); err == nil || err.Error() != want {
+		t.Errorf("unexpected error without -loadanddelete: %v, want %s", err, want)
+	}
+	// Maps that have the method are generated as without the option.
+	withOption, err := run(t.TempDir(), "-no-header", "-loadanddelete", "map[string]int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	without := generate(t, "-no-header", "map[string]int")
+	if withOption != without {
+		t.Error("-loadanddelete should not change maps that have LoadAndDelete")
+	}
+}
+
+func TestSpecComments(t *testing.T) {
+	want := generate(t, "-no-header", "map[string]int")
+	for _, args := range [][]string{
+		{"\"map[string]int\""},
+		{"'map[string]int'"},
+		{"`map[string]int`"},
+		{"map[string]int // cache"},
+		{"'map[string]int // cache'"},
+		{"map[string]int", "//", "cache"},
+		{"map[string]int", "// cache"},
+	} {
+		got, err := run(t.TempDir(), append([]string{"-no-header"}, args...)...)
+		if err != nil {
+			t.Fatalf("%q: %v", args, err)
+		}
+		if got != want {
+			t.Errorf("%q: should be generated as map[string]int", args)
+		}
+	}
+	// Comment markers in struct tags are not comments.
+	src := generate(t, "-no-header", "map[string]struct{ A string `json:\"//a\"` } // users")
+	if !strings.Contains(src, "json:\"//a\"") || strings.Contains(src, "users") {
+		t.Error("the struct tag should be kept, and the comment dropped")
+	}
+}
+
+func TestSpecErrors(t *testing.T) {
+	for spec, want := range map[string]string{
+		"[]int":            "[]int is a slice type. expected a map type, e.g. map[int]int",
+		"[4]int":           "[4]int is an array type. expected a map type, e.g. map[int]int",
+		"[string]int":      "missing the map keyword in [string]int. expected map[string]int",
+		"string":           "string is not a map type. expected map[T1]T2, e.g. map[string]string. use -set to generate a set of string",
+		"map[string]":      "incomplete map type map[string]: missing the value type. expected map[T1]T2, e.g. map[string]int",
+		"map[string]int{}": "map[string]int{} is a map literal. expected the map type, without braces: map[string]int",
+	} {
+		_, err := run(t.TempDir(), spec)
+		if want = "syncmap: " + want; err == nil || err.Error() != want {
+			t.Errorf("%s: unexpected error: %v, want %s", spec, err, want)
+		}
+	}
+	if _, err := run(t.TempDir(), "map[string"); err == nil || !strings.HasPrefix(err.Error(), "syncmap: invalid map type map[string: ") {
+		t.Errorf("unexpected error of an unclosed key type: %v", err)
+	}
+}
+
+func TestOrdered(t *testing.T) {
+	for _, args := range [][]string{
+		{"-ordered", "map[string]*int"},
+		{"-ordered", "-valueinline", "-clear", "map[string]int"},
+		{"-ordered", "-simple", "-clear", "-metrics", "map[string]int"},
+		{"-ordered", "-nocopy", "-cas", "-helpers", "storeall,merge", "map[struct{ A, B int }]int"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"\"container/list\"", "func (m *Map) RangeOrdered(f func(key ", "func (m *Map) store(key "} {
+			if !strings.Contains(src, s) {
+				t.Errorf("%v: generated code should contain: %s", args, s)
+			}
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	for _, args := range [][]string{
+		{"-ordered", "-set", "string"},
+		{"-ordered", "-readonly-after-init", "map[string]int"},
+	} {
+		if _, err := run(t.TempDir(), args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestMultimap(t *testing.T) {
+	for _, args := range [][]string{
+		{"-multimap", "map[string][]int"},
+		{"-multimap", "-simple", "-clear", "map[string][]int"},
+		{"-multimap", "-metrics", "-capacity", "-helpers", "storeall", "map[int][]struct{ A, B int }"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"func (m *Map) Append(key ", "func (m *Map) Get(key ", "func (m *Map) store(key "} {
+			if !strings.Contains(src, s) {
+				t.Errorf("%v: generated code should contain: %s", args, s)
+			}
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	for _, args := range [][]string{
+		{"-multimap", "map[string]int"},
+		{"-multimap", "-ordered", "map[string][]int"},
+		{"-multimap", "-readonly-after-init", "map[string][]int"},
+		{"-multimap", "-set", "string"},
+	} {
+		if _, err := run(t.TempDir(), args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	for _, args := range [][]string{
+		{"-compact", "map[string]*int"},
+		{"-compact", "-valueinline", "-ordered", "map[string]int"},
+		{"-compact", "-simple", "map[string]int"},
+		{"-compact", "-set", "string"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(src, "func (m *Map) Compact() {") {
+			t.Errorf("%v: missing Compact method:\n%s", args, src)
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	if _, err := run(t.TempDir(), "-compact", "-readonly-after-init", "map[string]int"); err == nil {
+		t.Error("expected an error for -compact with -readonly-after-init")
+	}
+	layout := pointerLayout(t, (*Generator).addCompact)
+	for _, s := range []string{"\tif p := m.read.Load(); p != nil {\n\t\tread = *p\n", "\tm.read.Store(&readOnlyMap{m: compact})\n"} {
+		if !strings.Contains(layout, s) {
+			t.Errorf("unexpected Compact method of an atomic.Pointer layout:\n%s", layout)
+		}
+	}
+}
+
+func TestApproxLen(t *testing.T) {
+	for _, args := range [][]string{
+		{"-approxlen", "map[string]*int"},
+		{"-approxlen", "-simple", "map[string]int"},
+		{"-approxlen", "-readonly-after-init", "map[string]int"},
+		{"-approxlen", "-compact", "-internal", "map[string]int"},
+		{"-approxlen", "-set", "string"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(src, ") ApproxLen() int {") {
+			t.Errorf("%v: missing ApproxLen method:\n%s", args, src)
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	layout := pointerLayout(t, (*Generator).addApproxLen)
+	for _, s := range []string{"\tif p := m.read.Load(); p != nil {\n\t\tread = *p\n", "\t\tread = *m.read.Load()\n"} {
+		if !strings.Contains(layout, s) {
+			t.Errorf("unexpected ApproxLen method of an atomic.Pointer layout:\n%s", layout)
+		}
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "types.go"), []byte(`package main
+
+import "fmt"
+
+type Loader interface {
+	Load(key string) (int, bool)
+}
+
+type Store[K comparable, V any] interface {
+	Load(key K) (V, bool)
+	Store(key K, value V)
+}
+
+var _ fmt.Stringer
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		args []string
+		want []string
+	}{
+		{
+			[]string{"-satisfies", "Loader", "-satisfies", "Store[string, int]", "-satisfies", "fmt.Stringer", "-helpers", "string", "map[string]int"},
+			[]string{"\t_ Loader             = (*Map)(nil)\n", "\t_ Store[string, int] = (*Map)(nil)\n", "\t_ fmt.Stringer       = (*Map)(nil)\n"},
+		},
+		{
+			[]string{"-satisfies", "Store[K, V]", "-generic", "map[K]V"},
+			[]string{"func _[K comparable, V any]() {\n\tvar _ Store[K, V] = (*Map[K, V])(nil)\n}\n"},
+		},
+	} {
+		src, err := run(dir, tt.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(src, s) {
+				t.Errorf("%v: generated code should contain %q:\n%s", tt.args, s, src)
+			}
+		}
+		typeCheck(t, dir, "types.go", "map.go")
+	}
+	for args, want := range map[string]string{
+		"*Loader":       "syncmap: -satisfies: invalid interface *Loader. expected a named interface type, e.g. pkg.Iface",
+		"Store[string,": "syncmap: -satisfies: parse interface Store[string,: 1:14: expected ']', found 'EOF'",
+		"store.loader":  "syncmap: interface type store.loader: store.loader is not exported by package store",
+	} {
+		if _, err := run(dir, "-satisfies", args, "map[string]int"); err == nil || err.Error() != want {
+			t.Errorf("%s: unexpected error: %v, want %s", args, err, want)
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "types.go"), []byte(`package main
+
+type Point struct{ X, Y int }
+
+type ID int64
+
+func hashPoint(p Point) uint64 { return uint64(p.X)*31 + uint64(p.Y) }
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		args []string
+		hash string
+	}{
+		{[]string{"-shards", "4", "map[string]int"}, "hash.Sum64()"},
+		{[]string{"-shards", "4", "-counter", "-set", "string"}, "hash.Sum64()"},
+		{[]string{"-shards", "4", "-wait", "-compute", "-cas", "-compact", "-helpers", "keys,len", "map[ID]*int"}, "uint64(key) * 0x9e3779b97f4a7c15 >> 32"},
+		{[]string{"-shards", "4", "-multimap", "-simple", "map[uint8][]string"}, "uint64(key) * 0x9e3779b97f4a7c15 >> 32"},
+		{[]string{"-shards", "16", "-shard-hash", "hashPoint", "map[Point]string"}, "hashPoint(key)"},
+		{[]string{"-shards", "4", "-shard-hash", "hashPoint", "map[struct{ X, Y int }]int"}, "hashPoint(key)"},
+		{[]string{"-shards", "2", "-approxlen", "-readonly-after-init", "-helpers", "string", "map[string]int"}, "hash.Sum64()"},
+	} {
+		src, err := run(dir, tt.args...)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		for _, s := range []string{"\tshards [" + tt.args[1] + "]shardMap\n", "\th := " + tt.hash + "\n"} {
+			if !strings.Contains(src, s) {
+				t.Errorf("%v: generated code should contain %q:\n%s", tt.args, s, src)
+			}
+		}
+		typeCheck(t, dir, "types.go", "map.go")
+	}
+	for _, tt := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-shards", "1", "map[string]int"}, "syncmap: -shards: expected at least 2 shards: 1"},
+		{[]string{"-shards", "4", "-metrics", "map[string]int"}, "syncmap: -metrics can not be used with -shards"},
+		{[]string{"-shards", "4", "map[Point]int"}, "syncmap: -shards: key type Point has no builtin hash. expected a string or an integer type, or use -shard-hash with a func(Point) uint64"},
+		{[]string{"-shard-hash", "hashPoint", "map[Point]int"}, "syncmap: -shard-hash requires -shards"},
+	} {
+		if _, err := run(dir, tt.args...); err == nil || err.Error() != tt.err {
+			t.Errorf("%v: unexpected error: %v, want %s", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestPromotions(t *testing.T) {
+	for _, args := range [][]string{
+		{"-promotions", "map[string]int"},
+		{"-promotions", "-metrics", "-internal", "map[string]int"},
+		{"-promotions", "-mutex-type", "sync.RWMutex", "-set", "string"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"\tnpromotions int64\n", "\tatomic.AddInt64(&m.npromotions, 1)\n}\n", ") PromotionStats() (promotions int64, misses int) {"} {
+			if !strings.Contains(src, s) {
+				t.Errorf("%v: generated code should contain %q:\n%s", args, s, src)
+			}
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	for _, args := range [][]string{
+		{"-promotions", "-simple", "map[string]int"},
+		{"-promotions", "-readonly-after-init", "map[string]int"},
+	} {
+		if _, err := run(t.TempDir(), args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestCounter(t *testing.T) {
+	for _, args := range [][]string{
+		{"-counter", "map[string]*int"},
+		{"-counter", "-keyinline", "-metrics", "-ordered", "-clear", "map[string]int"},
+		{"-counter", "-generic", "map[K]V"},
+		{"-counter", "-simple", "map[string]int"},
+		{"-counter", "-readonly-after-init", "map[string]int"},
+		{"-counter", "-set", "string"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(src, ") Len() int {"); n != 1 {
+			t.Errorf("%v: %d Len methods, want 1:\n%s", args, n, src)
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	if _, err := run(t.TempDir(), "-counter", "-helpers", "len", "map[string]int"); err == nil {
+		t.Error("expected an error for -counter with the len helper")
+	}
+}
+
+func TestWait(t *testing.T) {
+	for _, args := range [][]string{
+		{"-wait", "map[string]*int"},
+		{"-wait", "-metrics", "-ordered", "map[string]int"},
+		{"-wait", "-generic", "map[K]V"},
+		{"-wait", "-simple", "map[string]int"},
+		{"-wait", "-readonly-after-init", "map[string]int"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(src, ") LoadOrWait(ctx context.Context, key ") {
+			t.Errorf("%v: missing LoadOrWait method:\n%s", args, src)
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	if _, err := run(t.TempDir(), "-wait", "-set", "string"); err == nil {
+		t.Error("expected an error for -wait with -set")
+	}
+}
+
+func TestCompute(t *testing.T) {
+	for _, args := range [][]string{
+		{"-compute", "map[string]*int"},
+		{"-compute", "-ordered", "-wait", "map[string]int"},
+		{"-compute", "-multimap", "map[string][]int"},
+		{"-compute", "-generic", "map[K]V"},
+		{"-compute", "-simple", "map[string]int"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(src, ") LoadOrCompute(key ") {
+			t.Errorf("%v: missing LoadOrCompute method:\n%s", args, src)
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	// The computed values are stored by the wrappers of the other options.
+	src, err := run(t.TempDir(), "-compute", "-ordered", "map[string]int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(src, "value, _ = m.LoadOrStore(key, value)") {
+		t.Errorf("the computed value should be stored with the ordered LoadOrStore:\n%s", src)
+	}
+	if _, err := run(t.TempDir(), "-compute", "-set", "string"); err == nil {
+		t.Error("expected an error for -compute with -set")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	for _, args := range [][]string{
+		{"-trace", "map[string]*int"},
+		{"-trace", "-ordered", "-compute", "-helpers", "keys", "map[string]int"},
+		{"-trace", "-generic", "map[K]V"},
+		{"-trace", "-simple", "map[string]int"},
+		{"-trace", "-set", "string"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"var MapTrace func(op string, key interface{})", "\ttrace(\"Range\", nil)\n"} {
+			if !strings.Contains(src, s) {
+				t.Errorf("%v: missing %q:\n%s", args, s, src)
+			}
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	src, err := run(t.TempDir(), "-trace", "-set", "string")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(src, "\ttrace(\"Add\", key)\n") || strings.Contains(src, "trace(\"Store\"") {
+		t.Errorf("the set API should be traced instead of the map methods:\n%s", src)
+	}
+	// The keys of literal struct types are traced.
+	src, err = run(t.TempDir(), "-trace", "map[struct{ X, Y int }]int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{"Load", "Store", "Delete"} {
+		if s := fmt.Sprintf("\ttrace(%q, key)\n", op); !strings.Contains(src, s) {
+			t.Errorf("the key of %s should be traced:\n%s", op, src)
+		}
+	}
+}
+
+func TestParent(t *testing.T) {
+	for _, args := range [][]string{
+		{"-parent", "map[string]*int"},
+		{"-parent", "-metrics", "-ordered", "-capacity", "map[string]int"},
+		{"-parent", "-internal", "map[string]int"},
+		{"-parent", "-generic", "map[K]V"},
+		{"-parent", "-simple", "map[string]int"},
+		{"-parent", "-readonly-after-init", "map[string]int"},
+	} {
+		dir := t.TempDir()
+		src, err := run(dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(src, "func NewMapWithParent") {
+			t.Errorf("%v: missing NewMapWithParent constructor:\n%s", args, src)
+		}
+		typeCheck(t, dir, "map.go")
+	}
+	if _, err := run(t.TempDir(), "-parent", "-set", "string"); err == nil {
+		t.Error("expected an error for -parent with -set")
+	}
+}
+
+func TestGenerateFile(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "session.go")
+	args := []string{"-source", "testdata/testdata/src/sync/map.go", "-o", out, "-name", "UserMap", "-name", "TokenMap", "-name", "SessionMap",
+		"-helpers", "keys", "map[string]*http.Request", "map[string]int", "map[string]time.Time"}
+	cs, err := ParseConfigs(flag.NewFlagSet("syncmap", flag.ContinueOnError), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 3 || cs[1].Name != "TokenMap" || cs[1].Spec != "map[string]int" || cs[2].Out != out {
+		t.Fatalf("unexpected configs: %+v", cs)
+	}
+	if err := GenerateFile(cs); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := string(b)
+	for s, n := range map[string]int{
+		"\npackage main\n": 1,
+		"\nimport (\n":     1,
+		"\t\"sync\"\n":     1,
+		"\t\"net/http\"\n": 1,
+		"\t\"time\"\n":     1,
+		"// --- UserMap (map[string]*http.Request) ---": 1,
+		"// --- TokenMap (map[string]int) ---":          1,
+		"// --- SessionMap (map[string]time.Time) ---":  1,
+		"// Copyright 2016 The Go Authors.":             1,
+		"func (m *SessionMap) Keys() []string {":        1,
+	} {
+		if c := strings.Count(src, s); c != n {
+			t.Errorf("generated code should contain %q %d times: %d", s, n, c)
+		}
+	}
+	typeCheck(t, dir, "session.go")
+	if err := Regen(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(out); err != nil || string(b) != src {
+		t.Errorf("regenerated file should be unchanged: %v", err)
+	}
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-name", "UserMap", "-name", "TokenMap", "map[string]int", "map[int]string"}, "syncmap: -o is required with several -name"},
+		{[]string{"-o", out, "-name", "UserMap", "-name", "TokenMap", "map[string]int"}, "syncmap: expected a type argument for each -name: 2 names and 1 types"},
+		{[]string{"-o", out, "-stdin", "-name", "UserMap", "-name", "TokenMap"}, "syncmap: -stdin can not be used with several -name"},
+		{[]string{"-o", out, "-assert-zero", "-name", "UserMap", "-name", "TokenMap", "map[string]int", "map[int]string"}, "syncmap: -ext, -tags, -assert-size and -assert-zero can not be used with several -name"},
+		{[]string{"-o", out, "-name", "UserMap", "-name", "UserMap", "map[string]int", "map[int]string"}, "syncmap: identifier UserMap is declared by both UserMap and UserMap. use different -name"},
+	}
+	for _, tt := range tests {
+		cs, err := ParseConfigs(flag.NewFlagSet("syncmap", flag.ContinueOnError), append([]string{"-source", "testdata/testdata/src/sync/map.go"}, tt.args...))
+		if err == nil {
+			err = GenerateFile(cs)
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error for %v: %v, want %s", tt.args, err, tt.err)
+		}
+	}
+	if _, err := ParseConfig(flag.NewFlagSet("syncmap", flag.ContinueOnError), args); err == nil {
+		t.Error("ParseConfig should fail for several -name")
+	}
+}
+
+func TestGenerateFiles(t *testing.T) {
+	dir := t.TempDir()
+	user, token := filepath.Join(dir, "user", "user.go"), filepath.Join(dir, "auth", "token.go")
+	for _, d := range []string{filepath.Dir(user), filepath.Dir(token)} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	args := []string{"-source", "testdata/testdata/src/sync/map.go", "-helpers", "keys",
+		"-name", "UserMap", "-o", user, "-pkg", "user",
+		"-name", "TokenMap", "-o", token, "-pkg", "auth",
+		"-name", "SessionMap", "-o", token, "-pkg", "auth",
+		"map[string]*http.Request", "map[string]int", "map[string]time.Time"}
+	cs, err := ParseConfigs(flag.NewFlagSet("syncmap", flag.ContinueOnError), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 3 || cs[0].Out != user || cs[0].Pkg != "user" || cs[2].Out != token || cs[2].Pkg != "auth" {
+		t.Fatalf("unexpected configs: %+v", cs)
+	}
+	if err := GenerateFiles(cs); err != nil {
+		t.Fatal(err)
+	}
+	srcs := make(map[string]string)
+	for path, want := range map[string][]string{
+		user:  {"\npackage user\n", "// syncmap -source testdata/testdata/src/sync/map.go -helpers keys -o " + user + " -pkg user -name UserMap map[string]*http.Request\n", "func (m *UserMap) Keys() []string {"},
+		token: {"\npackage auth\n", "-o " + token + " -pkg auth -name TokenMap -name SessionMap map[string]int map[string]time.Time\n", "// --- SessionMap (map[string]time.Time) ---"},
+	} {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcs[path] = string(b)
+		for _, s := range want {
+			if !strings.Contains(string(b), s) {
+				t.Errorf("%s should contain %q:\n%s", path, s, b)
+			}
+		}
+		typeCheck(t, filepath.Dir(path), filepath.Base(path))
+	}
+	if strings.Contains(srcs[user], "TokenMap") || strings.Contains(srcs[token], "UserMap") {
+		t.Error("each file should contain only its own maps")
+	}
+	if err := Regen(dir); err != nil {
+		t.Fatal(err)
+	}
+	for path, src := range srcs {
+		if b, err := ioutil.ReadFile(path); err != nil || string(b) != src {
+			t.Errorf("regenerated file %s should be unchanged: %v", path, err)
+		}
+	}
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-o", user, "-o", token, "-name", "UserMap", "-name", "TokenMap", "-name", "SessionMap", "map[string]int", "map[int]string", "map[int]int"},
+			"syncmap: expected one -o, or a -o for each -name: 3 names and 2 -o"},
+		{[]string{"-o", user, "-pkg", "user", "-pkg", "auth", "-name", "UserMap", "-name", "TokenMap", "map[string]int", "map[int]string"},
+			"syncmap: maps of one file must have the same output file and package"},
+		{[]string{"-o", user, "-o", token, "map[string]int"}, "syncmap: several -o or -pkg require a -name for each of them"},
+	}
+	for _, tt := range tests {
+		cs, err := ParseConfigs(flag.NewFlagSet("syncmap", flag.ContinueOnError), append([]string{"-source", "testdata/testdata/src/sync/map.go"}, tt.args...))
+		if err == nil {
+			err = GenerateFiles(cs)
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error for %v: %v, want %s", tt.args, err, tt.err)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"methods.tmpl": `
+// Has reports whether the key is present in the map.
+func (m {{.Recv}}) Has(key {{.Key}}) bool {
+	_, ok := m.{{.Load}}(key)
+	return ok
+}
+
+// Values returns the values of the map.
+func (m {{.Recv}}) Values() (values []{{.Value}}) {
+	m.{{.Range}}(func(_ {{.Key}}, v {{.Value}}) bool {
+		values = append(values, v)
+		return true
+	})
+	return values
+}
+`,
+		"invalid.tmpl":  "func (m {{.Recv}}) Has( {}\n",
+		"imports.tmpl":  "import \"fmt\"\n\nfunc (m {{.Recv}}) Print() { fmt.Println(m) }\n",
+		"template.tmpl": "{{.Name}\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := run(dir, "-name", "UserMap", "-methods", "methods.tmpl", "map[string]*int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"func (m *UserMap) Has(key string) bool {\n\t_, ok := m.Load(key)\n", "func (m *UserMap) Values() (values []*int) {"} {
+		if !strings.Contains(src, s) {
+			t.Errorf("generated code should contain: %s", s)
+		}
+	}
+	typeCheck(t, dir, "map.go")
+	for _, name := range []string{"invalid.tmpl", "imports.tmpl", "template.tmpl", "missing.tmpl"} {
+		if _, err := run(dir, "-methods", name, "map[string]int"); err == nil {
+			t.Errorf("expected an error for the -methods file %s", name)
+		}
+	}
+}
+
+func TestTagsFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"platform.txt": "\n//go:build linux && !race\n", "empty.txt": "\n\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := run(dir, "-tags", "@platform.txt", "-helpers", "len", "map[int]int"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "map_ext.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "//go:build linux && !race\n// +build linux,!race\n") {
+		t.Errorf("extension file should be guarded by the constraint of the file:\n%s", b)
+	}
+	if _, err := run(dir, "-tags", "@empty.txt", "map[int]int"); err == nil || err.Error() != "syncmap: -tags file "+filepath.Join(dir, "empty.txt")+" is empty" {
+		t.Errorf("unexpected error for an empty file: %v", err)
+	}
+	if _, err := run(dir, "-tags", "@missing.txt", "map[int]int"); err == nil || !strings.HasPrefix(err.Error(), "syncmap: read -tags file: ") {
+		t.Errorf("unexpected error for a missing file: %v", err)
+	}
+}
+
+func TestAssertComparable(t *testing.T) {
+	src := generate(t, "-assert-comparable", "map[[2]string]int")
+	if !strings.Contains(src, "// [2]string can not be used as a map key.\nvar _ map[[2]string]struct{}\n") {
+		t.Errorf("generated code should contain the comparable assertion:\n%s", src)
+	}
+	// The assertion reports non-comparable keys at its own location.
+	src, err := run(t.TempDir(), "-assert-comparable", "map[[]string]int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "map.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Count(src[:strings.Index(src, "var _ map[")], "\n") + 1
+	var errs []string
+	conf := types.Config{Importer: importer.Default(), Error: func(err error) { errs = append(errs, err.Error()) }}
+	conf.Check("main", fset, []*ast.File{f}, nil)
+	want := fmt.Sprintf("map.go:%d:", line)
+	for _, err := range errs {
+		if strings.HasPrefix(err, want) && strings.Contains(err, "invalid map key type") {
+			return
+		}
+	}
+	t.Errorf("expected an invalid map key error at %s, got: %v", want, errs)
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	files := fstest.MapFS{}
+	c := Config{Name: "Map", Pkg: "main", Spec: "map[int]int", Size: 40, Ext: true, Helpers: []string{"keys"}, Out: filepath.Join(dir, "map.go")}
+	c.WriteFile = func(name string, data []byte, perm fs.FileMode) error {
+		files[filepath.Base(name)] = &fstest.MapFile{Data: data, Mode: perm}
+		return nil
+	}
+	if err := runConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(files, "map.go", "map_ext.go", "map_size_test.go"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := fs.ReadFile(files, "map_ext.go"); err != nil || !strings.Contains(string(b), "func (m *Map) Keys() []int {") {
+		t.Errorf("unexpected extension file: %s, %v", b, err)
+	}
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) > 0 {
+		t.Errorf("files should not be written to the file system: %v, %v", entries, err)
+	}
+	c.WriteFile = func(string, []byte, fs.FileMode) error { return fmt.Errorf("read-only file system") }
+	if err := runConfig(c); err == nil || err.Error() != "syncmap: writing file: "+c.Out+": read-only file system" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// fastConfigs are configs of maps generated by both the AST mutation and the templates
+// of -fast. All of them, except the last two, are generated from templates.
+var fastConfigs = [][]string{
+	{"-name", "IntMap", "map[int]int"},
+	{"-name", "IntStringMap", "map[int]string"},
+	{"-name", "Requests", "map[string]*http.Request"},
+	{"-name", "userMap", "map[*u.ID][]ID"},
+	{"-name", "StringSet", "-set", "-metrics", "string"},
+	{"-name", "FullMap", "-metrics", "-clear", "-capacity", "-iter", "-chaniter", "-helpers", "len,keys,values,string,tomap,json", "map[string]int"},
+	{"-name", "InlineMap", "-valueinline", "-cas", "-helpers", "loadordefault", "map[ID]int"},
+	{"-name", "SlabMap", "-slab", "-clear", "-compact", "map[ID]int"},
+	{"-name", "KeyMap", "-keyinline", "-valueinline", "map[*u.ID]ID"},
+	{"-name", "SimpleMap", "-simple", "-metrics", "-helpers", "keys", "map[string][]byte"},
+	{"-name", "ExtMap", "-ext", "-tags", "debug", "-helpers", "len,string", "map[int]string"},
+	{"-name", "LineMap", "-line", "map[string]int"},
+	{"-name", "IDMap", "-assert-comparable", "-assert-size", "40", "map[u.ID]int"},
+	{"-name", "OrderedMap", "-ordered", "-clear", "map[string]ID"},
+	{"-name", "HiddenMap", "-internal", "-capacity", "-metrics", "map[string]ID"},
+	{"-name", "LockMap", "-mutex-type", "u.Mutex", "map[string]ID"},
+	{"-name", "SpacedMap", "map[ string ] []int"},
+	{"-name", "SpacedSet", "-set", " *u.ID "},
+	{"-name", "FuncMap", "map[string]func()"},
+	{"-name", "BinaryMap", "-helpers", "binary", "map[string]ID"},
+}
+
+func TestFast(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "types.go"), []byte(`package main
+
+import u "example.com/user"
+
+var _ u.ID
+
+type ID string
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := make([]Config, len(fastConfigs))
+	for i, args := range fastConfigs {
+		c, err := ParseConfig(flag.NewFlagSet("syncmap", flag.ContinueOnError), args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Out = filepath.Join(dir, strings.ToLower(c.Name)+".go")
+		configs[i] = c
+	}
+	capture := func(files map[string]string) func(string, []byte, fs.FileMode) error {
+		return func(name string, data []byte, _ fs.FileMode) error {
+			files[filepath.Base(name)] = string(data)
+			return nil
+		}
+	}
+	want := make(map[string]string)
+	for _, c := range configs {
+		c.WriteFile = capture(want)
+		if err := runConfig(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var g *Generator
+	// The maps are generated twice, to use the cached templates.
+	for _, round := range []string{"cold", "cached"} {
+		got := make(map[string]string)
+		for i, c := range configs {
+			c.Fast, c.WriteFile = true, capture(got)
+			if g == nil {
+				g, err = NewGenerator(c)
+				g.goroot = "testdata/testdata"
+			} else {
+				err = g.Reset(c)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Mutate(); err != nil {
+				t.Fatal(err)
+			}
+			if fast := g.body != nil; fast != (i < len(configs)-2) {
+				t.Errorf("%s: unexpected use of the template for %v: %t", round, fastConfigs[i], fast)
+			}
+			if err := g.Gen(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			for name := range want {
+				if got[name] != want[name] {
+					t.Errorf("%s: %s is different from the AST mutation:\n%s", round, name, got[name])
+				}
+			}
+		}
+	}
+}
+
+func TestFastVerbose(t *testing.T) {
+	c := Config{Name: "Map", Pkg: "main", Spec: "map[int]int", Fast: true, Verbose: true, Out: filepath.Join(t.TempDir(), "map.go")}
+	g, err := NewGenerator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := bytes.NewBuffer(nil)
+	// The embedded copy of sync/map.go is used, with a warning.
+	g.goroot, g.stderr = t.TempDir(), log
+	if err := g.Mutate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Gen(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"syncmap: warning: ",
+		"syncmap: handling type readOnly\n",
+		"syncmap: generating Map from a cached template\n",
+	} {
+		if !strings.Contains(log.String(), s) {
+			t.Errorf("log should contain: %s", s)
+		}
+	}
+}
+
+func BenchmarkGenerate(b *testing.B) {
+	dir := b.TempDir()
+	for _, fast := range []bool{false, true} {
+		b.Run(fmt.Sprintf("fast=%t", fast), func(b *testing.B) {
+			b.ReportAllocs()
+			g, err := NewGenerator(Config{Name: "Map", Pkg: "main", Spec: "map[int]int", Out: filepath.Join(dir, "map.go")})
+			if err != nil {
+				b.Fatal(err)
+			}
+			g.goroot = "testdata/testdata"
+			for i := 0; i < b.N; i++ {
+				c := Config{
+					Name:      fmt.Sprintf("Map%d", i),
+					Pkg:       "main",
+					Spec:      "map[string]int",
+					Out:       filepath.Join(dir, "map.go"),
+					Fast:      fast,
+					WriteFile: func(string, []byte, fs.FileMode) error { return nil },
+				}
+				if err := g.Reset(c); err != nil {
+					b.Fatal(err)
+				}
+				if err := g.Mutate(); err != nil {
+					b.Fatal(err)
+				}
+				if err := g.Gen(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSource(t *testing.T) {
+	src, err := ioutil.ReadFile("testdata/testdata/src/sync/map.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	patched := filepath.Join(dir, "patched.go")
+	err = ioutil.WriteFile(patched, bytes.Replace(src, []byte("// Map is like a Go map"), []byte("// Map is a patched Go map,\n// like a Go map"), 1), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := run(dir, "-source", patched, "-name", "IntMap", "map[int]int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "// IntMap is a patched Go map,\n") {
+		t.Errorf("generated code should be specialized from the patched source:\n%s", out[:1000])
+	}
+	generic := filepath.Join(dir, "generic.go")
+	err = ioutil.WriteFile(generic, []byte(`package sync
+
+type Map[K comparable, V any] struct {
+	m map[K]V
+}
+
+func (m *Map[K, V]) Load(key K) (value V, ok bool) {
+	value, ok = m.m[key]
+	return
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{generic, "syncmap: " + generic + ": generic type Map is not supported"},
+		{filepath.Join(dir, "missing.go"), "syncmap: read \"" + filepath.Join(dir, "missing.go") + "\" file: open " + filepath.Join(dir, "missing.go") + ": no such file or directory"},
+	}
+	for _, tt := range tests {
+		if _, err := run(dir, "-source", tt.source, "map[int]int"); err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error: %v, want %s", err, tt.err)
+		}
+	}
+}
+
+func TestBestEffort(t *testing.T) {
+	src, err := ioutil.ReadFile("testdata/testdata/src/sync/map.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A sync/map.go of a future version, with a renamed method, and new declarations.
+	src = bytes.Replace(src, []byte("func (m *Map) Delete("), []byte("func (m *Map) Remove("), 1)
+	src = append(src, []byte(`
+// pair is a key-value pair of the map.
+type pair struct {
+	key, value interface{}
+}
+
+// Swap swaps the value for a key and returns the previous value if any.
+func (m *Map) Swap(key, value interface{}) (previous interface{}, loaded bool) {
+	p := pair{key, value}
+	previous, loaded = m.Load(p.key)
+	m.Store(p.key, p.value)
+	return previous, loaded
+}
+`)...)
+	dir := t.TempDir()
+	source := filepath.Join(dir, "next.go")
+	if err := ioutil.WriteFile(source, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(dir, "-source", source, "map[string]*int"); err == nil || err.Error() != "syncmap: unrecognized function: Remove" {
+		t.Errorf("unexpected error: %v, want unrecognized function", err)
+	}
+	c := Config{Name: "Map", Pkg: "main", Spec: "map[string]*int", Source: source, BestEffort: true, Out: filepath.Join(dir, "map.go")}
+	g, err := NewGenerator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := bytes.NewBuffer(nil)
+	g.stderr = log
+	if err := g.Mutate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Gen(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"syncmap: warning: best-effort: unrecognized func Remove. its types are substituted heuristically, and its code is not adapted\n",
+		"syncmap: warning: best-effort: unrecognized type pair. its types are substituted heuristically, and its code is not adapted\n",
+		"syncmap: warning: best-effort: unrecognized func Swap. its types are substituted heuristically, and its code is not adapted\n",
+		"syncmap: warning: best-effort: missing func Delete, which is declared by the supported versions of sync/map.go\n",
+	} {
+		if !strings.Contains(log.String(), s) {
+			t.Errorf("log should contain: %s", s)
+		}
+	}
+	pkg := typeCheck(t, dir, "map.go")
+	checkLoad(t, pkg, "string", "*int")
+	m := pkg.Scope().Lookup("Map").Type()
+	for name, want := range map[string]string{
+		"Remove": "func(key string)",
+		"Swap":   "func(key string, value *int) (previous *int, loaded bool)",
+	} {
+		f, _, _ := types.LookupFieldOrMethod(m, true, pkg, name)
+		if f == nil {
+			t.Errorf("missing method %s", name)
+			continue
+		}
+		if sig := types.TypeString(f.Type(), nil); sig != want {
+			t.Errorf("unexpected signature of %s: %s, want %s", name, sig, want)
+		}
+	}
+}
+
+func TestNoCopy(t *testing.T) {
+	for _, args := range [][]string{{"map[int]int"}, {"-metrics", "map[int]int"}, {"-simple", "map[int]int"}} {
+		dir := t.TempDir()
+		if _, err := run(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+		size := types.SizesFor("gc", "amd64").Sizeof(typeCheck(t, dir, "map.go").Scope().Lookup("Map").Type())
+		if _, err := run(dir, append([]string{"-nocopy"}, args...)...); err != nil {
+			t.Fatal(err)
+		}
+		m := typeCheck(t, dir, "map.go").Scope().Lookup("Map").Type()
+		if s := types.SizesFor("gc", "amd64").Sizeof(m); s != size {
+			t.Errorf("%v: the noCopy field should not change the size of the map: %d, want %d", args, s, size)
+		}
+		f := m.Underlying().(*types.Struct).Field(0)
+		if f.Name() != "noCopy" {
+			t.Fatalf("%v: noCopy should be the first field of the map: %s", args, f.Name())
+		}
+		for _, name := range []string{"Lock", "Unlock"} {
+			if obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(f.Type()), false, nil, name); obj == nil {
+				t.Errorf("%v: noCopy should have a %s method", args, name)
+			}
+		}
+	}
+}
+
+func TestInternal(t *testing.T) {
+	exported := func(typ types.Type) (names []string) {
+		ms := types.NewMethodSet(types.NewPointer(typ))
+		for i := 0; i < ms.Len(); i++ {
+			if name := ms.At(i).Obj().Name(); token.IsExported(name) {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	for _, args := range [][]string{
+		{"map[string]int"},
+		{"-metrics", "-capacity", "-helpers", "keys,json", "map[string]int"},
+		{"-simple", "-clear", "map[string]int"},
+		{"-readonly-after-init", "map[string]int"},
+		{"-set", "string"},
+		{"-generic", "map[K]V"},
+	} {
+		dir := t.TempDir()
+		if _, err := run(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+		want := exported(typeCheck(t, dir, "map.go").Scope().Lookup("Map").Type())
+		if _, err := run(dir, append([]string{"-internal"}, args...)...); err != nil {
+			t.Fatal(err)
+		}
+		m := typeCheck(t, dir, "map.go").Scope().Lookup("Map").Type()
+		st := m.Underlying().(*types.Struct)
+		if st.NumFields() != 1 || !st.Field(0).Embedded() || st.Field(0).Name() != "implMap" {
+			t.Errorf("%v: Map should only embed implMap: %s", args, st)
+		}
+		if got := exported(m); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: unexpected methods of Map: %v, want %v", args, got, want)
+		}
+	}
+}
+
+func TestMutexType(t *testing.T) {
+	for _, args := range [][]string{
+		{"-mutex-type", "Mutex", "map[string]int"},
+		{"-mutex-type", "Mutex", "-simple", "map[string]int"},
+		{"-mutex-type", "Mutex", "-readonly-after-init", "-capacity", "map[string]int"},
+		{"-mutex-type", "sync.RWMutex", "-metrics", "map[string]int"},
+	} {
+		dir := t.TempDir()
+		err := ioutil.WriteFile(filepath.Join(dir, "mutex.go"), []byte(`package main
+
+import "sync"
+
+type Mutex struct{ sync.Mutex }
+`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := run(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+		pkg := typeCheck(t, dir, "map.go", "mutex.go")
+		mu, _, _ := types.LookupFieldOrMethod(pkg.Scope().Lookup("Map").Type(), false, pkg, "mu")
+		want := args[1]
+		if !strings.Contains(want, ".") {
+			want = "main." + want
+		}
+		if typ := mu.Type().String(); typ != want {
+			t.Errorf("%v: unexpected type of mu: %s, want %s", args, typ, want)
+		}
+	}
+	for _, typ := range []string{"*Mutex", "[]int", "m[int]"} {
+		if _, err := run(t.TempDir(), "-mutex-type", typ, "map[string]int"); err == nil {
+			t.Errorf("-mutex-type %s: expected an error", typ)
+		}
+	}
+}
+
+func TestLineEndings(t *testing.T) {
+	tests := []struct {
+		crlf   bool
+		format func([]byte) ([]byte, error)
+	}{
+		{false, nil},
+		{true, nil},
+		{false, func(src []byte) ([]byte, error) {
+			src, err := imports.Process("", src, nil)
+			return append(src, "\n\n"...), err
+		}},
+		{true, func(src []byte) ([]byte, error) {
+			src, err := imports.Process("", src, nil)
+			return bytes.ReplaceAll(src, []byte("\n"), []byte("\r\n")), err
+		}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		c := Config{Name: "Map", Pkg: "main", Spec: "map[int]int", Size: 40, Ext: true, Helpers: []string{"keys"}, CRLF: tt.crlf, Format: tt.format, Out: filepath.Join(dir, "map.go")}
+		if err := runConfig(c); err != nil {
+			t.Fatal(err)
+		}
+		eol := "\n"
+		if tt.crlf {
+			eol = "\r\n"
+		}
+		for _, name := range []string{"map.go", "map_ext.go", "map_size_test.go"} {
+			b, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			src := string(b)
+			if n := strings.Count(src, "\n"); strings.Count(src, eol) != n || strings.Count(src, "\r") != strings.Count(src, "\r\n") {
+				t.Errorf("crlf=%t: %s should use %q line endings only", tt.crlf, name, eol)
+			}
+			if !strings.HasSuffix(src, eol) || strings.HasSuffix(src, eol+eol) {
+				t.Errorf("crlf=%t: %s should end with a single newline: %q", tt.crlf, name, src[len(src)-10:])
+			}
+		}
+	}
+}
+
+func TestPerm(t *testing.T) {
+	dir := t.TempDir()
+	for _, mode := range []string{"0444", "0444", "640"} {
+		if _, err := run(dir, "-perm", mode, "-assert-size", "40", "map[int]int"); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"map.go", "map_size_test.go"} {
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprintf("%#o", info.Mode().Perm()); strings.TrimLeft(got, "0") != strings.TrimLeft(mode, "0") {
+				t.Errorf("unexpected permissions of %s: %s, want %s", name, got, mode)
+			}
+		}
+	}
+	for _, mode := range []string{"rw", "0888", "01777", "0"} {
+		_, err := ParseConfig(flag.NewFlagSet("syncmap", flag.ContinueOnError), []string{"-perm", mode, "map[int]int"})
+		if err == nil || !strings.Contains(err.Error(), "invalid permissions") {
+			t.Errorf("unexpected error for %s: %v", mode, err)
+		}
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	want, err := run(t.TempDir(), "-metrics", "-helpers", "keys,string", "map[int]int")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{Name: "Map", Pkg: "main", Spec: "map[int]int", Metrics: true, Helpers: []string{"keys", "string"}, Verbose: true, Out: filepath.Join(t.TempDir(), "map.go")}
+	g, err := NewGenerator(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := bytes.NewBuffer(nil)
+	g.goroot, g.stderr = "testdata/testdata", log
+	if err := g.Mutate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Gen(); err != nil {
+		t.Fatal(err)
+	}
+	// The command is not recorded when the config is not parsed from arguments.
+	want = strings.Replace(want, "// syncmap -metrics -helpers keys,string map[int]int\n", "", 1)
+	if b, err := ioutil.ReadFile(c.Out); err != nil || string(b) != want {
+		t.Errorf("verbose mode should not affect the generated code: %v", err)
+	}
+	for _, s := range []string{
+		"syncmap: read testdata/testdata/src/sync/map.go\n",
+		"syncmap: handling type readOnly\n",
+		"syncmap: handling func tryExpungeLocked\n",
+		"syncmap: renaming entry to entryMap\n",
+		"syncmap: adding import \"sync/atomic\" for the metrics\n",
+		"syncmap: adding helper string\n",
+		"syncmap: adding import \"fmt\" for the helpers\n",
+		"syncmap: writing " + c.Out + "\n",
+	} {
+		if !strings.Contains(log.String(), s) {
+			t.Errorf("log should contain: %s", s)
+		}
+	}
+}
+
+func TestDumpMalformed(t *testing.T) {
+	g, err := NewGenerator(Config{Name: "Map", Pkg: "main", Spec: "map[int]int", Out: filepath.Join(t.TempDir(), "map.go")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := bytes.NewBuffer(nil)
+	g.goroot, g.stderr = "testdata/testdata", log
+	if err := g.Mutate(); err != nil {
+		t.Fatal(err)
+	}
+	// A statement without an expression, as a buggy mutation could leave.
+	for _, d := range g.file.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Name.Name == "Delete" {
+			d.Body.List[0] = &ast.ExprStmt{}
+		}
+	}
+	err = g.Gen()
+	if err == nil || !strings.HasPrefix(err.Error(), "syncmap: format mutated code (the AST is printed above): ") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dump := log.String()
+	if !strings.HasPrefix(dump, "syncmap: the mutated code can not be formatted.") || !strings.Contains(dump, `Name: "Delete"`) {
+		t.Errorf("the AST of Delete should be printed:\n%s", dump)
+	}
+	if strings.Contains(dump, `Name: "Load"`) {
+		t.Error("only the malformed declarations should be printed")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	mod, nomod := t.TempDir(), t.TempDir()
+	for name, src := range map[string]string{
+		filepath.Join(mod, "go.mod"):   "module example.com/app\n\ngo 1.18\n",
+		filepath.Join(mod, "types.go"): "package main\n\ntype ID string\n\ntype Pair[A, B any] struct {\n\ta A\n\tb B\n}\n",
+		filepath.Join(mod, "bad.tmpl"): "func (m {{.Recv}}) Bad() int { return \"s\" }\n",
+	} {
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		dir  string
+		args []string
+		err  string
+	}{
+		{mod, []string{"-cas", "map[ID]Pair[ID, int]"}, ""},
+		{mod, []string{"-tags", "debug", "-helpers", "keys", "-assert-zero", "map[string]*ID"}, ""},
+		{nomod, []string{"-generic", "-iter", "map[K]V"}, ""},
+		{mod, []string{"-methods", "bad.tmpl", "map[ID]int"}, "syncmap: -verify: go build failed:\n" + filepath.Join(mod, "map.go") + ":"},
+	}
+	for _, tt := range tests {
+		_, err := run(tt.dir, append([]string{"-verify"}, tt.args...)...)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("unexpected error for %q: %v", tt.args, err)
+		case tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)):
+			t.Errorf("unexpected error for %q: %v, want %s", tt.args, err, tt.err)
+		}
+	}
+	// The throwaway packages are removed.
+	for _, dir := range []string{mod, nomod} {
+		if matches, _ := filepath.Glob(filepath.Join(dir, "_syncmap_verify*")); len(matches) > 0 {
+			t.Errorf("throwaway packages should be removed: %q", matches)
+		}
+	}
+}
+
+func TestBench(t *testing.T) {
+	source := filepath.Join("testdata", "testdata", "src", "sync", "map.go")
+	b := bytes.NewBuffer(nil)
+	if err := Bench(b, []string{"-benchtime", "1x", "-source", source, "-name", "IntMap", "map[int]string"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"op  ", "IntMap", "sync.Map wrapper", "\nStore  ", "\nLoad  ", "\nLoadOrStore  ", "\nRange  "} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("output should contain %q:\n%s", s, b)
+		}
+	}
+	for _, args := range [][]string{
+		{"-source", source, "map[[2]int]int"},
+		{"-source", source, "-set", "string"},
+		{"-source", source, "map[string]ID"},
+	} {
+		if err := Bench(ioutil.Discard, args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	var g *Generator
+	gen := func(helpers ...string) map[string]string {
+		files := make(map[string]string)
+		c := Config{
+			Name:     "Map",
+			Pkg:      "main",
+			Spec:     "map[string]int",
+			Metrics:  true,
+			Clear:    true,
+			Capacity: true,
+			Ext:      true,
+			Helpers:  helpers,
+			Format:   func(src []byte) ([]byte, error) { return src, nil },
+			Out:      filepath.Join(dir, "map.go"),
+			WriteFile: func(name string, data []byte, _ fs.FileMode) error {
+				files[filepath.Base(name)] = string(data)
+				return nil
+			},
+		}
+		var err error
+		if g == nil {
+			g, err = NewGenerator(c)
+			g.goroot = "testdata/testdata"
+		} else {
+			err = g.Reset(c)
+		}
+		if err == nil {
+			err = g.Mutate()
+		}
+		if err == nil {
+			err = g.Gen()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	want := gen("string", "rangesafe", "json", "keys", "gob")
+	if n := strings.Count(want["map_ext.go"], "import \"fmt\"\n"); n != 1 {
+		t.Errorf("the fmt package should be imported once by the extension file: %d", n)
+	}
+	for _, helpers := range [][]string{
+		{"string", "rangesafe", "json", "keys", "gob"},
+		{"gob", "keys", "json", "rangesafe", "string"},
+		{"keys", "json", "gob", "string", "rangesafe", "keys"},
+	} {
+		for i := 0; i < 3; i++ {
+			if got := gen(helpers...); !reflect.DeepEqual(got, want) {
+				t.Fatalf("the output of %v is different from the first one", helpers)
+			}
+		}
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	if err := Doctor(b, "testdata/testdata/src/sync/map.go"); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, b)
+	}
+	if want := "testdata/testdata/src/sync/map.go: 20 declarations\n\tcompatible\n"; b.String() != want {
+		t.Errorf("unexpected report: %q, want %q", b, want)
+	}
+	src, err := ioutil.ReadFile("testdata/testdata/src/sync/map.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src = bytes.Replace(src, []byte("func (m *Map) Delete("), []byte("func (m *Map) Remove("), 1)
+	src = append(src, "\nfunc (m *Map) Swap(key, value interface{}) (previous interface{}, loaded bool) { return }\n\ntype pair[K comparable, V any] struct{}\n"...)
+	path := filepath.Join(t.TempDir(), "map.go")
+	if err := ioutil.WriteFile(path, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := Doctor(b, path); err == nil || err.Error() != "syncmap: "+path+" is not compatible with this version of syncmap" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, s := range []string{
+		"\tunrecognized (no handler): func Remove\n",
+		"\tunrecognized (no handler): func Swap\n",
+		"\tunrecognized (no handler): type pair\n",
+		"\tmissing (handled, but not declared): func Delete\n",
+		"\tgeneric (not supported): type pair\n",
+		"\tmutation failed: syncmap: " + path + ": generic type pair is not supported\n",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("report should contain: %q\n%s", s, b)
+		}
+	}
+}
+
+func TestLineDirectives(t *testing.T) {
+	src := generate(t, "-line", "-helpers", "len", "map[int]int")
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "map.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Map":              "testdata/testdata/src/sync/map.go:27",
+		"Load":             "testdata/testdata/src/sync/map.go:102",
+		"tryExpungeLocked": "testdata/testdata/src/sync/map.go:375",
+	}
+	for _, d := range f.Decls {
+		var name string
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			name = d.Name.Name
+		case *ast.GenDecl:
+			if s, ok := d.Specs[0].(*ast.TypeSpec); ok {
+				name = s.Name.Name
+			}
+		}
+		pos := fset.Position(d.Pos())
+		switch {
+		case name == "Len":
+			// Appended code is mapped back to the generated file.
+			if abs := fset.PositionFor(d.Pos(), false); pos.Filename != "map.go" || pos.Line != abs.Line {
+				t.Errorf("unexpected position of Len: %v, want %v", pos, abs)
+			}
+		case want[name] != "":
+			if got := fmt.Sprintf("%s:%d", pos.Filename, pos.Line); got != want[name] {
+				t.Errorf("unexpected position of %s: %s, want %s", name, got, want[name])
+			}
+		}
+	}
+}
+
+// typeCheck type-checks the given files of the main package in dir, and fails the test
+// if they do not compile.
+func typeCheck(t *testing.T, dir string, names ...string) *types.Package {
+	t.Helper()
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range names {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{Importer: importer.Default()}
+	pkg, err := conf.Check("main", fset, files, nil)
+	if err != nil {
+		t.Fatalf("generated code does not compile: %v", err)
+	}
+	return pkg
+}
+
+// checkLoad fails the test if the Load method of the generated Map does not have the
+// given key and value types.
+func checkLoad(t *testing.T, pkg *types.Package, key, value string) {
+	t.Helper()
+	load, _, _ := types.LookupFieldOrMethod(pkg.Scope().Lookup("Map").Type(), true, pkg, "Load")
+	sig := load.Type().(*types.Signature)
+	if k := sig.Params().At(0).Type().String(); k != key {
+		t.Errorf("unexpected key type: %s, want %s", k, key)
+	}
+	if v := sig.Results().At(0).Type().String(); v != value {
+		t.Errorf("unexpected value type: %s, want %s", v, value)
+	}
+}
+
+// pointerLayout returns the source of a map with the layout of Go 1.20 and later, where
+// the read-only map is stored in an atomic.Pointer, after the given method is added to it.
+func pointerLayout(t *testing.T, add func(*Generator)) string {
+	t.Helper()
+	g := &Generator{Config: Config{Name: "Map"}, key: "int", fset: token.NewFileSet()}
+	var err error
+	g.file, err = parser.ParseFile(g.fset, "", `package main
+
+type Map struct {
+	mu     sync.Mutex
+	read   atomic.Pointer[readOnlyMap]
+	dirty  map[int]*entryMap
+	misses int
+}
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(g)
+	b := bytes.NewBuffer(nil)
+	if err := format.Node(b, g.fset, g.file); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
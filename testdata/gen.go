@@ -8,7 +8,7 @@ package main
 
 //go:generate go run github.com/a8m/syncmap -name stringerMap "map[string]interface{ String() string }"
 
-//go:generate go run github.com/a8m/syncmap -name IntMap map[int]int
+//go:generate go run github.com/a8m/syncmap -name IntMap -assert-zero map[int]int
 
 //go:generate go run github.com/a8m/syncmap -name StructMap "map[struct{ Name string }]struct{ Age int }"
 
@@ -17,3 +17,53 @@ package main
 //go:generate go run github.com/a8m/syncmap -name StringByteChan "map[string](chan []byte)"
 
 //go:generate go run github.com/a8m/syncmap -name StringIntChan "map[string](chan int)"
+
+//go:generate go run github.com/a8m/syncmap -name RuneMap -helpers len,keys,values,string,snapshotrange,rangebatch,rangesafe,merge map[rune]byte
+
+//go:generate go run github.com/a8m/syncmap -name ScoreMap -cas -helpers tomap,rangeinto,storeall,json,jsonstream,binary,gob,loadordefault,loadnonzero,loadptr,merge,snapshotrange,rangebatch,rangesafe map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name MetricsMap -metrics map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name IterMap -iter -tags go1.23 map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name InlineMap -valueinline -counter -cas -compact -assert-zero map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name SlabMap -slab -counter -cas -clear -compact -assert-zero map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name KeyMap -keyinline -slab -helpers keys map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name StringSet -set -assert-zero string
+
+//go:generate go run github.com/a8m/syncmap -name IntStringMap -methods intstringmap.tmpl map[int]string
+
+//go:generate go run github.com/a8m/syncmap -name PairMap -chaniter map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name SimpleMap -simple -cas -compact -assert-zero -capacity -metrics -helpers keys map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name ClearMap -clear -compact -helpers len map[int]string
+
+//go:generate go run github.com/a8m/syncmap -name CapacityMap -capacity map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name FrozenMap -readonly-after-init -cas -assert-zero -capacity -metrics -helpers len map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name NestedMap map[string]*IntMap
+
+//go:generate go run github.com/a8m/syncmap -name OrderedMap -ordered -clear -metrics -helpers keys map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name GenericMap -generic -cas -value-constraint comparable -helpers keys map[K]V
+
+//go:generate go run github.com/a8m/syncmap -name TagMap -multimap -clear map[string][]string
+
+//go:generate go run github.com/a8m/syncmap -name CountMap -counter -promotions -clear -compact map[int]int
+
+//go:generate go run github.com/a8m/syncmap -name WaitMap -wait map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name ComputeMap -compute map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name TracedMap -trace -clear -helpers keys map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name LayerMap -parent -metrics map[string]int
+
+//go:generate go run github.com/a8m/syncmap -name DeadlineMap -helpers binary map[int]time.Time
+
+//go:generate go run github.com/a8m/syncmap -name ShardMap -shards 8 -counter -clear -helpers keys map[string]int
@@ -1,8 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestIntMap(t *testing.T) {
@@ -159,3 +173,1221 @@ func TestStringIntChan(t *testing.T) {
 		return true
 	})
 }
+
+func TestRuneMap(t *testing.T) {
+	var m RuneMap
+	m.Store('a', 1)
+	m.Store('b', 2)
+	if n := m.Len(); n != 2 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+	keys := m.Keys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	if !reflect.DeepEqual(keys, []rune{'a', 'b'}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	values := m.Values()
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	if !reflect.DeepEqual(values, []byte{1, 2}) {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	if s := m.String(); s != "map[97:1 98:2]" {
+		t.Fatalf("unexpected string: %s", s)
+	}
+}
+
+func TestScoreMap(t *testing.T) {
+	var m ScoreMap
+	m.Store("a", 1)
+	m.Store("b", 2)
+	b, err := json.Marshal(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"a":1,"b":2}` {
+		t.Fatalf("unexpected json: %s", b)
+	}
+	var j ScoreMap
+	if err := json.Unmarshal(b, &j); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := j.Load("b"); v != 2 {
+		t.Fatalf("unexpected value: %d", v)
+	}
+	w := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(w).Encode(&m); err != nil {
+		t.Fatal(err)
+	}
+	var g ScoreMap
+	if err := gob.NewDecoder(w).Decode(&g); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := g.Load("a"); v != 1 {
+		t.Fatalf("unexpected value: %d", v)
+	}
+}
+
+func TestRuneMapSnapshotRange(t *testing.T) {
+	var m RuneMap
+	m.Store('a', 1)
+	m.Store('b', 2)
+	seen := make(map[rune]byte)
+	m.SnapshotRange(func(key rune, value byte) bool {
+		m.Delete(key)
+		m.Store(key+2, value)
+		seen[key] = value
+		return true
+	})
+	if !reflect.DeepEqual(seen, map[rune]byte{'a': 1, 'b': 2}) {
+		t.Fatalf("unexpected entries: %v", seen)
+	}
+	if n := m.Len(); n != 2 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+}
+
+func TestRuneMapRangeBatch(t *testing.T) {
+	var m RuneMap
+	for i := 0; i < 10; i++ {
+		m.Store(rune('a'+i), byte(i))
+	}
+	seen := make(map[rune]byte)
+	var sizes []int
+	m.RangeBatch(4, func(keys []rune, values []byte) bool {
+		sizes = append(sizes, len(keys))
+		for i, key := range keys {
+			seen[key] = values[i]
+		}
+		return true
+	})
+	if !reflect.DeepEqual(sizes, []int{4, 4, 2}) || len(seen) != 10 {
+		t.Fatalf("unexpected batches: %v, %d entries", sizes, len(seen))
+	}
+	for key, value := range seen {
+		if value != byte(key-'a') {
+			t.Fatalf("unexpected value of %c: %d", key, value)
+		}
+	}
+	calls := 0
+	m.RangeBatch(3, func([]rune, []byte) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Fatalf("range should stop after the first batch: %d calls", calls)
+	}
+	// A batch size larger than the map is not allocated up front.
+	sizes = nil
+	m.RangeBatch(math.MaxInt, func(keys []rune, _ []byte) bool {
+		sizes = append(sizes, len(keys))
+		return true
+	})
+	if !reflect.DeepEqual(sizes, []int{10}) {
+		t.Fatalf("unexpected batches of a large batch size: %v", sizes)
+	}
+}
+
+func TestRuneMapMerge(t *testing.T) {
+	var m, other RuneMap
+	m.Store('a', 1)
+	other.Store('a', 2)
+	other.Store('b', 3)
+	sum := func(existing, incoming byte) byte { return existing + incoming }
+	m.Merge(&other, sum)
+	if s := m.String(); s != "map[97:3 98:3]" {
+		t.Fatalf("unexpected map: %s", s)
+	}
+	// Concurrent merges and stores do not lose each other's values.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				m.Merge(&other, sum)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				var one RuneMap
+				one.Store('c', 1)
+				m.Merge(&one, sum)
+			}
+		}()
+	}
+	wg.Wait()
+	if s := m.String(); s != "map[97:43 98:63 99:20]" {
+		t.Fatalf("unexpected map after concurrent merges: %s", s)
+	}
+}
+
+func TestRuneMapRangeSafe(t *testing.T) {
+	var m RuneMap
+	m.Store('a', 1)
+	if err := m.RangeSafe(func(rune, byte) bool { return true }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := m.RangeSafe(func(rune, byte) bool { panic("boom") })
+	if err == nil || err.Error() != "range: panic: boom" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = m.RangeSafe(func(rune, byte) bool { panic(io.ErrUnexpectedEOF) })
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("error should wrap the panic value: %v", err)
+	}
+}
+
+func TestScoreMapStoreAll(t *testing.T) {
+	var m ScoreMap
+	m.Store("a", 0)
+	m.StoreAll(map[string]int{"a": 1, "b": 2})
+	if s := m.ToMap(); !reflect.DeepEqual(s, map[string]int{"a": 1, "b": 2}) {
+		t.Fatalf("unexpected map: %v", s)
+	}
+}
+
+func TestScoreMapRangeInto(t *testing.T) {
+	var m ScoreMap
+	m.Store("a", 1)
+	m.Store("b", 2)
+	dst := map[string]int{"a": 0, "c": 3}
+	m.RangeInto(dst)
+	if !reflect.DeepEqual(dst, map[string]int{"a": 1, "b": 2, "c": 3}) {
+		t.Fatalf("unexpected map: %v", dst)
+	}
+}
+
+func TestScoreMapWriteJSON(t *testing.T) {
+	var m ScoreMap
+	b := bytes.NewBuffer(nil)
+	if err := m.WriteJSON(b); err != nil || b.String() != "{}" {
+		t.Fatalf("unexpected JSON of the empty map: %q, %v", b, err)
+	}
+	for i := 0; i < 100; i++ {
+		m.Store("key\""+strconv.Itoa(i), i)
+	}
+	b.Reset()
+	if err := m.WriteJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]int
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", b, err)
+	}
+	marshaled, err := m.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(marshaled, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("WriteJSON and MarshalJSON differ: %v, %v", got, want)
+	}
+	if err := m.WriteJSON(errWriter{}); err == nil {
+		t.Fatal("expected the error of the writer")
+	}
+}
+
+// errWriter is an io.Writer that always fails.
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestScoreMapBinary(t *testing.T) {
+	var m, dst ScoreMap
+	for i := 0; i < 100; i++ {
+		m.Store(strconv.Itoa(i), -i)
+	}
+	b := bytes.NewBuffer(nil)
+	if err := m.WriteBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	n := b.Len()
+	if err := dst.ReadBinary(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst.ToMap(), m.ToMap()) {
+		t.Fatalf("unexpected map after ReadBinary: %v", dst.ToMap())
+	}
+	if err := dst.ReadBinary(bytes.NewReader(b.Bytes()[:n-1])); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error of a truncated entry: %v", err)
+	}
+	var d, ddst DeadlineMap
+	d.Store(1, time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC))
+	d.Store(-2, time.Time{})
+	b.Reset()
+	if err := d.WriteBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := ddst.ReadBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []int{1, -2} {
+		want, _ := d.Load(key)
+		if got, ok := ddst.Load(key); !ok || !got.Equal(want) {
+			t.Fatalf("unexpected value of %d after ReadBinary: %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestScoreMapLoadOrDefault(t *testing.T) {
+	var m ScoreMap
+	m.Store("a", 1)
+	if v := m.LoadOrDefault("a", 10); v != 1 {
+		t.Fatalf("unexpected value for existing key: %d", v)
+	}
+	if v := m.LoadOrDefault("b", 10); v != 10 {
+		t.Fatalf("unexpected value for missing key: %d", v)
+	}
+	if _, ok := m.Load("b"); ok {
+		t.Fatal("LoadOrDefault should not store the default value")
+	}
+}
+
+func TestScoreMapLoadNonZero(t *testing.T) {
+	var m ScoreMap
+	m.StoreAll(map[string]int{"a": 1, "b": 0})
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false} {
+		if v, ok := m.LoadNonZero(key); ok != want || (ok && v != 1) {
+			t.Errorf("unexpected load of %s: %v, %v", key, v, ok)
+		}
+	}
+}
+
+func TestScoreMapLoadPtr(t *testing.T) {
+	var m ScoreMap
+	m.Store("a", 1)
+	if p, ok := m.LoadPtr("b"); ok || p != nil {
+		t.Fatalf("unexpected load of a missing key: %v, %v", p, ok)
+	}
+	p, ok := m.LoadPtr("a")
+	if !ok || *p != 1 {
+		t.Fatalf("unexpected load: %v, %v", p, ok)
+	}
+	*p++
+	if v, _ := m.Load("a"); v != 1 {
+		t.Fatalf("the stored value should not change before it is stored back: %d", v)
+	}
+	m.Store("a", *p)
+	if v, _ := m.Load("a"); v != 2 {
+		t.Fatalf("unexpected value after store: %d", v)
+	}
+}
+
+func TestScoreMapMerge(t *testing.T) {
+	var m, other ScoreMap
+	m.StoreAll(map[string]int{"a": 1, "b": 2})
+	other.StoreAll(map[string]int{"b": 3, "c": 4})
+	m.Merge(&other, func(existing, incoming int) int { return existing + incoming })
+	if s := m.ToMap(); !reflect.DeepEqual(s, map[string]int{"a": 1, "b": 5, "c": 4}) {
+		t.Fatalf("unexpected map: %v", s)
+	}
+	if s := other.ToMap(); !reflect.DeepEqual(s, map[string]int{"b": 3, "c": 4}) {
+		t.Fatalf("other map should not change: %v", s)
+	}
+	// Concurrent merges do not lose each other's values.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Merge(&other, func(existing, incoming int) int { return existing + incoming })
+			}
+		}()
+	}
+	wg.Wait()
+	if s := m.ToMap(); !reflect.DeepEqual(s, map[string]int{"a": 1, "b": 5 + 800*3, "c": 4 + 800*4}) {
+		t.Fatalf("unexpected map after concurrent merges: %v", s)
+	}
+}
+
+func TestMetricsMap(t *testing.T) {
+	var m MetricsMap
+	m.Store("a", 1)
+	m.Load("a")
+	m.Load("b")
+	m.LoadOrStore("a", 2)
+	m.LoadOrStore("c", 3)
+	m.Delete("a")
+	m.Delete("b")
+	m.LoadAndDelete("c")
+	want := MetricsMapStats{Loads: 4, Hits: 2, Misses: 2, Stores: 2, Deletes: 2}
+	if s := m.Stats(); s != want {
+		t.Fatalf("unexpected stats: %+v, want %+v", s, want)
+	}
+}
+
+func TestInlineMap(t *testing.T) {
+	var m InlineMap
+	m.Store("a", 1)
+	if v, ok := m.Load("a"); !ok || v != 1 {
+		t.Fatalf("unexpected load: %v, %v", v, ok)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { m.Store("a", 2) }); allocs != 0 {
+		t.Fatalf("store of an existing key should not allocate: %v allocs", allocs)
+	}
+	if v, loaded := m.LoadOrStore("a", 3); !loaded || v != 2 {
+		t.Fatalf("unexpected load or store: %v, %v", v, loaded)
+	}
+	if v, loaded := m.LoadAndDelete("a"); !loaded || v != 2 {
+		t.Fatalf("unexpected load and delete: %v, %v", v, loaded)
+	}
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("key should be deleted")
+	}
+	// Promote the dirty map, expunge the deleted entries, and store them again.
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+		m.Load(strconv.Itoa(i))
+	}
+	for i := 0; i < 10; i++ {
+		m.Delete(strconv.Itoa(i))
+	}
+	m.Store("b", 1)
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	n := 0
+	m.Range(func(key string, value int) bool {
+		n++
+		return true
+	})
+	if n != 11 {
+		t.Fatalf("unexpected number of entries: %d, want 11", n)
+	}
+}
+
+func TestSlabMap(t *testing.T) {
+	var m SlabMap
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	// The slots of the replaced and the deleted values are reused.
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i+10)
+		m.Delete(strconv.Itoa(i))
+		m.Store(strconv.Itoa(i), i+20)
+	}
+	if n := len(m.slab.values); n != 11 {
+		t.Fatalf("unexpected number of slots: %d, want 11", n)
+	}
+	for i := 0; i < 10; i++ {
+		if v, ok := m.Load(strconv.Itoa(i)); !ok || v != i+20 {
+			t.Fatalf("unexpected load of %d: %v, %v", i, v, ok)
+		}
+	}
+	if v, loaded := m.LoadOrStore("0", 30); !loaded || v != 20 {
+		t.Fatalf("unexpected load or store: %v, %v", v, loaded)
+	}
+	if v, loaded := m.LoadAndDelete("0"); !loaded || v != 20 {
+		t.Fatalf("unexpected load and delete: %v, %v", v, loaded)
+	}
+	if v, loaded := m.LoadOrStore("0", 30); loaded || v != 30 {
+		t.Fatalf("unexpected load or store: %v, %v", v, loaded)
+	}
+	m.Clear()
+	if _, ok := m.Load("1"); ok || m.slab != nil {
+		t.Fatal("the map should be empty, and the slab released")
+	}
+	// Concurrent stores, deletes and loads of the same keys reuse the slots while they
+	// are loaded. A load never returns the value of another key.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := j % 16
+				m.Store(strconv.Itoa(key), key)
+				if v, ok := m.Load(strconv.Itoa((key + i) % 16)); ok && v != (key+i)%16 {
+					t.Errorf("unexpected value of %d: %d", (key+i)%16, v)
+					return
+				}
+				m.Delete(strconv.Itoa((key + 1) % 16))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestKeyMap(t *testing.T) {
+	var m KeyMap
+	// Promote the dirty map, expunge the deleted entries, and store them again, so
+	// that the keys are read from entries of both the read-only and the dirty map.
+	for i := 0; i < 10; i++ {
+		m.Store(strconv.Itoa(i), i)
+		m.Load(strconv.Itoa(i))
+	}
+	for i := 0; i < 10; i += 2 {
+		m.Delete(strconv.Itoa(i))
+	}
+	m.Store("a", -1)
+	for i := 0; i < 10; i += 4 {
+		m.Store(strconv.Itoa(i), i)
+	}
+	want := map[string]int{"a": -1}
+	for i := 0; i < 10; i++ {
+		if i%2 == 1 || i%4 == 0 {
+			want[strconv.Itoa(i)] = i
+		}
+	}
+	got := make(map[string]int)
+	m.Range(func(key string, value int) bool {
+		got[key] = value
+		return true
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entries: %v, want %v", got, want)
+	}
+	if keys := m.Keys(); len(keys) != len(want) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestStringSet(t *testing.T) {
+	var s StringSet
+	s.Add("a")
+	s.Add("b")
+	s.Add("a")
+	if !s.Contains("a") || !s.Contains("b") || s.Contains("c") {
+		t.Fatal("unexpected set membership")
+	}
+	s.Remove("a")
+	if s.Contains("a") {
+		t.Fatal("removed key should not be in the set")
+	}
+	s.Add("c")
+	var keys []string
+	s.Range(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"b", "c"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	if n := s.Len(); n != 2 {
+		t.Fatalf("unexpected length: %d, want 2", n)
+	}
+}
+
+// testExpunged exercises the expunged entries of a map, whose keys are derived from i.
+func testExpunged(t *testing.T, store func(i int), load func(i int) bool, del func(i int)) {
+	t.Helper()
+	// Promote the dirty map to the read map by missing on each key.
+	for i := 0; i < 10; i++ {
+		store(i)
+		load(i)
+	}
+	for i := 0; i < 10; i++ {
+		del(i)
+	}
+	// A store of a new key copies the read map to a new dirty map, and marks the
+	// deleted entries as expunged.
+	store(10)
+	for i := 0; i < 10; i++ {
+		if load(i) {
+			t.Fatalf("expunged key %d should not be present", i)
+		}
+		store(i)
+		if !load(i) {
+			t.Fatalf("key %d should be present after being stored again", i)
+		}
+	}
+	if !load(10) {
+		t.Fatal("key 10 should be present")
+	}
+}
+
+func TestExpunged(t *testing.T) {
+	t.Run("int", func(t *testing.T) {
+		var m IntMap
+		testExpunged(t,
+			func(i int) { m.Store(i, i) },
+			func(i int) bool { v, ok := m.Load(i); return ok && v == i },
+			func(i int) { m.Delete(i) },
+		)
+	})
+	t.Run("string", func(t *testing.T) {
+		var m IntStringMap
+		testExpunged(t,
+			func(i int) { m.Store(i, strconv.Itoa(i)) },
+			func(i int) bool { v, ok := m.Load(i); return ok && v == strconv.Itoa(i) },
+			func(i int) { m.Delete(i) },
+		)
+	})
+	t.Run("struct{}", func(t *testing.T) {
+		var s StringSet
+		testExpunged(t,
+			func(i int) { s.Add(strconv.Itoa(i)) },
+			func(i int) bool { return s.Contains(strconv.Itoa(i)) },
+			func(i int) { s.Remove(strconv.Itoa(i)) },
+		)
+	})
+}
+
+func TestPairMapIter(t *testing.T) {
+	var m PairMap
+	want := map[string]int{"a": 1, "b": 2, "c": 3}
+	for k, v := range want {
+		m.Store(k, v)
+	}
+	got := make(map[string]int)
+	for p := range m.Iter() {
+		got[p.Key] = p.Value
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entries: %v, want %v", got, want)
+	}
+}
+
+func TestSimpleMap(t *testing.T) {
+	var m SimpleMap
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("empty map should not contain a")
+	}
+	m.Store("a", 1)
+	if v, loaded := m.LoadOrStore("a", 2); !loaded || v != 1 {
+		t.Fatalf("unexpected load or store: %v, %v", v, loaded)
+	}
+	if v, loaded := m.LoadOrStore("b", 2); loaded || v != 2 {
+		t.Fatalf("unexpected load or store: %v, %v", v, loaded)
+	}
+	// Range may call the map methods without a deadlock.
+	m.Range(func(key string, value int) bool {
+		m.Store(key+key, value)
+		return true
+	})
+	keys := m.Keys()
+	sort.Strings(keys)
+	if want := []string{"a", "aa", "b", "bb"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("unexpected keys: %v, want %v", keys, want)
+	}
+	if v, loaded := m.LoadAndDelete("a"); !loaded || v != 1 {
+		t.Fatalf("unexpected load and delete: %v, %v", v, loaded)
+	}
+	m.Delete("b")
+	want := SimpleMapStats{Loads: 3, Hits: 1, Misses: 2, Stores: 4, Deletes: 2}
+	if s := m.Stats(); s != want {
+		t.Fatalf("unexpected stats: %+v, want %+v", s, want)
+	}
+}
+
+func TestClearMap(t *testing.T) {
+	var m ClearMap
+	m.Clear()
+	for i := 0; i < 10; i++ {
+		m.Store(i, strconv.Itoa(i))
+		// Promote some of the entries to the read map.
+		if i%2 == 0 {
+			m.Load(i)
+		}
+	}
+	m.Clear()
+	if n := m.Len(); n != 0 {
+		t.Fatalf("cleared map should be empty: %d entries", n)
+	}
+	if _, ok := m.Load(0); ok {
+		t.Fatal("cleared map should not contain 0")
+	}
+	m.Store(1, "1")
+	if v, ok := m.Load(1); !ok || v != "1" {
+		t.Fatalf("unexpected load after clear: %v, %v", v, ok)
+	}
+}
+
+func TestCapacityMap(t *testing.T) {
+	m := NewCapacityMapWithCapacity(100)
+	for i := 0; i < 100; i++ {
+		m.Store(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := m.Load(strconv.Itoa(i)); !ok || v != i {
+			t.Fatalf("unexpected load of %d: %v, %v", i, v, ok)
+		}
+	}
+	s := NewSimpleMapWithCapacity(10)
+	s.Store("a", 1)
+	if v, ok := s.Load("a"); !ok || v != 1 {
+		t.Fatalf("unexpected load: %v, %v", v, ok)
+	}
+}
+
+func TestFrozenMap(t *testing.T) {
+	m := NewFrozenMapWithCapacity(2)
+	m.Store("a", 1)
+	m.Store("b", 2)
+	m.Delete("b")
+	if v, loaded := m.LoadOrStore("c", 3); loaded {
+		t.Fatalf("unexpected load: %v", v)
+	}
+	m.Freeze()
+	m.Freeze()
+	if v, ok := m.Load("a"); !ok || v != 1 {
+		t.Fatalf("unexpected load after freeze: %v, %v", v, ok)
+	}
+	if v, loaded := m.LoadOrStore("c", 4); !loaded || v != 3 {
+		t.Fatalf("unexpected load of an existing key after freeze: %v, %v", v, loaded)
+	}
+	if n := m.Len(); n != 2 {
+		t.Fatalf("unexpected length: %d", n)
+	}
+	if s, want := m.Stats(), (FrozenMapStats{Loads: 3, Hits: 2, Misses: 1, Stores: 3, Deletes: 1}); s != want {
+		t.Fatalf("unexpected stats: %+v, want %+v", s, want)
+	}
+	for op, f := range map[string]func(){
+		"Store":          func() { m.Store("d", 4) },
+		"LoadOrStore":    func() { m.LoadOrStore("d", 4) },
+		"LoadAndDelete":  func() { m.LoadAndDelete("a") },
+		"Delete":         func() { m.Delete("a") },
+		"CompareAndSwap": func() { m.CompareAndSwap("a", 1, 2) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != "FrozenMap: write after Freeze" {
+					t.Errorf("unexpected panic of %s after freeze: %v", op, r)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	for name, m := range map[string]interface {
+		Load(string) (int, bool)
+		Store(string, int)
+		CompareAndSwap(string, int, int) bool
+	}{
+		"ScoreMap":  new(ScoreMap),
+		"InlineMap": new(InlineMap),
+		"SlabMap":   new(SlabMap),
+		"SimpleMap": new(SimpleMap),
+	} {
+		if m.CompareAndSwap("a", 0, 1) {
+			t.Fatalf("%s: swapped the value of a missing key", name)
+		}
+		m.Store("a", 0)
+		if m.CompareAndSwap("a", 1, 2) {
+			t.Fatalf("%s: swapped a value that is not equal to old", name)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					for {
+						v, _ := m.Load("a")
+						if m.CompareAndSwap("a", v, v+1) {
+							break
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if v, _ := m.Load("a"); v != 800 {
+			t.Fatalf("%s: unexpected value after concurrent swaps: %d, want 800", name, v)
+		}
+	}
+}
+
+func TestNestedMap(t *testing.T) {
+	var m NestedMap
+	inner, _ := m.LoadOrStore("a", new(IntMap))
+	inner.Store(1, 2)
+	if inner, ok := m.Load("a"); !ok {
+		t.Fatal("expected the inner map to be present")
+	} else if v, ok := inner.Load(1); !ok || v != 2 {
+		t.Fatalf("unexpected load from the inner map: %v, %v", v, ok)
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+	var m OrderedMap
+	for i, key := range []string{"c", "a", "d", "b"} {
+		m.Store(key, i)
+	}
+	m.Store("a", 10)
+	m.Delete("d")
+	if _, loaded := m.LoadOrStore("d", 20); loaded {
+		t.Fatal("d should be stored")
+	}
+	if _, loaded := m.LoadAndDelete("c"); !loaded {
+		t.Fatal("c should be deleted")
+	}
+	var keys []string
+	var values []int
+	m.RangeOrdered(func(key string, value int) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true
+	})
+	if want := []string{"a", "b", "d"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("unexpected order: %v, want %v", keys, want)
+	}
+	if want := []int{10, 3, 20}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("unexpected values: %v, want %v", values, want)
+	}
+	// RangeOrdered loads the values of the keys.
+	if s, want := m.Stats(), (OrderedMapStats{Loads: 4, Hits: 3, Misses: 1, Stores: 6, Deletes: 2}); s != want {
+		t.Fatalf("unexpected stats: %+v, want %+v", s, want)
+	}
+	m.Clear()
+	m.Store("e", 1)
+	keys = keys[:0]
+	m.RangeOrdered(func(key string, _ int) bool {
+		keys = append(keys, key)
+		m.Delete(key)
+		return true
+	})
+	if want := []string{"e"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("unexpected order after clear: %v, want %v", keys, want)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := strconv.Itoa(i*100 + j)
+				m.Store(key, j)
+				if j%2 == 0 {
+					m.Delete(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	n := 0
+	m.RangeOrdered(func(string, int) bool {
+		n++
+		return true
+	})
+	if n != 400 || len(m.Keys()) != 400 {
+		t.Fatalf("unexpected number of keys: %d, want 400", n)
+	}
+}
+
+func TestClearMapCompact(t *testing.T) {
+	var m ClearMap
+	m.Compact()
+	for i := 0; i < 1000; i++ {
+		m.Store(i, strconv.Itoa(i))
+	}
+	for i := 0; i < 990; i++ {
+		m.Delete(i)
+	}
+	// Promote the dirty map to the read-only map, and expunge the deleted entries in a
+	// new dirty map.
+	for i := 0; i < 20; i++ {
+		m.Load(-1)
+	}
+	m.Store(1000, "1000")
+	m.Delete(995)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			m.Store(2000+i%10, "x")
+			m.Delete(3000 + i%10)
+		}
+	}()
+	m.Compact()
+	wg.Wait()
+	m.Compact()
+	for i := 990; i <= 1000; i++ {
+		if _, ok := m.Load(i); ok != (i != 995) {
+			t.Fatalf("unexpected load of %d after compact: %v", i, ok)
+		}
+	}
+	if n := m.Len(); n != 20 {
+		t.Fatalf("unexpected length after compact: %d, want 20", n)
+	}
+	for name, m := range map[string]interface {
+		Load(string) (int, bool)
+		Store(string, int)
+		Delete(string)
+		Compact()
+	}{
+		"InlineMap": new(InlineMap),
+		"SlabMap":   new(SlabMap),
+		"SimpleMap": new(SimpleMap),
+	} {
+		m.Compact()
+		m.Store("a", 1)
+		m.Store("b", 2)
+		m.Delete("a")
+		m.Compact()
+		if _, ok := m.Load("a"); ok {
+			t.Fatalf("%s: deleted key found after compact", name)
+		}
+		if v, ok := m.Load("b"); !ok || v != 2 {
+			t.Fatalf("%s: unexpected load after compact: %v, %v", name, v, ok)
+		}
+	}
+}
+
+func TestIntStringMapMethods(t *testing.T) {
+	var m IntStringMap
+	m.Store(1, "a")
+	if !m.Has(1) || m.Has(2) {
+		t.Fatal("unexpected result of the custom Has method")
+	}
+}
+
+func TestGenericMap(t *testing.T) {
+	var m GenericMap[string, int]
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("the zero GenericMap should be empty")
+	}
+	m.Store("a", 1)
+	if v, loaded := m.LoadOrStore("b", 2); loaded || v != 2 {
+		t.Fatalf("unexpected result of LoadOrStore: %d, %t", v, loaded)
+	}
+	if !m.CompareAndSwap("a", 1, 3) || m.CompareAndSwap("a", 1, 4) {
+		t.Fatal("unexpected result of CompareAndSwap")
+	}
+	if v, ok := m.LoadAndDelete("a"); !ok || v != 3 {
+		t.Fatalf("unexpected result of LoadAndDelete: %d, %t", v, ok)
+	}
+	if keys := m.Keys(); !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	// Another instantiation of the same map.
+	var p GenericMap[int, *int]
+	if v, ok := p.Load(1); ok || v != nil {
+		t.Fatalf("unexpected result of Load: %v, %t", v, ok)
+	}
+}
+
+func TestTagMap(t *testing.T) {
+	var m TagMap
+	if s := m.Get("a"); s != nil {
+		t.Fatalf("unexpected slice of a missing key: %v", s)
+	}
+	m.Append("a", "x")
+	loaded := m.Get("a")
+	m.Append("a", "y", "z")
+	if s := m.Get("a"); !reflect.DeepEqual(s, []string{"x", "y", "z"}) {
+		t.Fatalf("unexpected slice: %v", s)
+	}
+	// The slices are copied on write.
+	if !reflect.DeepEqual(loaded, []string{"x"}) {
+		t.Fatalf("the loaded slice should not be modified: %v", loaded)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.Append("b", strconv.Itoa(i))
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := len(m.Get("b")); n != 800 {
+		t.Fatalf("concurrent appends were lost: %d values, want 800", n)
+	}
+	m.Store("a", nil)
+	m.Delete("b")
+	m.Clear()
+	if _, ok := m.Load("a"); ok {
+		t.Fatal("the map should be empty after Clear")
+	}
+}
+
+func TestCountMap(t *testing.T) {
+	var m CountMap
+	if n := m.Len(); n != 0 {
+		t.Fatalf("unexpected length of the zero map: %d", n)
+	}
+	m.Store(1, 1)
+	m.Store(1, 2)
+	m.LoadOrStore(1, 3)
+	m.LoadOrStore(2, 2)
+	m.Delete(3)
+	if n := m.Len(); n != 2 {
+		t.Fatalf("unexpected length: %d, want 2", n)
+	}
+	m.Clear()
+	if n := m.Len(); n != 0 {
+		t.Fatalf("unexpected length after Clear: %d", n)
+	}
+	for name, m := range map[string]interface {
+		Store(string, int)
+		LoadOrStore(string, int) (int, bool)
+		LoadAndDelete(string) (int, bool)
+		Delete(string)
+		Range(func(string, int) bool)
+		Len() int
+	}{
+		"InlineMap": new(InlineMap),
+		"SlabMap":   new(SlabMap),
+	} {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 2000; j++ {
+					// Few keys, in order to update and delete the same entries
+					// concurrently, and to promote and rebuild the dirty map.
+					key := strconv.Itoa((i + j) % 16)
+					switch j % 4 {
+					case 0:
+						m.Store(key, j)
+					case 1:
+						m.LoadOrStore(key, j)
+					case 2:
+						m.LoadAndDelete(key)
+					case 3:
+						m.Delete(key)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		n := 0
+		m.Range(func(string, int) bool {
+			n++
+			return true
+		})
+		if l := m.Len(); l != n {
+			t.Fatalf("%s: unexpected length: %d, want %d", name, l, n)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		m.Store(i, i)
+	}
+	for i := 0; i < 50; i++ {
+		m.Delete(i)
+	}
+	m.Compact()
+	if n := m.Len(); n != 50 {
+		t.Fatalf("unexpected length after Compact: %d, want 50", n)
+	}
+}
+
+func TestCountMapPromotions(t *testing.T) {
+	var m CountMap
+	for i := 0; i < 3; i++ {
+		m.Store(i, i)
+	}
+	// The new keys are in the dirty map, and the dirty map is promoted after 3 misses.
+	for i := 0; i < 2; i++ {
+		m.Load(i)
+	}
+	if n, misses := m.PromotionStats(); n != 0 || misses != 2 {
+		t.Fatalf("unexpected promotion stats before the threshold: %d, %d", n, misses)
+	}
+	m.Load(2)
+	if n, misses := m.PromotionStats(); n != 1 || misses != 0 {
+		t.Fatalf("unexpected promotion stats after the threshold: %d, %d", n, misses)
+	}
+	// The promoted keys are loaded without misses.
+	m.Load(0)
+	if n, misses := m.PromotionStats(); n != 1 || misses != 0 {
+		t.Fatalf("unexpected promotion stats after a hit: %d, %d", n, misses)
+	}
+}
+
+func TestWaitMap(t *testing.T) {
+	var m WaitMap
+	m.Store("a", 1)
+	if v, err := m.LoadOrWait(context.Background(), "a"); err != nil || v != 1 {
+		t.Fatalf("unexpected result of LoadOrWait of a present key: %d, %v", v, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.LoadOrWait(ctx, "b"); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error of LoadOrWait of a missing key: %v", err)
+	}
+	if n := len(m.waiters); n != 0 || m.nwaiters != 0 {
+		t.Fatalf("the waiters of a done context should be removed: %d keys, %d waiters", n, m.nwaiters)
+	}
+	var wg sync.WaitGroup
+	values := make([]int, 8)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := m.LoadOrWait(context.Background(), strconv.Itoa(i%2))
+			if err != nil {
+				t.Error(err)
+			}
+			values[i] = v
+		}(i)
+	}
+	m.Store("0", 10)
+	m.LoadOrStore("1", 11)
+	wg.Wait()
+	for i, v := range values {
+		if v != 10+i%2 {
+			t.Fatalf("unexpected value of waiter %d: %d", i, v)
+		}
+	}
+}
+
+func TestComputeMap(t *testing.T) {
+	var (
+		m     ComputeMap
+		calls int32
+		wg    sync.WaitGroup
+	)
+	release := make(chan struct{})
+	compute := func(key string) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return len(key), nil
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := m.LoadOrCompute("abc", compute); err != nil || v != 3 {
+				t.Errorf("unexpected result of LoadOrCompute: %d, %v", v, err)
+			}
+		}()
+	}
+	// Let the calls miss the key before compute returns.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("compute was called %d times, want 1", calls)
+	}
+	errCompute := errors.New("compute failed")
+	if _, err := m.LoadOrCompute("x", func(string) (int, error) { return 0, errCompute }); err != errCompute {
+		t.Fatalf("unexpected error of LoadOrCompute: %v", err)
+	}
+	if _, ok := m.Load("x"); ok {
+		t.Fatal("the value of a failed compute should not be stored")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("the panic of compute should be propagated")
+			}
+		}()
+		m.LoadOrCompute("x", func(string) (int, error) { panic("compute panicked") })
+	}()
+	if v, err := m.LoadOrCompute("x", func(string) (int, error) { return 1, nil }); err != nil || v != 1 {
+		t.Fatalf("unexpected result of LoadOrCompute after a panic: %d, %v", v, err)
+	}
+	if len(m.computing) != 0 {
+		t.Fatalf("the completed calls should be removed: %d", len(m.computing))
+	}
+}
+
+func TestTracedMap(t *testing.T) {
+	var (
+		m   TracedMap
+		ops []string
+	)
+	m.Store("a", 1)
+	TracedMapTrace = func(op string, key interface{}) {
+		ops = append(ops, fmt.Sprintf("%s(%v)", op, key))
+	}
+	defer func() { TracedMapTrace = nil }()
+	m.Load("a")
+	m.Delete("b")
+	m.Keys()
+	m.Clear()
+	want := []string{"Load(a)", "Delete(b)", "LoadAndDelete(b)", "Range(<nil>)", "Clear(<nil>)"}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("unexpected traced operations: %v, want %v", ops, want)
+	}
+}
+
+func TestLayerMap(t *testing.T) {
+	var defaults LayerMap
+	defaults.Store("a", 1)
+	defaults.Store("b", 2)
+	m := NewLayerMapWithParent(&defaults)
+	if v, ok := m.Load("a"); !ok || v != 1 {
+		t.Fatalf("unexpected load of an inherited key: %d, %t", v, ok)
+	}
+	m.Store("a", 10)
+	if v, _ := m.Load("a"); v != 10 {
+		t.Fatalf("unexpected load of an overridden key: %d", v)
+	}
+	if v, _ := defaults.Load("a"); v != 1 {
+		t.Fatalf("the parent should not be modified: %d", v)
+	}
+	if v, loaded := m.LoadOrStore("b", 20); !loaded || v != 2 {
+		t.Fatalf("unexpected LoadOrStore of an inherited key: %d, %t", v, loaded)
+	}
+	if v, loaded := m.LoadOrStore("c", 30); loaded || v != 30 {
+		t.Fatalf("unexpected LoadOrStore of a new key: %d, %t", v, loaded)
+	}
+	if _, ok := defaults.Load("c"); ok {
+		t.Fatal("the parent should not be modified by LoadOrStore")
+	}
+	m.Delete("a")
+	if v, _ := m.Load("a"); v != 1 {
+		t.Fatalf("the parent value should be loaded after a local delete: %d", v)
+	}
+	grandchild := NewLayerMapWithParent(m)
+	if v, _ := grandchild.Load("c"); v != 30 {
+		t.Fatalf("unexpected load of a key of the parent: %d", v)
+	}
+	if v, _ := grandchild.Load("b"); v != 2 {
+		t.Fatalf("unexpected load of a key of the grandparent: %d", v)
+	}
+	if s := grandchild.Stats(); s.Hits != 2 || s.Misses != 0 {
+		t.Fatalf("the loads served by the parents should be hits: %+v", s)
+	}
+}
+
+func TestShardMap(t *testing.T) {
+	var m ShardMap
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := strconv.Itoa(i*100 + j)
+				m.Store(key, j)
+				if v, ok := m.Load(key); !ok || v != j {
+					t.Errorf("unexpected value of %s: %d, %v", key, v, ok)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := m.Len(); n != 800 {
+		t.Fatalf("unexpected length: %d, want 800", n)
+	}
+	if n := len(m.Keys()); n != 800 {
+		t.Fatalf("unexpected number of keys: %d, want 800", n)
+	}
+	var used int
+	for i := range m.shards {
+		if m.shards[i].Len() > 0 {
+			used++
+		}
+	}
+	if used != len(m.shards) {
+		t.Errorf("the keys should be distributed among all the shards: %d of %d are used", used, len(m.shards))
+	}
+	var visited int
+	m.Range(func(string, int) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Errorf("Range should stop when f returns false: %d visited", visited)
+	}
+	m.Clear()
+	if n := m.Len(); n != 0 {
+		t.Fatalf("unexpected length after Clear: %d", n)
+	}
+}
@@ -1,4 +1,5 @@
 // Code generated by syncmap; DO NOT EDIT.
+// syncmap -name IntMap -assert-zero map[int]int
 
 // Copyright 2016 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
@@ -12,21 +13,21 @@ import (
 	"unsafe"
 )
 
-// Map is like a Go map[interface{}]interface{} but is safe for concurrent use
+// IntMap is like a Go map[int]int but is safe for concurrent use
 // by multiple goroutines without additional locking or coordination.
 // Loads, stores, and deletes run in amortized constant time.
 //
-// The Map type is specialized. Most code should use a plain Go map instead,
+// The IntMap type is specialized. Most code should use a plain Go map instead,
 // with separate locking or coordination, for better type safety and to make it
 // easier to maintain other invariants along with the map content.
 //
-// The Map type is optimized for two common use cases: (1) when the entry for a given
+// The IntMap type is optimized for two common use cases: (1) when the entry for a given
 // key is only ever written once but read many times, as in caches that only grow,
 // or (2) when multiple goroutines read, write, and overwrite entries for disjoint
-// sets of keys. In these two cases, use of a Map may significantly reduce lock
+// sets of keys. In these two cases, use of an IntMap may significantly reduce lock
 // contention compared to a Go map paired with a separate Mutex or RWMutex.
 //
-// The zero Map is empty and ready for use. A Map must not be copied after first use.
+// The zero IntMap is empty and ready for use. An IntMap must not be copied after first use.
 type IntMap struct {
 	mu sync.Mutex
 
@@ -70,7 +71,11 @@ type readOnlyIntMap struct {
 
 // expunged is an arbitrary pointer that marks entries which have been deleted
 // from the dirty map.
-var expungedIntMap = unsafe.Pointer(new(int))
+//
+// It is only compared by pointer identity with the entry pointers, and never
+// dereferenced. It points to a byte rather than to a value of the map, as values
+// of zero size (e.g. struct{}) may share the same address.
+var expungedIntMap = unsafe.Pointer(new(byte))
 
 // An entry is a slot in the map corresponding to a particular key.
 type entryIntMap struct {
@@ -122,7 +127,7 @@ func (m *IntMap) Load(key int) (value int, ok bool) {
 		m.mu.Unlock()
 	}
 	if !ok {
-		return value, false
+		return zeroValueIntMap, false
 	}
 	return e.load()
 }
@@ -130,7 +135,7 @@ func (m *IntMap) Load(key int) (value int, ok bool) {
 func (e *entryIntMap) load() (value int, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == nil || p == expungedIntMap {
-		return value, false
+		return zeroValueIntMap, false
 	}
 	return *(*int)(p), true
 }
@@ -242,7 +247,7 @@ func (m *IntMap) LoadOrStore(key, value int) (actual int, loaded bool) {
 func (e *entryIntMap) tryLoadOrStore(i int) (actual int, loaded, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == expungedIntMap {
-		return actual, false, false
+		return zeroValueIntMap, false, false
 	}
 	if p != nil {
 		return *(*int)(p), true, true
@@ -258,7 +263,7 @@ func (e *entryIntMap) tryLoadOrStore(i int) (actual int, loaded, ok bool) {
 		}
 		p = atomic.LoadPointer(&e.p)
 		if p == expungedIntMap {
-			return actual, false, false
+			return zeroValueIntMap, false, false
 		}
 		if p != nil {
 			return *(*int)(p), true, true
@@ -288,7 +293,7 @@ func (m *IntMap) LoadAndDelete(key int) (value int, loaded bool) {
 	if ok {
 		return e.delete()
 	}
-	return value, false
+	return zeroValueIntMap, false
 }
 
 // Delete deletes the value for a key.
@@ -300,7 +305,7 @@ func (e *entryIntMap) delete() (value int, ok bool) {
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expungedIntMap {
-			return value, false
+			return zeroValueIntMap, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
 			return *(*int)(p), true
@@ -318,6 +323,9 @@ func (e *entryIntMap) delete() (value int, ok bool) {
 //
 // Range may be O(N) with the number of elements in the map even if f returns
 // false after a constant number of calls.
+//
+// Range does not block other methods on the receiver; even f itself may call any
+// method on m.
 func (m *IntMap) Range(f func(key, value int) bool) {
 	// We need to be able to iterate over all of the keys that were already
 	// present at the start of the call to Range.
@@ -385,3 +393,7 @@ func (e *entryIntMap) tryExpungeLocked() (isExpunged bool) {
 	}
 	return p == expungedIntMap
 }
+
+// zeroValueIntMap is the zero value of the IntMap values, returned when no value is present.
+// It must not be modified.
+var zeroValueIntMap int
@@ -0,0 +1,41 @@
+//go:build go1.23
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIterMap(t *testing.T) {
+	var m IterMap
+	want := map[string]int{"a": 1, "b": 2, "c": 3}
+	for k, v := range want {
+		m.Store(k, v)
+	}
+	got := make(map[string]int)
+	for k, v := range m.All() {
+		got[k] = v
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected entries: %v, want %v", got, want)
+	}
+	n := 0
+	for range m.All() {
+		if n++; n == 2 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Fatalf("range should stop after break: %d iterations", n)
+	}
+}
+
+func TestIterMapMutateDuringAll(t *testing.T) {
+	var m IterMap
+	testMutateDuringRange(t, &m, func(f func(string, int) bool) {
+		for k, v := range m.All() {
+			f(k, v)
+		}
+	})
+}
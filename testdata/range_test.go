@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rangeMap is the interface of the generated maps of string keys and int values that are
+// mutated during their iteration by TestMutateDuringRange.
+type rangeMap interface {
+	Load(key string) (int, bool)
+	Store(key string, value int)
+	Delete(key string)
+	Range(f func(key string, value int) bool)
+}
+
+// syncMap adapts sync.Map to rangeMap, as the reference of the semantics of the generated maps.
+type syncMap struct{ m sync.Map }
+
+func (m *syncMap) Load(key string) (int, bool) {
+	v, ok := m.m.Load(key)
+	if !ok {
+		return 0, false
+	}
+	return v.(int), true
+}
+
+func (m *syncMap) Store(key string, value int) { m.m.Store(key, value) }
+func (m *syncMap) Delete(key string)           { m.m.Delete(key) }
+
+func (m *syncMap) Range(f func(key string, value int) bool) {
+	m.m.Range(func(key, value interface{}) bool { return f(key.(string), value.(int)) })
+}
+
+// TestMutateDuringRange checks that the iteration methods of the generated maps have the
+// semantics of the Range method of sync.Map when f stores and deletes keys: the iteration
+// does not deadlock, no key is visited more than once, the keys that are not changed are
+// visited with their values, and the changed keys are visited (if at all) with a value they
+// had during the iteration.
+func TestMutateDuringRange(t *testing.T) {
+	var (
+		score   ScoreMap
+		ordered OrderedMap
+		pairs   PairMap
+	)
+	tests := []struct {
+		name   string
+		m      rangeMap
+		rangeF func(f func(key string, value int) bool)
+	}{
+		{name: "sync.Map", m: &syncMap{}},
+		{name: "ScoreMap", m: &ScoreMap{}},
+		{name: "MetricsMap", m: &MetricsMap{}},
+		{name: "InlineMap", m: &InlineMap{}},
+		{name: "SlabMap", m: &SlabMap{}},
+		{name: "KeyMap", m: &KeyMap{}},
+		{name: "CapacityMap", m: NewCapacityMapWithCapacity(8)},
+		{name: "SimpleMap", m: &SimpleMap{}},
+		{name: "FrozenMap", m: &FrozenMap{}},
+		{name: "OrderedMap", m: &OrderedMap{}},
+		{name: "GenericMap", m: &GenericMap[string, int]{}},
+		{name: "OrderedMap.RangeOrdered", m: &ordered, rangeF: ordered.RangeOrdered},
+		{name: "ScoreMap.SnapshotRange", m: &score, rangeF: score.SnapshotRange},
+		{name: "ScoreMap.RangeSafe", m: &score, rangeF: func(f func(string, int) bool) {
+			if err := score.RangeSafe(f); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}},
+		{name: "ScoreMap.RangeBatch", m: &score, rangeF: func(f func(string, int) bool) {
+			score.RangeBatch(3, func(keys []string, values []int) bool {
+				for i := range keys {
+					if !f(keys[i], values[i]) {
+						return false
+					}
+				}
+				return true
+			})
+		}},
+		{name: "PairMap.Iter", m: &pairs, rangeF: func(f func(string, int) bool) {
+			for p := range pairs.Iter() {
+				f(p.Key, p.Value)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rangeF := tt.rangeF
+			if rangeF == nil {
+				rangeF = tt.m.Range
+			}
+			testMutateDuringRange(t, tt.m, rangeF)
+		})
+	}
+}
+
+func testMutateDuringRange(t *testing.T, m rangeMap, rangeF func(f func(key string, value int) bool)) {
+	t.Helper()
+	const n = 10
+	for i := 0; i < n; i++ {
+		m.Store("key-"+strconv.Itoa(i), i)
+		m.Store("deleted-"+strconv.Itoa(i), i)
+	}
+	// Promote the stored keys to the read-only map of the maps that have one, so that the
+	// stores and deletes of f are not all made on the dirty map.
+	for i := 0; i < n; i++ {
+		m.Load("key-" + strconv.Itoa(i))
+	}
+	seen := make(map[string]int)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rangeF(func(key string, value int) bool {
+			if _, ok := seen[key]; ok {
+				t.Errorf("key %s was visited more than once", key)
+			}
+			seen[key] = value
+			if len(seen) == 1 {
+				for i := 0; i < n; i++ {
+					m.Delete("deleted-" + strconv.Itoa(i))
+				}
+			}
+			if strings.HasPrefix(key, "key-") {
+				m.Store(key, value+n)
+				m.Store("added-"+key, -value)
+			}
+			// Nested iterations do not block either.
+			m.Range(func(string, int) bool { return false })
+			return true
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the iteration is blocked")
+	}
+	for i := 0; i < n; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if value, ok := seen[key]; !ok || value != i {
+			t.Errorf("key %s should be visited with its value %d: %d, %t", key, i, value, ok)
+		}
+		if value, ok := m.Load(key); !ok || value != i+n {
+			t.Errorf("key %s should be stored with the value %d: %d, %t", key, i+n, value, ok)
+		}
+		if value, ok := m.Load("added-" + key); !ok || value != -i {
+			t.Errorf("key added-%s should be stored with the value %d: %d, %t", key, -i, value, ok)
+		}
+		if _, ok := m.Load("deleted-" + strconv.Itoa(i)); ok {
+			t.Errorf("key deleted-%d should be deleted", i)
+		}
+	}
+	// The deleted and the added keys may or may not be visited, but not with other values.
+	for key, value := range seen {
+		var want int
+		switch {
+		case strings.HasPrefix(key, "deleted-"):
+			want, _ = strconv.Atoi(strings.TrimPrefix(key, "deleted-"))
+		case strings.HasPrefix(key, "added-key-"):
+			want, _ = strconv.Atoi(strings.TrimPrefix(key, "added-key-"))
+			want = -want
+		default:
+			continue
+		}
+		if value != want {
+			t.Errorf("key %s was visited with an unexpected value: %d, want %d", key, value, want)
+		}
+	}
+}
@@ -1,4 +1,5 @@
 // Code generated by syncmap; DO NOT EDIT.
+// syncmap -name Requests map[string]*http.Request
 
 // Copyright 2016 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
@@ -13,21 +14,21 @@ import (
 	"unsafe"
 )
 
-// Map is like a Go map[interface{}]interface{} but is safe for concurrent use
+// Requests is like a Go map[string]*http.Request but is safe for concurrent use
 // by multiple goroutines without additional locking or coordination.
 // Loads, stores, and deletes run in amortized constant time.
 //
-// The Map type is specialized. Most code should use a plain Go map instead,
+// The Requests type is specialized. Most code should use a plain Go map instead,
 // with separate locking or coordination, for better type safety and to make it
 // easier to maintain other invariants along with the map content.
 //
-// The Map type is optimized for two common use cases: (1) when the entry for a given
+// The Requests type is optimized for two common use cases: (1) when the entry for a given
 // key is only ever written once but read many times, as in caches that only grow,
 // or (2) when multiple goroutines read, write, and overwrite entries for disjoint
-// sets of keys. In these two cases, use of a Map may significantly reduce lock
+// sets of keys. In these two cases, use of a Requests may significantly reduce lock
 // contention compared to a Go map paired with a separate Mutex or RWMutex.
 //
-// The zero Map is empty and ready for use. A Map must not be copied after first use.
+// The zero Requests is empty and ready for use. A Requests must not be copied after first use.
 type Requests struct {
 	mu sync.Mutex
 
@@ -71,7 +72,11 @@ type readOnlyRequests struct {
 
 // expunged is an arbitrary pointer that marks entries which have been deleted
 // from the dirty map.
-var expungedRequests = unsafe.Pointer(new(*http.Request))
+//
+// It is only compared by pointer identity with the entry pointers, and never
+// dereferenced. It points to a byte rather than to a value of the map, as values
+// of zero size (e.g. struct{}) may share the same address.
+var expungedRequests = unsafe.Pointer(new(byte))
 
 // An entry is a slot in the map corresponding to a particular key.
 type entryRequests struct {
@@ -123,7 +128,7 @@ func (m *Requests) Load(key string) (value *http.Request, ok bool) {
 		m.mu.Unlock()
 	}
 	if !ok {
-		return value, false
+		return zeroValueRequests, false
 	}
 	return e.load()
 }
@@ -131,7 +136,7 @@ func (m *Requests) Load(key string) (value *http.Request, ok bool) {
 func (e *entryRequests) load() (value *http.Request, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == nil || p == expungedRequests {
-		return value, false
+		return zeroValueRequests, false
 	}
 	return *(**http.Request)(p), true
 }
@@ -243,7 +248,7 @@ func (m *Requests) LoadOrStore(key string, value *http.Request) (actual *http.Re
 func (e *entryRequests) tryLoadOrStore(i *http.Request) (actual *http.Request, loaded, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == expungedRequests {
-		return actual, false, false
+		return zeroValueRequests, false, false
 	}
 	if p != nil {
 		return *(**http.Request)(p), true, true
@@ -259,7 +264,7 @@ func (e *entryRequests) tryLoadOrStore(i *http.Request) (actual *http.Request, l
 		}
 		p = atomic.LoadPointer(&e.p)
 		if p == expungedRequests {
-			return actual, false, false
+			return zeroValueRequests, false, false
 		}
 		if p != nil {
 			return *(**http.Request)(p), true, true
@@ -289,7 +294,7 @@ func (m *Requests) LoadAndDelete(key string) (value *http.Request, loaded bool)
 	if ok {
 		return e.delete()
 	}
-	return value, false
+	return zeroValueRequests, false
 }
 
 // Delete deletes the value for a key.
@@ -301,7 +306,7 @@ func (e *entryRequests) delete() (value *http.Request, ok bool) {
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expungedRequests {
-			return value, false
+			return zeroValueRequests, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
 			return *(**http.Request)(p), true
@@ -319,6 +324,9 @@ func (e *entryRequests) delete() (value *http.Request, ok bool) {
 //
 // Range may be O(N) with the number of elements in the map even if f returns
 // false after a constant number of calls.
+//
+// Range does not block other methods on the receiver; even f itself may call any
+// method on m.
 func (m *Requests) Range(f func(key string, value *http.Request) bool) {
 	// We need to be able to iterate over all of the keys that were already
 	// present at the start of the call to Range.
@@ -386,3 +394,7 @@ func (e *entryRequests) tryExpungeLocked() (isExpunged bool) {
 	}
 	return p == expungedRequests
 }
+
+// zeroValueRequests is the zero value of the Requests values, returned when no value is present.
+// It must not be modified.
+var zeroValueRequests *http.Request
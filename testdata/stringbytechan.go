@@ -1,4 +1,5 @@
 // Code generated by syncmap; DO NOT EDIT.
+// syncmap -name StringByteChan "map[string](chan []byte)"
 
 // Copyright 2016 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
@@ -12,21 +13,21 @@ import (
 	"unsafe"
 )
 
-// Map is like a Go map[interface{}]interface{} but is safe for concurrent use
+// StringByteChan is like a Go map[string](chan []byte) but is safe for concurrent use
 // by multiple goroutines without additional locking or coordination.
 // Loads, stores, and deletes run in amortized constant time.
 //
-// The Map type is specialized. Most code should use a plain Go map instead,
+// The StringByteChan type is specialized. Most code should use a plain Go map instead,
 // with separate locking or coordination, for better type safety and to make it
 // easier to maintain other invariants along with the map content.
 //
-// The Map type is optimized for two common use cases: (1) when the entry for a given
+// The StringByteChan type is optimized for two common use cases: (1) when the entry for a given
 // key is only ever written once but read many times, as in caches that only grow,
 // or (2) when multiple goroutines read, write, and overwrite entries for disjoint
-// sets of keys. In these two cases, use of a Map may significantly reduce lock
+// sets of keys. In these two cases, use of a StringByteChan may significantly reduce lock
 // contention compared to a Go map paired with a separate Mutex or RWMutex.
 //
-// The zero Map is empty and ready for use. A Map must not be copied after first use.
+// The zero StringByteChan is empty and ready for use. A StringByteChan must not be copied after first use.
 type StringByteChan struct {
 	mu sync.Mutex
 
@@ -70,7 +71,11 @@ type readOnlyStringByteChan struct {
 
 // expunged is an arbitrary pointer that marks entries which have been deleted
 // from the dirty map.
-var expungedStringByteChan = unsafe.Pointer(new((chan []byte)))
+//
+// It is only compared by pointer identity with the entry pointers, and never
+// dereferenced. It points to a byte rather than to a value of the map, as values
+// of zero size (e.g. struct{}) may share the same address.
+var expungedStringByteChan = unsafe.Pointer(new(byte))
 
 // An entry is a slot in the map corresponding to a particular key.
 type entryStringByteChan struct {
@@ -122,7 +127,7 @@ func (m *StringByteChan) Load(key string) (value chan []byte, ok bool) {
 		m.mu.Unlock()
 	}
 	if !ok {
-		return value, false
+		return zeroValueStringByteChan, false
 	}
 	return e.load()
 }
@@ -130,7 +135,7 @@ func (m *StringByteChan) Load(key string) (value chan []byte, ok bool) {
 func (e *entryStringByteChan) load() (value chan []byte, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == nil || p == expungedStringByteChan {
-		return value, false
+		return zeroValueStringByteChan, false
 	}
 	return *(*(chan []byte))(p), true
 }
@@ -246,7 +251,7 @@ func (m *StringByteChan) LoadOrStore(key string, value chan []byte) (actual chan
 func (e *entryStringByteChan) tryLoadOrStore(i chan []byte) (actual chan []byte, loaded, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == expungedStringByteChan {
-		return actual, false, false
+		return zeroValueStringByteChan, false, false
 	}
 	if p != nil {
 		return *(*(chan []byte))(p), true, true
@@ -262,7 +267,7 @@ func (e *entryStringByteChan) tryLoadOrStore(i chan []byte) (actual chan []byte,
 		}
 		p = atomic.LoadPointer(&e.p)
 		if p == expungedStringByteChan {
-			return actual, false, false
+			return zeroValueStringByteChan, false, false
 		}
 		if p != nil {
 			return *(*(chan []byte))(p), true, true
@@ -292,7 +297,7 @@ func (m *StringByteChan) LoadAndDelete(key string) (value chan []byte, loaded bo
 	if ok {
 		return e.delete()
 	}
-	return value, false
+	return zeroValueStringByteChan, false
 }
 
 // Delete deletes the value for a key.
@@ -304,7 +309,7 @@ func (e *entryStringByteChan) delete() (value chan []byte, ok bool) {
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expungedStringByteChan {
-			return value, false
+			return zeroValueStringByteChan, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
 			return *(*(chan []byte))(p), true
@@ -322,6 +327,9 @@ func (e *entryStringByteChan) delete() (value chan []byte, ok bool) {
 //
 // Range may be O(N) with the number of elements in the map even if f returns
 // false after a constant number of calls.
+//
+// Range does not block other methods on the receiver; even f itself may call any
+// method on m.
 func (m *StringByteChan) Range(f func(key string, value chan []byte) bool) {
 	// We need to be able to iterate over all of the keys that were already
 	// present at the start of the call to Range.
@@ -389,3 +397,7 @@ func (e *entryStringByteChan) tryExpungeLocked() (isExpunged bool) {
 	}
 	return p == expungedStringByteChan
 }
+
+// zeroValueStringByteChan is the zero value of the StringByteChan values, returned when no value is present.
+// It must not be modified.
+var zeroValueStringByteChan (chan []byte)
@@ -1,4 +1,5 @@
 // Code generated by syncmap; DO NOT EDIT.
+// syncmap -name stringerMap "map[string]interface{ String() string }"
 
 // Copyright 2016 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
@@ -12,21 +13,21 @@ import (
 	"unsafe"
 )
 
-// Map is like a Go map[interface{}]interface{} but is safe for concurrent use
+// stringerMap is like a Go map[string]interface{String() string} but is safe for concurrent use
 // by multiple goroutines without additional locking or coordination.
 // Loads, stores, and deletes run in amortized constant time.
 //
-// The Map type is specialized. Most code should use a plain Go map instead,
+// The stringerMap type is specialized. Most code should use a plain Go map instead,
 // with separate locking or coordination, for better type safety and to make it
 // easier to maintain other invariants along with the map content.
 //
-// The Map type is optimized for two common use cases: (1) when the entry for a given
+// The stringerMap type is optimized for two common use cases: (1) when the entry for a given
 // key is only ever written once but read many times, as in caches that only grow,
 // or (2) when multiple goroutines read, write, and overwrite entries for disjoint
-// sets of keys. In these two cases, use of a Map may significantly reduce lock
+// sets of keys. In these two cases, use of a stringerMap may significantly reduce lock
 // contention compared to a Go map paired with a separate Mutex or RWMutex.
 //
-// The zero Map is empty and ready for use. A Map must not be copied after first use.
+// The zero stringerMap is empty and ready for use. A stringerMap must not be copied after first use.
 type stringerMap struct {
 	mu sync.Mutex
 
@@ -70,7 +71,11 @@ type readOnlyStringerMap struct {
 
 // expunged is an arbitrary pointer that marks entries which have been deleted
 // from the dirty map.
-var expungedStringerMap = unsafe.Pointer(new(interface{ String() string }))
+//
+// It is only compared by pointer identity with the entry pointers, and never
+// dereferenced. It points to a byte rather than to a value of the map, as values
+// of zero size (e.g. struct{}) may share the same address.
+var expungedStringerMap = unsafe.Pointer(new(byte))
 
 // An entry is a slot in the map corresponding to a particular key.
 type entryStringerMap struct {
@@ -122,7 +127,7 @@ func (m *stringerMap) Load(key string) (value interface{ String() string }, ok b
 		m.mu.Unlock()
 	}
 	if !ok {
-		return value, false
+		return zeroValueStringerMap, false
 	}
 	return e.load()
 }
@@ -130,7 +135,7 @@ func (m *stringerMap) Load(key string) (value interface{ String() string }, ok b
 func (e *entryStringerMap) load() (value interface{ String() string }, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == nil || p == expungedStringerMap {
-		return value, false
+		return zeroValueStringerMap, false
 	}
 	return *(*interface{ String() string })(p), true
 }
@@ -242,7 +247,7 @@ func (m *stringerMap) LoadOrStore(key string, value interface{ String() string }
 func (e *entryStringerMap) tryLoadOrStore(i interface{ String() string }) (actual interface{ String() string }, loaded, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == expungedStringerMap {
-		return actual, false, false
+		return zeroValueStringerMap, false, false
 	}
 	if p != nil {
 		return *(*interface{ String() string })(p), true, true
@@ -258,7 +263,7 @@ func (e *entryStringerMap) tryLoadOrStore(i interface{ String() string }) (actua
 		}
 		p = atomic.LoadPointer(&e.p)
 		if p == expungedStringerMap {
-			return actual, false, false
+			return zeroValueStringerMap, false, false
 		}
 		if p != nil {
 			return *(*interface{ String() string })(p), true, true
@@ -288,7 +293,7 @@ func (m *stringerMap) LoadAndDelete(key string) (value interface{ String() strin
 	if ok {
 		return e.delete()
 	}
-	return value, false
+	return zeroValueStringerMap, false
 }
 
 // Delete deletes the value for a key.
@@ -300,7 +305,7 @@ func (e *entryStringerMap) delete() (value interface{ String() string }, ok bool
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expungedStringerMap {
-			return value, false
+			return zeroValueStringerMap, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
 			return *(*interface{ String() string })(p), true
@@ -318,6 +323,9 @@ func (e *entryStringerMap) delete() (value interface{ String() string }, ok bool
 //
 // Range may be O(N) with the number of elements in the map even if f returns
 // false after a constant number of calls.
+//
+// Range does not block other methods on the receiver; even f itself may call any
+// method on m.
 func (m *stringerMap) Range(f func(key string, value interface{ String() string }) bool) {
 	// We need to be able to iterate over all of the keys that were already
 	// present at the start of the call to Range.
@@ -385,3 +393,7 @@ func (e *entryStringerMap) tryExpungeLocked() (isExpunged bool) {
 	}
 	return p == expungedStringerMap
 }
+
+// zeroValueStringerMap is the zero value of the stringerMap values, returned when no value is present.
+// It must not be modified.
+var zeroValueStringerMap interface{ String() string }
@@ -1,4 +1,5 @@
 // Code generated by syncmap; DO NOT EDIT.
+// syncmap -name StringIntChan "map[string](chan int)"
 
 // Copyright 2016 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
@@ -12,21 +13,21 @@ import (
 	"unsafe"
 )
 
-// Map is like a Go map[interface{}]interface{} but is safe for concurrent use
+// StringIntChan is like a Go map[string](chan int) but is safe for concurrent use
 // by multiple goroutines without additional locking or coordination.
 // Loads, stores, and deletes run in amortized constant time.
 //
-// The Map type is specialized. Most code should use a plain Go map instead,
+// The StringIntChan type is specialized. Most code should use a plain Go map instead,
 // with separate locking or coordination, for better type safety and to make it
 // easier to maintain other invariants along with the map content.
 //
-// The Map type is optimized for two common use cases: (1) when the entry for a given
+// The StringIntChan type is optimized for two common use cases: (1) when the entry for a given
 // key is only ever written once but read many times, as in caches that only grow,
 // or (2) when multiple goroutines read, write, and overwrite entries for disjoint
-// sets of keys. In these two cases, use of a Map may significantly reduce lock
+// sets of keys. In these two cases, use of a StringIntChan may significantly reduce lock
 // contention compared to a Go map paired with a separate Mutex or RWMutex.
 //
-// The zero Map is empty and ready for use. A Map must not be copied after first use.
+// The zero StringIntChan is empty and ready for use. A StringIntChan must not be copied after first use.
 type StringIntChan struct {
 	mu sync.Mutex
 
@@ -70,7 +71,11 @@ type readOnlyStringIntChan struct {
 
 // expunged is an arbitrary pointer that marks entries which have been deleted
 // from the dirty map.
-var expungedStringIntChan = unsafe.Pointer(new((chan int)))
+//
+// It is only compared by pointer identity with the entry pointers, and never
+// dereferenced. It points to a byte rather than to a value of the map, as values
+// of zero size (e.g. struct{}) may share the same address.
+var expungedStringIntChan = unsafe.Pointer(new(byte))
 
 // An entry is a slot in the map corresponding to a particular key.
 type entryStringIntChan struct {
@@ -122,7 +127,7 @@ func (m *StringIntChan) Load(key string) (value chan int, ok bool) {
 		m.mu.Unlock()
 	}
 	if !ok {
-		return value, false
+		return zeroValueStringIntChan, false
 	}
 	return e.load()
 }
@@ -130,7 +135,7 @@ func (m *StringIntChan) Load(key string) (value chan int, ok bool) {
 func (e *entryStringIntChan) load() (value chan int, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == nil || p == expungedStringIntChan {
-		return value, false
+		return zeroValueStringIntChan, false
 	}
 	return *(*(chan int))(p), true
 }
@@ -246,7 +251,7 @@ func (m *StringIntChan) LoadOrStore(key string, value chan int) (actual chan int
 func (e *entryStringIntChan) tryLoadOrStore(i chan int) (actual chan int, loaded, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == expungedStringIntChan {
-		return actual, false, false
+		return zeroValueStringIntChan, false, false
 	}
 	if p != nil {
 		return *(*(chan int))(p), true, true
@@ -262,7 +267,7 @@ func (e *entryStringIntChan) tryLoadOrStore(i chan int) (actual chan int, loaded
 		}
 		p = atomic.LoadPointer(&e.p)
 		if p == expungedStringIntChan {
-			return actual, false, false
+			return zeroValueStringIntChan, false, false
 		}
 		if p != nil {
 			return *(*(chan int))(p), true, true
@@ -292,7 +297,7 @@ func (m *StringIntChan) LoadAndDelete(key string) (value chan int, loaded bool)
 	if ok {
 		return e.delete()
 	}
-	return value, false
+	return zeroValueStringIntChan, false
 }
 
 // Delete deletes the value for a key.
@@ -304,7 +309,7 @@ func (e *entryStringIntChan) delete() (value chan int, ok bool) {
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expungedStringIntChan {
-			return value, false
+			return zeroValueStringIntChan, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
 			return *(*(chan int))(p), true
@@ -322,6 +327,9 @@ func (e *entryStringIntChan) delete() (value chan int, ok bool) {
 //
 // Range may be O(N) with the number of elements in the map even if f returns
 // false after a constant number of calls.
+//
+// Range does not block other methods on the receiver; even f itself may call any
+// method on m.
 func (m *StringIntChan) Range(f func(key string, value chan int) bool) {
 	// We need to be able to iterate over all of the keys that were already
 	// present at the start of the call to Range.
@@ -389,3 +397,7 @@ func (e *entryStringIntChan) tryExpungeLocked() (isExpunged bool) {
 	}
 	return p == expungedStringIntChan
 }
+
+// zeroValueStringIntChan is the zero value of the StringIntChan values, returned when no value is present.
+// It must not be modified.
+var zeroValueStringIntChan (chan int)
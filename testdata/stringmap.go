@@ -1,4 +1,5 @@
 // Code generated by syncmap; DO NOT EDIT.
+// syncmap -name StringMap map[string]interface{}
 
 // Copyright 2016 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
@@ -12,21 +13,21 @@ import (
 	"unsafe"
 )
 
-// Map is like a Go map[interface{}]interface{} but is safe for concurrent use
+// StringMap is like a Go map[string]interface{} but is safe for concurrent use
 // by multiple goroutines without additional locking or coordination.
 // Loads, stores, and deletes run in amortized constant time.
 //
-// The Map type is specialized. Most code should use a plain Go map instead,
+// The StringMap type is specialized. Most code should use a plain Go map instead,
 // with separate locking or coordination, for better type safety and to make it
 // easier to maintain other invariants along with the map content.
 //
-// The Map type is optimized for two common use cases: (1) when the entry for a given
+// The StringMap type is optimized for two common use cases: (1) when the entry for a given
 // key is only ever written once but read many times, as in caches that only grow,
 // or (2) when multiple goroutines read, write, and overwrite entries for disjoint
-// sets of keys. In these two cases, use of a Map may significantly reduce lock
+// sets of keys. In these two cases, use of a StringMap may significantly reduce lock
 // contention compared to a Go map paired with a separate Mutex or RWMutex.
 //
-// The zero Map is empty and ready for use. A Map must not be copied after first use.
+// The zero StringMap is empty and ready for use. A StringMap must not be copied after first use.
 type StringMap struct {
 	mu sync.Mutex
 
@@ -70,7 +71,11 @@ type readOnlyStringMap struct {
 
 // expunged is an arbitrary pointer that marks entries which have been deleted
 // from the dirty map.
-var expungedStringMap = unsafe.Pointer(new(interface{}))
+//
+// It is only compared by pointer identity with the entry pointers, and never
+// dereferenced. It points to a byte rather than to a value of the map, as values
+// of zero size (e.g. struct{}) may share the same address.
+var expungedStringMap = unsafe.Pointer(new(byte))
 
 // An entry is a slot in the map corresponding to a particular key.
 type entryStringMap struct {
@@ -122,7 +127,7 @@ func (m *StringMap) Load(key string) (value interface{}, ok bool) {
 		m.mu.Unlock()
 	}
 	if !ok {
-		return value, false
+		return zeroValueStringMap, false
 	}
 	return e.load()
 }
@@ -130,7 +135,7 @@ func (m *StringMap) Load(key string) (value interface{}, ok bool) {
 func (e *entryStringMap) load() (value interface{}, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == nil || p == expungedStringMap {
-		return value, false
+		return zeroValueStringMap, false
 	}
 	return *(*interface{})(p), true
 }
@@ -242,7 +247,7 @@ func (m *StringMap) LoadOrStore(key string, value interface{}) (actual interface
 func (e *entryStringMap) tryLoadOrStore(i interface{}) (actual interface{}, loaded, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == expungedStringMap {
-		return actual, false, false
+		return zeroValueStringMap, false, false
 	}
 	if p != nil {
 		return *(*interface{})(p), true, true
@@ -258,7 +263,7 @@ func (e *entryStringMap) tryLoadOrStore(i interface{}) (actual interface{}, load
 		}
 		p = atomic.LoadPointer(&e.p)
 		if p == expungedStringMap {
-			return actual, false, false
+			return zeroValueStringMap, false, false
 		}
 		if p != nil {
 			return *(*interface{})(p), true, true
@@ -288,7 +293,7 @@ func (m *StringMap) LoadAndDelete(key string) (value interface{}, loaded bool) {
 	if ok {
 		return e.delete()
 	}
-	return value, false
+	return zeroValueStringMap, false
 }
 
 // Delete deletes the value for a key.
@@ -300,7 +305,7 @@ func (e *entryStringMap) delete() (value interface{}, ok bool) {
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expungedStringMap {
-			return value, false
+			return zeroValueStringMap, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
 			return *(*interface{})(p), true
@@ -318,6 +323,9 @@ func (e *entryStringMap) delete() (value interface{}, ok bool) {
 //
 // Range may be O(N) with the number of elements in the map even if f returns
 // false after a constant number of calls.
+//
+// Range does not block other methods on the receiver; even f itself may call any
+// method on m.
 func (m *StringMap) Range(f func(key string, value interface{}) bool) {
 	// We need to be able to iterate over all of the keys that were already
 	// present at the start of the call to Range.
@@ -385,3 +393,7 @@ func (e *entryStringMap) tryExpungeLocked() (isExpunged bool) {
 	}
 	return p == expungedStringMap
 }
+
+// zeroValueStringMap is the zero value of the StringMap values, returned when no value is present.
+// It must not be modified.
+var zeroValueStringMap interface{}
@@ -1,4 +1,5 @@
 // Code generated by syncmap; DO NOT EDIT.
+// syncmap -name StructMap "map[struct{ Name string }]struct{ Age int }"
 
 // Copyright 2016 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
@@ -12,21 +13,21 @@ import (
 	"unsafe"
 )
 
-// Map is like a Go map[interface{}]interface{} but is safe for concurrent use
+// StructMap is like a Go map[struct{Name string}]struct{Age int} but is safe for concurrent use
 // by multiple goroutines without additional locking or coordination.
 // Loads, stores, and deletes run in amortized constant time.
 //
-// The Map type is specialized. Most code should use a plain Go map instead,
+// The StructMap type is specialized. Most code should use a plain Go map instead,
 // with separate locking or coordination, for better type safety and to make it
 // easier to maintain other invariants along with the map content.
 //
-// The Map type is optimized for two common use cases: (1) when the entry for a given
+// The StructMap type is optimized for two common use cases: (1) when the entry for a given
 // key is only ever written once but read many times, as in caches that only grow,
 // or (2) when multiple goroutines read, write, and overwrite entries for disjoint
-// sets of keys. In these two cases, use of a Map may significantly reduce lock
+// sets of keys. In these two cases, use of a StructMap may significantly reduce lock
 // contention compared to a Go map paired with a separate Mutex or RWMutex.
 //
-// The zero Map is empty and ready for use. A Map must not be copied after first use.
+// The zero StructMap is empty and ready for use. A StructMap must not be copied after first use.
 type StructMap struct {
 	mu sync.Mutex
 
@@ -70,7 +71,11 @@ type readOnlyStructMap struct {
 
 // expunged is an arbitrary pointer that marks entries which have been deleted
 // from the dirty map.
-var expungedStructMap = unsafe.Pointer(new(struct{ Age int }))
+//
+// It is only compared by pointer identity with the entry pointers, and never
+// dereferenced. It points to a byte rather than to a value of the map, as values
+// of zero size (e.g. struct{}) may share the same address.
+var expungedStructMap = unsafe.Pointer(new(byte))
 
 // An entry is a slot in the map corresponding to a particular key.
 type entryStructMap struct {
@@ -122,7 +127,7 @@ func (m *StructMap) Load(key struct{ Name string }) (value struct{ Age int }, ok
 		m.mu.Unlock()
 	}
 	if !ok {
-		return value, false
+		return zeroValueStructMap, false
 	}
 	return e.load()
 }
@@ -130,7 +135,7 @@ func (m *StructMap) Load(key struct{ Name string }) (value struct{ Age int }, ok
 func (e *entryStructMap) load() (value struct{ Age int }, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == nil || p == expungedStructMap {
-		return value, false
+		return zeroValueStructMap, false
 	}
 	return *(*struct{ Age int })(p), true
 }
@@ -242,7 +247,7 @@ func (m *StructMap) LoadOrStore(key struct{ Name string }, value struct{ Age int
 func (e *entryStructMap) tryLoadOrStore(i struct{ Age int }) (actual struct{ Age int }, loaded, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == expungedStructMap {
-		return actual, false, false
+		return zeroValueStructMap, false, false
 	}
 	if p != nil {
 		return *(*struct{ Age int })(p), true, true
@@ -258,7 +263,7 @@ func (e *entryStructMap) tryLoadOrStore(i struct{ Age int }) (actual struct{ Age
 		}
 		p = atomic.LoadPointer(&e.p)
 		if p == expungedStructMap {
-			return actual, false, false
+			return zeroValueStructMap, false, false
 		}
 		if p != nil {
 			return *(*struct{ Age int })(p), true, true
@@ -288,7 +293,7 @@ func (m *StructMap) LoadAndDelete(key struct{ Name string }) (value struct{ Age
 	if ok {
 		return e.delete()
 	}
-	return value, false
+	return zeroValueStructMap, false
 }
 
 // Delete deletes the value for a key.
@@ -300,7 +305,7 @@ func (e *entryStructMap) delete() (value struct{ Age int }, ok bool) {
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expungedStructMap {
-			return value, false
+			return zeroValueStructMap, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
 			return *(*struct{ Age int })(p), true
@@ -318,6 +323,9 @@ func (e *entryStructMap) delete() (value struct{ Age int }, ok bool) {
 //
 // Range may be O(N) with the number of elements in the map even if f returns
 // false after a constant number of calls.
+//
+// Range does not block other methods on the receiver; even f itself may call any
+// method on m.
 func (m *StructMap) Range(f func(key struct{ Name string }, value struct{ Age int }) bool) {
 	// We need to be able to iterate over all of the keys that were already
 	// present at the start of the call to Range.
@@ -385,3 +393,7 @@ func (e *entryStructMap) tryExpungeLocked() (isExpunged bool) {
 	}
 	return p == expungedStructMap
 }
+
+// zeroValueStructMap is the zero value of the StructMap values, returned when no value is present.
+// It must not be modified.
+var zeroValueStructMap struct{ Age int }
@@ -1,4 +1,5 @@
 // Code generated by syncmap; DO NOT EDIT.
+// syncmap -name WriterMap map[string]io.Writer
 
 // Copyright 2016 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
@@ -13,21 +14,21 @@ import (
 	"unsafe"
 )
 
-// Map is like a Go map[interface{}]interface{} but is safe for concurrent use
+// WriterMap is like a Go map[string]io.Writer but is safe for concurrent use
 // by multiple goroutines without additional locking or coordination.
 // Loads, stores, and deletes run in amortized constant time.
 //
-// The Map type is specialized. Most code should use a plain Go map instead,
+// The WriterMap type is specialized. Most code should use a plain Go map instead,
 // with separate locking or coordination, for better type safety and to make it
 // easier to maintain other invariants along with the map content.
 //
-// The Map type is optimized for two common use cases: (1) when the entry for a given
+// The WriterMap type is optimized for two common use cases: (1) when the entry for a given
 // key is only ever written once but read many times, as in caches that only grow,
 // or (2) when multiple goroutines read, write, and overwrite entries for disjoint
-// sets of keys. In these two cases, use of a Map may significantly reduce lock
+// sets of keys. In these two cases, use of a WriterMap may significantly reduce lock
 // contention compared to a Go map paired with a separate Mutex or RWMutex.
 //
-// The zero Map is empty and ready for use. A Map must not be copied after first use.
+// The zero WriterMap is empty and ready for use. A WriterMap must not be copied after first use.
 type WriterMap struct {
 	mu sync.Mutex
 
@@ -71,7 +72,11 @@ type readOnlyWriterMap struct {
 
 // expunged is an arbitrary pointer that marks entries which have been deleted
 // from the dirty map.
-var expungedWriterMap = unsafe.Pointer(new(io.Writer))
+//
+// It is only compared by pointer identity with the entry pointers, and never
+// dereferenced. It points to a byte rather than to a value of the map, as values
+// of zero size (e.g. struct{}) may share the same address.
+var expungedWriterMap = unsafe.Pointer(new(byte))
 
 // An entry is a slot in the map corresponding to a particular key.
 type entryWriterMap struct {
@@ -123,7 +128,7 @@ func (m *WriterMap) Load(key string) (value io.Writer, ok bool) {
 		m.mu.Unlock()
 	}
 	if !ok {
-		return value, false
+		return zeroValueWriterMap, false
 	}
 	return e.load()
 }
@@ -131,7 +136,7 @@ func (m *WriterMap) Load(key string) (value io.Writer, ok bool) {
 func (e *entryWriterMap) load() (value io.Writer, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == nil || p == expungedWriterMap {
-		return value, false
+		return zeroValueWriterMap, false
 	}
 	return *(*io.Writer)(p), true
 }
@@ -243,7 +248,7 @@ func (m *WriterMap) LoadOrStore(key string, value io.Writer) (actual io.Writer,
 func (e *entryWriterMap) tryLoadOrStore(i io.Writer) (actual io.Writer, loaded, ok bool) {
 	p := atomic.LoadPointer(&e.p)
 	if p == expungedWriterMap {
-		return actual, false, false
+		return zeroValueWriterMap, false, false
 	}
 	if p != nil {
 		return *(*io.Writer)(p), true, true
@@ -259,7 +264,7 @@ func (e *entryWriterMap) tryLoadOrStore(i io.Writer) (actual io.Writer, loaded,
 		}
 		p = atomic.LoadPointer(&e.p)
 		if p == expungedWriterMap {
-			return actual, false, false
+			return zeroValueWriterMap, false, false
 		}
 		if p != nil {
 			return *(*io.Writer)(p), true, true
@@ -289,7 +294,7 @@ func (m *WriterMap) LoadAndDelete(key string) (value io.Writer, loaded bool) {
 	if ok {
 		return e.delete()
 	}
-	return value, false
+	return zeroValueWriterMap, false
 }
 
 // Delete deletes the value for a key.
@@ -301,7 +306,7 @@ func (e *entryWriterMap) delete() (value io.Writer, ok bool) {
 	for {
 		p := atomic.LoadPointer(&e.p)
 		if p == nil || p == expungedWriterMap {
-			return value, false
+			return zeroValueWriterMap, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
 			return *(*io.Writer)(p), true
@@ -319,6 +324,9 @@ func (e *entryWriterMap) delete() (value io.Writer, ok bool) {
 //
 // Range may be O(N) with the number of elements in the map even if f returns
 // false after a constant number of calls.
+//
+// Range does not block other methods on the receiver; even f itself may call any
+// method on m.
 func (m *WriterMap) Range(f func(key string, value io.Writer) bool) {
 	// We need to be able to iterate over all of the keys that were already
 	// present at the start of the call to Range.
@@ -386,3 +394,7 @@ func (e *entryWriterMap) tryExpungeLocked() (isExpunged bool) {
 	}
 	return p == expungedWriterMap
 }
+
+// zeroValueWriterMap is the zero value of the WriterMap values, returned when no value is present.
+// It must not be modified.
+var zeroValueWriterMap io.Writer
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"sort"
+)
+
+// addTrace declares the trace hook of the map, and calls it at the entry of the exported
+// methods of the map with the name of the method, and the key it gets, if any. The hook
+// is a package variable, which is nil by default, and the methods load and check it
+// before they call it. The helpers are added afterwards, and their calls of the map
+// methods are traced instead.
+func (g *Generator) addTrace() {
+	hook := g.traceVar()
+	var ops []string
+	for _, d := range g.file.Decls {
+		f, ok := d.(*ast.FuncDecl)
+		if !ok || !g.isMethod(f) || !f.Name.IsExported() {
+			continue
+		}
+		key := "nil"
+		if ps := f.Type.Params.List; len(ps) > 0 && len(ps[0].Names) > 0 && g.isKeyType(ps[0].Type) {
+			key = ps[0].Names[0].Name
+		}
+		prepend(f, fmt.Sprintf(`if trace := %s; trace != nil {
+			trace(%q, %s)
+		}`, hook, f.Name.Name, key))
+		ops = append(ops, f.Name.Name)
+	}
+	expect(ops != nil, "trace: %s has no exported methods", g.Name)
+	sort.Strings(ops)
+	g.logf("tracing the methods of %s: %v", g.Name, ops)
+	g.appendSource([]byte(fmt.Sprintf(`
+// %[1]s is called at the entry of the methods of %[2]s with the name of the method (op),
+// and the key it gets. The key is nil for the methods that get no key, e.g. Range. It is
+// nil by default, and it must be set before the maps are used, e.g. in an init function
+// of the tests, as it is not synchronized with the calls.
+var %[1]s func(op string, key interface{})
+`, hook, g.Name)))
+}
+
+// traceVar returns the name of the trace hook generated by -trace.
+func (g *Generator) traceVar() string {
+	return g.Name + "Trace"
+}
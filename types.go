@@ -0,0 +1,398 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// underlying returns the underlying type expression of x. Parentheses are removed, and
+// identifiers are resolved using the types declared in the package of the generated file.
+// Types that can not be resolved (e.g. types from other packages) are returned as is.
+func (g *Generator) underlying(x ast.Expr) ast.Expr {
+	seen := make(map[string]bool)
+	for {
+		switch t := x.(type) {
+		case *ast.ParenExpr:
+			x = t.X
+		case *ast.Ident:
+			if g.typeParam(t.Name) != nil {
+				return x
+			}
+			decl, ok := g.localTypes()[t.Name]
+			if !ok || seen[t.Name] {
+				return x
+			}
+			seen[t.Name] = true
+			x = decl
+		default:
+			return x
+		}
+	}
+}
+
+// localTypes returns the types declared in the package of the generated file, keyed by
+// their names.
+func (g *Generator) localTypes() map[string]ast.Expr {
+	g.parsePackage()
+	return g.locals
+}
+
+// declared returns the top-level identifiers declared in the package of the generated file,
+// mapped to the files declaring them.
+func (g *Generator) declared() map[string]string {
+	g.parsePackage()
+	return g.decls
+}
+
+// localMethods returns the methods declared in the package of the generated file for the
+// given type, mapped to whether their receiver is a pointer.
+func (g *Generator) localMethods(name string) map[string]bool {
+	g.parsePackage()
+	return g.recvs[name]
+}
+
+// pkgImports returns the imports of the package of the generated file, keyed by their
+// names. If different files import different paths using the same name, the first one is
+// used.
+func (g *Generator) pkgImports() map[string]string {
+	g.parsePackage()
+	return g.imports
+}
+
+// parsePackage parses the package of the generated file, excluding the generated file
+// itself and test files, unless the package is an external test package. Files that can
+// not be parsed are ignored.
+func (g *Generator) parsePackage() {
+	if g.locals != nil {
+		return
+	}
+	g.locals = make(map[string]ast.Expr)
+	g.decls = make(map[string]string)
+	g.recvs = make(map[string]map[string]bool)
+	g.imports = make(map[string]string)
+	paths, _ := filepath.Glob(filepath.Join(filepath.Dir(g.Out), "*.go"))
+	fset := token.NewFileSet()
+	for _, path := range paths {
+		if filepath.Base(path) == filepath.Base(g.Out) || strings.HasSuffix(path, "_test.go") && !g.testPkg() {
+			continue
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil || f.Name.Name != g.Pkg {
+			continue
+		}
+		for _, spec := range f.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				continue
+			}
+			name := importName(path)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			if _, ok := g.imports[name]; !ok {
+				g.imports[name] = path
+			}
+		}
+		for _, d := range f.Decls {
+			switch d := d.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					g.decls[d.Name.Name] = path
+					break
+				}
+				recv := d.Recv.List[0].Type
+				star, ptr := recv.(*ast.StarExpr)
+				if ptr {
+					recv = star.X
+				}
+				switch t := recv.(type) {
+				case *ast.IndexExpr:
+					recv = t.X
+				case *ast.IndexListExpr:
+					recv = t.X
+				}
+				if id, ok := recv.(*ast.Ident); ok {
+					if g.recvs[id.Name] == nil {
+						g.recvs[id.Name] = make(map[string]bool)
+					}
+					g.recvs[id.Name][d.Name.Name] = ptr
+				}
+			case *ast.GenDecl:
+				for _, s := range d.Specs {
+					switch s := s.(type) {
+					case *ast.TypeSpec:
+						g.locals[s.Name.Name] = s.Type
+						g.decls[s.Name.Name] = path
+					case *ast.ValueSpec:
+						for _, n := range s.Names {
+							g.decls[n.Name] = path
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// syncLocks holds the types of the sync package that must not be copied after first use.
+var syncLocks = map[string]bool{"Mutex": true, "RWMutex": true, "Map": true, "WaitGroup": true, "Once": true, "Cond": true, "Pool": true}
+
+// lockPath returns the lock contained by value in the given type expression (e.g.
+// "sync.Mutex"), or an empty string if there is none. Locks in types from other packages
+// than sync are not detected.
+func (g *Generator) lockPath(x ast.Expr) string {
+	seen := make(map[ast.Expr]bool)
+	var path func(x ast.Expr) string
+	path = func(x ast.Expr) string {
+		x = g.underlying(x)
+		if seen[x] {
+			return ""
+		}
+		seen[x] = true
+		switch t := x.(type) {
+		case *ast.SelectorExpr:
+			if id, ok := t.X.(*ast.Ident); ok && id.Name == "sync" && syncLocks[t.Sel.Name] {
+				return "sync." + t.Sel.Name
+			}
+		case *ast.ArrayType:
+			if t.Len != nil {
+				return path(t.Elt)
+			}
+		case *ast.StructType:
+			for _, f := range t.Fields.List {
+				if p := path(f.Type); p != "" {
+					return p
+				}
+			}
+		}
+		return ""
+	}
+	return path(x)
+}
+
+// comparable reports whether values of the given type expression can be compared with ==.
+// Types from other packages are assumed to be comparable.
+func (g *Generator) comparable(x ast.Expr) bool {
+	seen := make(map[ast.Expr]bool)
+	var ok func(x ast.Expr) bool
+	ok = func(x ast.Expr) bool {
+		x = g.underlying(x)
+		if seen[x] {
+			return true
+		}
+		seen[x] = true
+		switch t := x.(type) {
+		case *ast.Ident:
+			if p := g.typeParam(t.Name); p != nil {
+				return !anyConstraint(p.constraint)
+			}
+		case *ast.FuncType, *ast.MapType:
+			return false
+		case *ast.ArrayType:
+			return t.Len != nil && ok(t.Elt)
+		case *ast.StructType:
+			for _, f := range t.Fields.List {
+				if !ok(f.Type) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return ok(x)
+}
+
+// importSpec is an import of the generated file.
+type importSpec struct {
+	name string // empty if the name is the last element of the path.
+	path string
+}
+
+func (s importSpec) String() string {
+	if s.name == "" {
+		return strconv.Quote(s.path)
+	}
+	return s.name + " " + strconv.Quote(s.path)
+}
+
+// addTypeImports adds the imports of the packages referenced by the key and value types,
+// by -mutex-type, and by the constraints of -generic, e.g. user constraint interfaces.
+func (g *Generator) addTypeImports() {
+	for _, s := range g.typeImports() {
+		g.logf("adding import %s for the map types", s)
+		g.addImport(g.file, s.name, s.path)
+	}
+	if g.mutexType != nil {
+		for _, s := range g.importsOf(g.mutexType) {
+			g.logf("adding import %s for the mutex type", s)
+			g.addImport(g.file, s.name, s.path)
+		}
+	}
+	for _, s := range g.importsOf(g.constraints...) {
+		g.logf("adding import %s for the constraints", s)
+		g.addImport(g.file, s.name, s.path)
+	}
+}
+
+// addImport adds an import to the file, unless it is already imported. The name is empty
+// for imports that are named by the last element of their path.
+func (g *Generator) addImport(f *ast.File, name, path string) {
+	if astutil.AddNamedImport(g.fset, f, name, path) {
+		g.stats.Imports++
+	}
+}
+
+// fixStdImports adds the imports of the standard packages that are used by the code of
+// sync/map.go and by the options, and deletes the ones that are not used anymore, e.g.
+// unsafe after -valueinline. Hence, the generated code compiles without goimports, e.g.
+// with a Format hook that does not resolve the imports.
+func (g *Generator) fixStdImports() {
+	imported := make(map[string]string) // names of the imports, mapped to their paths.
+	for _, s := range g.file.Imports {
+		path, err := strconv.Unquote(s.Path.Value)
+		check(err, "unquote import path %s", s.Path.Value)
+		name := importName(path)
+		if s.Name != nil {
+			name = s.Name.Name
+		}
+		imported[name] = path
+	}
+	for _, path := range []string{"sync", "sync/atomic", "unsafe"} {
+		name := importName(path)
+		switch p, ok := imported[name]; {
+		case ok && p != path:
+			// The name refers to another package, e.g. of the map types.
+		case usesPackage(g.file, name):
+			g.addImport(g.file, "", path)
+		case ok:
+			g.logf("deleting unused import %q", path)
+			astutil.DeleteImport(g.fset, g.file, path)
+		}
+	}
+}
+
+// usesPackage reports whether the file has a selector of the given package name, that is
+// not resolved to a declaration of the file.
+func usesPackage(f *ast.File, name string) (used bool) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		if s, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := s.X.(*ast.Ident); ok && id.Name == name && id.Obj == nil {
+				used = true
+			}
+		}
+		return !used
+	})
+	return
+}
+
+// typeImports returns the imports of the packages referenced by the key and value types.
+// The import paths are taken from the other files in the output package, and packages
+// that are not imported there are left for goimports to resolve. With -packages, they
+// are resolved by resolveImports.
+func (g *Generator) typeImports() []importSpec {
+	return g.importsOf(g.mapType.Key, g.mapType.Value)
+}
+
+// importsOf returns the imports of the packages referenced by the given type expressions.
+// See typeImports.
+func (g *Generator) importsOf(xs ...ast.Expr) []importSpec {
+	if g.Packages {
+		return g.resolveImports(xs)
+	}
+	var names []string
+	for _, x := range xs {
+		names = append(names, qualifiers(x)...)
+	}
+	var specs []importSpec
+	for _, name := range names {
+		path, ok := g.pkgImports()[name]
+		switch {
+		case !ok:
+		case name == importName(path):
+			specs = append(specs, importSpec{path: path})
+		default:
+			specs = append(specs, importSpec{name: name, path: path})
+		}
+	}
+	return specs
+}
+
+// checkQualifiers fails if a qualified identifier of the given map type can not refer to
+// an exported declaration of an imported package (e.g. "a.b.C", "_.T", "string.Builder"
+// or "user.id"), or if an unqualified identifier may be declared by a dot import of the
+// output package. The imports of the generated file are added by the qualifiers, and
+// goimports can not resolve the dot imports, which are scoped to their files.
+func (g *Generator) checkQualifiers(kind, name string, x ast.Expr) {
+	ast.Inspect(x, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Field:
+			// The names of the fields, the parameters and the methods are not types.
+			g.checkQualifiers(kind, name, n.Type)
+			return false
+		case *ast.SelectorExpr:
+			id, ok := n.X.(*ast.Ident)
+			expect(ok, "%s type %s: %s is not a qualified identifier. expected a package name before the dot, e.g. pkg.Type",
+				kind, name, types.ExprString(n))
+			expect(id.Name != "_", "%s type %s: the blank identifier can not qualify %s. expected a package name", kind, name, n.Sel.Name)
+			expect(types.Universe.Lookup(id.Name) == nil, "%s type %s: %s is a predeclared identifier, not a package name", kind, name, id.Name)
+			_, local := g.declared()[id.Name]
+			expect(!local, "%s type %s: %s is declared in the output package, and is not a package name", kind, name, id.Name)
+			expect(n.Sel.IsExported(), "%s type %s: %s is not exported by package %s", kind, name, types.ExprString(n), id.Name)
+			return false
+		case *ast.Ident:
+			path, ok := g.pkgImports()["."]
+			if !ok || n.Name == "_" || types.Universe.Lookup(n.Name) != nil || g.typeParam(n.Name) != nil {
+				return false
+			}
+			_, local := g.declared()[n.Name]
+			expect(local, "%s type %s: %s is not declared in the output package, and may be declared by its dot import of %q, "+
+				"which is not supported. use a qualified type instead", kind, name, n.Name, path)
+		}
+		return true
+	})
+}
+
+// isKeyType reports whether the type of a parameter is the key type of the map. The types
+// are printed the same way, as g.key is formatted by go/format, which spaces the literal
+// types (e.g. struct{ X, Y int }) differently than go/types.
+func (g *Generator) isKeyType(x ast.Expr) bool {
+	return types.ExprString(x) == types.ExprString(g.mapType.Key)
+}
+
+// qualifiers returns the package names that qualify identifiers in the given type expression
+// (e.g. "user" in "*user.ID"), in order of appearance.
+func qualifiers(x ast.Expr) []string {
+	var names []string
+	ast.Inspect(x, func(n ast.Node) bool {
+		s, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if id, ok := s.X.(*ast.Ident); ok {
+			names = append(names, id.Name)
+		}
+		return false
+	})
+	return names
+}
+
+var majorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+// importName returns the name of an imported package, assuming it is the last element
+// of its path. The major version suffix of a module path is ignored.
+func importName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && majorVersion.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+	return name
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// generatedFile is a file written by the generator, kept for -verify.
+type generatedFile struct {
+	path string
+	src  []byte
+}
+
+// stubTypes returns the types of the output package that are referenced by the key and
+// value types, mapped to their number of type parameters, and the type of -mutex-type,
+// mapped to lockerStub. The throwaway package of verify does not have the other files of
+// the output package, and declares stubs of them instead.
+func (g *Generator) stubTypes() map[string]int {
+	stubs := make(map[string]int)
+	var walk func(n ast.Node) bool
+	walk = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			// Types of other packages are compiled from their sources.
+			return false
+		case *ast.Field:
+			// The names of struct fields and of parameters are not types.
+			ast.Inspect(n.Type, walk)
+			return false
+		case *ast.IndexExpr:
+			if id, ok := n.X.(*ast.Ident); ok {
+				stubs[id.Name] = 1
+			}
+		case *ast.IndexListExpr:
+			if id, ok := n.X.(*ast.Ident); ok {
+				stubs[id.Name] = len(n.Indices)
+			}
+		case *ast.Ident:
+			if _, ok := stubs[n.Name]; !ok {
+				stubs[n.Name] = 0
+			}
+		}
+		return true
+	}
+	ast.Inspect(g.mapType.Key, walk)
+	ast.Inspect(g.mapType.Value, walk)
+	if id, ok := g.mutexType.(*ast.Ident); ok {
+		stubs[id.Name] = lockerStub
+	}
+	for name := range stubs {
+		if _, ok := g.localTypes()[name]; !ok || g.typeParam(name) != nil {
+			delete(stubs, name)
+		}
+	}
+	return stubs
+}
+
+// verify builds and vets the generated files in a throwaway package, and fails with the
+// compile errors, reported at the paths of the generated files. The package is created in
+// the output directory, so that the imports of the generated files are resolved by the
+// module of the output package, or in a temporary module if there is none. The types of
+// the output package that the files reference are declared as stubs of int, and the build
+// constraints of -tags are dropped, so all the files are compiled together.
+func (g *Generator) verify(stubs map[string]int) {
+	dir := filepath.Dir(g.Out)
+	var tmp string
+	var err error
+	if mod, _ := goCmd(dir, "env", "GOMOD"); mod != "" && mod != os.DevNull {
+		tmp, err = ioutil.TempDir(dir, "_syncmap_verify")
+		check(err, "-verify: create package")
+	} else {
+		tmp, err = ioutil.TempDir("", "syncmap_verify")
+		check(err, "-verify: create package")
+		_, err = goCmd(tmp, "mod", "init", "syncmapverify")
+		check(err, "-verify: create module")
+	}
+	defer os.RemoveAll(tmp)
+	names := make(map[string]string)
+	for _, f := range g.generated {
+		names["./"+filepath.Base(f.path)] = f.path
+		err := ioutil.WriteFile(filepath.Join(tmp, filepath.Base(f.path)), dropBuildLines(f.src), 0644)
+		check(err, "-verify: write %s", f.path)
+	}
+	stub := "syncmap_stubs.go"
+	if g.testPkg() {
+		stub = "syncmap_stubs_test.go"
+	}
+	err = ioutil.WriteFile(filepath.Join(tmp, stub), g.stubSource(stubs), 0644)
+	check(err, "-verify: write stubs")
+	cmds := [][]string{{"vet", "."}}
+	if !g.testPkg() {
+		// The files of external test packages are compiled only by vet.
+		cmds = append([][]string{{"build", "-o", os.DevNull, "."}}, cmds...)
+	}
+	for _, args := range cmds {
+		g.logf("verifying the generated code with go %s", strings.Join(args, " "))
+		out, err := goCmd(tmp, args...)
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			expect(false, "-verify: go %s failed:\n%s", args[0], verifyErrors(out, names))
+		}
+		check(err, "-verify: run go %s", args[0])
+	}
+}
+
+// stubSource returns the source of the stubs of verify.
+func (g *Generator) stubSource(stubs map[string]int) []byte {
+	b := bytes.NewBuffer(nil)
+	fmt.Fprintf(b, "package %s\n", g.Pkg)
+	names := make([]string, 0, len(stubs))
+	for name := range stubs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var params []string
+		for i := 0; i < stubs[name]; i++ {
+			params = append(params, fmt.Sprintf("T%d", i))
+		}
+		switch {
+		case stubs[name] == lockerStub:
+			fmt.Fprintf(b, "\ntype %[1]s struct{}\n\nfunc (*%[1]s) Lock() {}\n\nfunc (*%[1]s) Unlock() {}\n", name)
+		case len(params) > 0:
+			fmt.Fprintf(b, "\ntype %s[%s any] int\n", name, strings.Join(params, ", "))
+		default:
+			fmt.Fprintf(b, "\ntype %s int\n", name)
+		}
+	}
+	if g.Pkg == "main" {
+		b.WriteString("\nfunc main() {}\n")
+	}
+	return b.Bytes()
+}
+
+// dropBuildLines replaces the build constraint lines of the source with empty lines, to
+// keep the line numbers of the compile errors.
+func dropBuildLines(src []byte) []byte {
+	lines := bytes.Split(src, []byte("\n"))
+	for i, l := range lines {
+		if s := strings.TrimSpace(string(l)); constraint.IsGoBuild(s) || constraint.IsPlusBuild(s) {
+			lines[i] = nil
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// verifyErrors returns the output of the go command without the package headers, and with
+// the paths of the files in the throwaway package replaced by the paths of the generated
+// files.
+func verifyErrors(out string, names map[string]string) string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
+		l = strings.TrimPrefix(l, "vet: ")
+		for name, path := range names {
+			if strings.HasPrefix(l, name+":") {
+				l = path + strings.TrimPrefix(l, name)
+			}
+		}
+		lines = append(lines, l)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// goCmd runs the go command with the given arguments in dir, and returns its combined
+// output.
+func goCmd(dir string, args ...string) (string, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
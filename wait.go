@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"text/template"
+)
+
+// addWait appends the LoadOrWait method, that blocks until a key is stored in the map. The
+// waiters are registered by key next to the map, and Store and LoadOrStore wake them after
+// they store a value. The registry is guarded by its own mutex, and the writes check an
+// atomic count of the waiters before they lock it. Hence, the writes of a map that nobody
+// waits on pay only for the load of the count.
+func (g *Generator) addWait() {
+	g.logf("adding import \"context\" for LoadOrWait")
+	g.addImport(g.file, "", "context")
+	g.addImport(g.file, "", "sync")
+	g.addImport(g.file, "", "sync/atomic")
+	var stores int
+	for _, d := range g.file.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			if t, ok := d.Specs[0].(*ast.TypeSpec); ok && t.Name.Name == g.Name {
+				appendFields(t.Type.(*ast.StructType), fmt.Sprintf("waitMu sync.Mutex; waiters map[%s][]chan struct{}; nwaiters int32", g.key))
+			}
+		case *ast.FuncDecl:
+			if !g.isMethod(d) {
+				continue
+			}
+			m := d.Recv.List[0].Names[0].Name
+			switch d.Name.Name {
+			case "Store":
+				key := d.Type.Params.List[0].Names[0].Name
+				prepend(d, fmt.Sprintf(`defer func() {
+					if atomic.LoadInt32(&%[1]s.nwaiters) != 0 {
+						%[1]s.notifyWaiters(%[2]s)
+					}
+				}()`, m, key))
+				stores++
+			case "LoadOrStore":
+				key, loaded := d.Type.Params.List[0].Names[0].Name, lastResult(d)
+				prepend(d, fmt.Sprintf(`defer func() {
+					if !%[3]s && atomic.LoadInt32(&%[1]s.nwaiters) != 0 {
+						%[1]s.notifyWaiters(%[2]s)
+					}
+				}()`, m, key, loaded))
+				stores++
+			}
+		}
+	}
+	expect(stores == 2, "wait: missing Store or LoadOrStore method")
+	b := bytes.NewBuffer(nil)
+	err := waitTmpl.Execute(b, g.helperData())
+	check(err, "execute wait template")
+	g.appendSource(b.Bytes())
+}
+
+var waitTmpl = template.Must(template.New("wait").Parse(`
+// LoadOrWait returns the value stored in the map for a key. If no value is present, it
+// blocks until a value is stored for the key by Store or LoadOrStore, or until ctx is
+// done, in which case it returns the zero value and ctx.Err(). A key that is deleted
+// before LoadOrWait loads it is waited for again.
+func (m *{{.Name}}) LoadOrWait(ctx context.Context, key {{.Key}}) ({{.Value}}, error) {
+	for {
+		if value, ok := m.Load(key); ok {
+			return value, nil
+		}
+		c := make(chan struct{})
+		m.waitMu.Lock()
+		if m.waiters == nil {
+			m.waiters = make(map[{{.Key}}][]chan struct{})
+		}
+		m.waiters[key] = append(m.waiters[key], c)
+		atomic.AddInt32(&m.nwaiters, 1)
+		m.waitMu.Unlock()
+		// The value may have been stored before the waiter was registered, by a
+		// write that did not see it.
+		if value, ok := m.Load(key); ok {
+			m.removeWaiter(key, c)
+			return value, nil
+		}
+		select {
+		case <-c:
+		case <-ctx.Done():
+			m.removeWaiter(key, c)
+			return {{.Zero}}, ctx.Err()
+		}
+	}
+}
+
+// notifyWaiters wakes the goroutines that wait for the key in LoadOrWait.
+func (m *{{.Name}}) notifyWaiters(key {{.Key}}) {
+	m.waitMu.Lock()
+	defer m.waitMu.Unlock()
+	for _, c := range m.waiters[key] {
+		close(c)
+	}
+	atomic.AddInt32(&m.nwaiters, -int32(len(m.waiters[key])))
+	delete(m.waiters, key)
+}
+
+// removeWaiter removes the channel of a goroutine that stopped waiting for the key,
+// unless it was already removed by notifyWaiters.
+func (m *{{.Name}}) removeWaiter(key {{.Key}}, c chan struct{}) {
+	m.waitMu.Lock()
+	defer m.waitMu.Unlock()
+	waiters := m.waiters[key]
+	for i := range waiters {
+		if waiters[i] != c {
+			continue
+		}
+		waiters[i] = waiters[len(waiters)-1]
+		waiters = waiters[:len(waiters)-1]
+		atomic.AddInt32(&m.nwaiters, -1)
+		if len(waiters) == 0 {
+			delete(m.waiters, key)
+		} else {
+			m.waiters[key] = waiters
+		}
+		return
+	}
+}
+`))
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// zeroValue returns the name of the variable that holds the zero value of the map values.
+func (g *Generator) zeroValue() string {
+	return "zeroValue" + strings.Title(g.Name)
+}
+
+// zero returns the expression of the zero value of the map values. Generic maps can not
+// declare the zero value variable, and use *new(V) instead.
+func (g *Generator) zero() string {
+	if g.Generic {
+		return "*new(" + g.value + ")"
+	}
+	return g.zeroValue()
+}
+
+// addZeroValue appends the variable that holds the zero value of the map values. The
+// methods and the helpers that return no value reference it, instead of relying on
+// unassigned named results.
+func (g *Generator) addZeroValue() {
+	g.appendSource([]byte(fmt.Sprintf(`
+// %[1]s is the zero value of the %[2]s values, returned when no value is present.
+// It must not be modified.
+var %[1]s %[3]s
+`, g.zeroValue(), g.Name, g.value)))
+}
+
+// returnZero replaces the nil results of the return statements in n with the zero value
+// variable. It is used for the results of the interface{} values of sync/map.go.
+func (g *Generator) returnZero(n ast.Node) {
+	astutil.Apply(n, func(c *astutil.Cursor) bool {
+		if _, ok := c.Parent().(*ast.ReturnStmt); ok {
+			if i, ok := c.Node().(*ast.Ident); ok && i.Name == new(types.Nil).String() {
+				x, err := parser.ParseExpr(g.zero())
+				check(err, "parse zero value %s", g.zero())
+				setAllPos(x, i.Pos())
+				c.Replace(x)
+			}
+		}
+		return true
+	}, nil)
+}